@@ -2,25 +2,60 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
+	"os"
 	"os/exec"
 	"runtime"
 )
 
+// playerArgs maps native players to the arguments used to open the stream.
+var playerArgs = map[string][]string{
+	"mpv":    {"--profile=low-latency", "--untimed"},
+	"ffplay": {"-fflags", "nobuffer", "-flags", "low_delay"},
+	"vlc":    {"--network-caching=100"},
+}
+
 func main() {
 	url := flag.String("url", "http://localhost:8080/stream", "proxy stream URL")
+	player := flag.String("player", "browser", "how to open the stream: browser, mpv, ffplay or vlc")
 	flag.Parse()
 
+	if *player == "browser" {
+		if err := openBrowser(*url); err != nil {
+			log.Fatalf("open: %v", err)
+		}
+		return
+	}
+
+	args, ok := playerArgs[*player]
+	if !ok {
+		log.Fatalf("unknown player %q (want browser, mpv, ffplay or vlc)", *player)
+	}
+	bin, err := exec.LookPath(*player)
+	if err != nil {
+		log.Fatalf("%s not found on PATH: %v", *player, err)
+	}
+	cmd := exec.Command(bin, append(args, *url)...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		log.Fatalf("%s: %v", *player, err)
+	}
+}
+
+// openBrowser opens url in the system browser without waiting for it.
+func openBrowser(url string) error {
 	var cmd *exec.Cmd
 	switch runtime.GOOS {
 	case "darwin":
-		cmd = exec.Command("open", *url)
+		cmd = exec.Command("open", url)
 	case "linux":
-		cmd = exec.Command("xdg-open", *url)
+		cmd = exec.Command("xdg-open", url)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
 	default:
-		log.Fatalf("unsupported OS: %s", runtime.GOOS)
-	}
-	if err := cmd.Start(); err != nil {
-		log.Fatalf("open: %v", err)
+		return fmt.Errorf("unsupported OS: %s", runtime.GOOS)
 	}
+	return cmd.Start()
 }
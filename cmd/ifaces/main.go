@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"mjpeg-multicast/internal/mcast"
+)
+
+func main() {
+	join := flag.String("join", "", "also try joining this multicast group (e.g. 239.0.0.1) on every up, multicast-capable interface")
+	flag.Parse()
+	if *join != "" {
+		if ip := net.ParseIP(*join); ip == nil || ip.To4() == nil || !ip.IsMulticast() {
+			log.Fatalf("join: %s is not an IPv4 multicast group", *join)
+		}
+	}
+
+	ifs, err := mcast.Interfaces()
+	if err != nil {
+		log.Fatalf("interfaces: %v", err)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	header := "\tNAME\tMTU\tFLAGS\tADDRESSES"
+	if *join != "" {
+		header += "\tJOIN " + *join
+	}
+	fmt.Fprintln(w, header)
+	for _, ifi := range ifs {
+		mark := ""
+		if ifi.Default {
+			mark = "*"
+		}
+		addrs := strings.Join(ifi.Addrs, ",")
+		if addrs == "" {
+			addrs = "-"
+		}
+		line := fmt.Sprintf("%s\t%s\t%d\t%s\t%s", mark, ifi.Name, ifi.MTU, ifi.Flags, addrs)
+		if *join != "" {
+			if !ifi.Up || !ifi.Multicast {
+				line += "\t-"
+			} else if err := mcast.TryJoin(*join, ifi.Name); err != nil {
+				line += "\t" + err.Error()
+			} else {
+				line += "\tok"
+			}
+		}
+		fmt.Fprintln(w, line)
+	}
+	w.Flush()
+	fmt.Println("\n* joined by proxy, record and view when -if is not given")
+}
@@ -1,79 +1,342 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/http/pprof"
 	"os"
 	"os/signal"
+	"sort"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"mjpeg-multicast/internal/frame"
+	"mjpeg-multicast/internal/logutil"
 	"mjpeg-multicast/internal/mcast"
 )
 
 type client struct {
-	ch chan []byte
+	ch        chan []byte
+	addr      string // remote address, for /clients
+	connected time.Time
+	sent      atomic.Uint64
+	dropped   atomic.Uint64 // frames skipped because the client was behind
+	errors    atomic.Uint64 // failed or timed out writes
 }
 
+// clientInfo is one entry of the /clients listing.
+type clientInfo struct {
+	RemoteAddr    string    `json:"remote_addr"`
+	ConnectedAt   time.Time `json:"connected_at"`
+	Seconds       float64   `json:"connected_seconds"`
+	FramesSent    uint64    `json:"frames_sent"`
+	FramesDropped uint64    `json:"frames_dropped"`
+	WriteErrors   uint64    `json:"write_errors"`
+}
+
+// Queue policies: what broadcast does when a client's queue is full.
+const (
+	dropNewest = "drop-newest" // skip the new frame
+	dropOldest = "drop-oldest" // make room by discarding the oldest queued frame
+	coalesce   = "coalesce"    // replace everything queued with the new frame
+)
+
 type hub struct {
-	mu      sync.Mutex
-	clients map[*client]struct{}
+	queue     int    // frames queued per client
+	policy    string // one of the queue policies
+	mu        sync.Mutex
+	clients   map[*client]struct{}
+	lastFrame time.Time // when the last frame was broadcast
+	// the last frame, its ETag and when it last changed, for /snapshot
+	last        []byte
+	lastETag    string
+	lastChanged time.Time
 }
 
 var broadcasted uint64
 
-func newHub() *hub { return &hub{clients: make(map[*client]struct{})} }
+// Bounds of the reader's backoff between rejoins after receive errors.
+const (
+	rxBackoffMin = 500 * time.Millisecond
+	rxBackoffMax = 30 * time.Second
+)
+
+// rejected counts frames -validate-jpeg kept from viewers.
+var rejected atomic.Uint64
+
+// validJPEG reports whether b looks like a whole JPEG: it starts with the
+// SOI marker and ends with EOI. It doesn't decode anything.
+func validJPEG(b []byte) bool {
+	return len(b) >= 4 && b[0] == 0xff && b[1] == 0xd8 && b[len(b)-2] == 0xff && b[len(b)-1] == 0xd9
+}
+
+func newHub(queue int, policy string) (*hub, error) {
+	switch policy {
+	case dropNewest, dropOldest, coalesce:
+	default:
+		return nil, fmt.Errorf("unknown queue policy %q (want %s, %s or %s)", policy, dropNewest, dropOldest, coalesce)
+	}
+	if queue < 1 {
+		return nil, fmt.Errorf("queue must hold at least one frame, got %d", queue)
+	}
+	return &hub{queue: queue, policy: policy, clients: make(map[*client]struct{})}, nil
+}
+
+// newClient returns a client with a queue of the hub's size.
+func (h *hub) newClient(addr string) *client {
+	return &client{ch: make(chan []byte, h.queue), addr: addr, connected: time.Now()}
+}
+
+func (h *hub) add(c *client) { h.mu.Lock(); h.clients[c] = struct{}{}; h.mu.Unlock() }
+func (h *hub) remove(c *client) {
+	h.mu.Lock()
+	if _, ok := h.clients[c]; ok {
+		delete(h.clients, c)
+		close(c.ch)
+	}
+	h.mu.Unlock()
+}
+
+// closeAll disconnects every client; their stream handlers return once they
+// see the closed channel.
+func (h *hub) closeAll() {
+	h.mu.Lock()
+	for c := range h.clients {
+		delete(h.clients, c)
+		close(c.ch)
+	}
+	h.mu.Unlock()
+}
 
-func (h *hub) add(c *client)    { h.mu.Lock(); h.clients[c] = struct{}{}; h.mu.Unlock() }
-func (h *hub) remove(c *client) { h.mu.Lock(); delete(h.clients, c); close(c.ch); h.mu.Unlock() }
 func (h *hub) broadcast(frame []byte) {
+	sum := sha256.Sum256(frame)
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
 	h.mu.Lock()
+	h.lastFrame = time.Now()
+	if etag != h.lastETag {
+		// keepalive resends of the same frame keep its validators
+		h.last, h.lastETag = frame, etag
+		h.lastChanged = h.lastFrame
+	}
 	for c := range h.clients {
+		h.enqueue(c, frame)
+	}
+	h.mu.Unlock()
+}
+
+// enqueue queues frame for c, applying the queue policy when c is behind.
+// h.mu must be held, so nothing else sends to c.ch meanwhile.
+func (h *hub) enqueue(c *client, frame []byte) {
+	select {
+	case c.ch <- frame:
+		return
+	default:
+	}
+	switch h.policy {
+	case dropOldest:
 		select {
-		case c.ch <- frame:
-		default:
-			// slow client, drop
+		case <-c.ch:
+			c.dropped.Add(1)
+		default: // the client caught up meanwhile
 		}
+	case coalesce:
+		// the newest frame is all a viewer that fell behind needs
+		for n := len(c.ch); n > 0; n-- {
+			select {
+			case <-c.ch:
+				c.dropped.Add(1)
+			default:
+			}
+		}
+	default:
+		c.dropped.Add(1)
+		return
+	}
+	select {
+	case c.ch <- frame:
+	default:
+		c.dropped.Add(1)
+	}
+}
+
+// list describes the connected clients, longest connected first.
+func (h *hub) list() []clientInfo {
+	now := time.Now()
+	h.mu.Lock()
+	out := make([]clientInfo, 0, len(h.clients))
+	for c := range h.clients {
+		out = append(out, clientInfo{
+			RemoteAddr:    c.addr,
+			ConnectedAt:   c.connected,
+			Seconds:       now.Sub(c.connected).Round(time.Millisecond).Seconds(),
+			FramesSent:    c.sent.Load(),
+			FramesDropped: c.dropped.Load(),
+			WriteErrors:   c.errors.Load(),
+		})
 	}
 	h.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].ConnectedAt.Before(out[j].ConnectedAt) })
+	return out
+}
+
+// appendPart appends f as one part of the multipart stream to b.
+func appendPart(b, f []byte) []byte {
+	b = fmt.Appendf(b, "--frame\r\nContent-Type: image/jpeg\r\nContent-Length: %d\r\n\r\n", len(f))
+	b = append(b, f...)
+	return append(b, "\r\n"...)
 }
 
 func main() {
 	addr := flag.String("addr", "224.0.0.250:5000", "multicast address:port")
 	httpAddr := flag.String("http", ":8080", "http listen address")
 	ifname := flag.String("if", "", "network interface name to use for multicast (optional)")
+	source := flag.String("source", "", "sender's IPv4 address, for a source-specific (SSM) join on networks that require one (optional)")
+	joinAll := flag.Bool("join-all", false, "join the group on every up, multicast-capable interface instead of only the first (not with -if)")
+	key := flag.String("key", "", "pre-shared AES-GCM key the server encrypts frames with, as 32, 48 or 64 hex digits or @file to read them from; frames not encrypted with it are dropped")
+	noLoopback := flag.Bool("no-loopback", false, "don't receive the group's traffic sent from this host (only takes effect on Windows; elsewhere use the server's -no-loopback)")
+	stale := flag.Duration("stale", 30*time.Second, "/healthz fails, and the receiver rejoins the group, when no frame arrived for this long; use the server's -keepalive for streams that can go unchanged longer")
+	logLevel := flag.String("log-level", "info", "log level: debug, info, warn or error")
+	readBuffer := flag.Int("read-buffer", mcast.DefaultReadBuffer, "socket receive buffer in bytes (capped by net.core.rmem_max on Linux)")
+	latestOnly := flag.Bool("latest-only", false, "when the proxy falls behind, skip to the newest frame instead of queueing up to 8 stale ones")
+	nackPort := flag.Int("nack-port", 0, "ask the server to resend fragments missing from nearly complete frames, at this port (the server's -nack-listen); 0 to disable")
+	clientTimeout := flag.Duration("client-timeout", 10*time.Second, "disconnect a /stream viewer when writing one frame to it takes longer than this (0 to never)")
+	clientQueue := flag.Int("client-queue", 2, "frames queued per /stream viewer before -queue-policy applies")
+	queuePolicy := flag.String("queue-policy", dropNewest, "when a viewer's queue is full: drop-newest (skip new frames), drop-oldest, or coalesce (keep only the newest)")
+	validateJPEG := flag.Bool("validate-jpeg", false, "skip frames that don't start and end with JPEG markers instead of passing corrupt images to viewers")
+	jitterBuffer := flag.Duration("jitter-buffer", 0, "hold frames for up to this long to pass them on evenly spaced at the stream's frame rate (0 to pass them on immediately)")
+	inOrder := flag.Bool("in-order", false, "drop frames that complete after a newer one, so viewers never step back to an older picture")
+	lowLatency := flag.Bool("low-latency", false, "give up on incomplete frames after 500ms instead of 5s, for -low-latency servers")
+	verbose := flag.Bool("verbose", false, "log every received datagram (needs -log-level debug)")
+	cors := flag.Bool("cors", false, "send CORS headers so web apps on other origins can use /stream, /snapshot and the JSON endpoints")
+	corsOrigins := flag.String("cors-origins", "", "with -cors, comma-separated origins allowed, e.g. https://dash.example.com (empty allows any)")
+	thumbWidth := flag.Int("thumb-width", 0, "also serve /stream/thumb, every frame scaled down to this many pixels wide for small previews (0 to disable)")
+	thumbQuality := flag.Int("thumb-quality", 60, "JPEG quality (1-100) of /stream/thumb frames")
+	pprofAddr := flag.String("pprof", "", "serve net/http/pprof on this separate address, e.g. localhost:6060 (off by default)")
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
 		flag.PrintDefaults()
 		fmt.Fprintf(os.Stderr, "\nExample:\n  %s -addr 224.0.0.250:5000 -http :8080\n", os.Args[0])
 	}
 	flag.Parse()
+	if err := logutil.Setup(*logLevel); err != nil {
+		log.Fatalf("log-level: %v", err)
+	}
+	if *pprofAddr != "" {
+		startPprof(*pprofAddr)
+	}
 
-	rx, err := mcast.NewReceiver(*addr, *ifname)
+	var psk []byte
+	if *key != "" {
+		var err error
+		if psk, err = mcast.ParseKey(*key); err != nil {
+			log.Fatalf("key: %v", err)
+		}
+	}
+	opts := mcast.ReceiverOptions{Interface: *ifname, Verbose: *verbose, ReadBuffer: *readBuffer, LatestOnly: *latestOnly, InOrder: *inOrder, JitterBuffer: *jitterBuffer, NACKPort: *nackPort, Source: *source, JoinAllInterfaces: *joinAll, NoLoopback: *noLoopback, Key: psk}
+	if *lowLatency {
+		opts.ReassemblyTimeout = 500 * time.Millisecond
+	}
+	rx, err := mcast.NewReceiverWithOptions(*addr, opts)
 	if err != nil {
 		log.Fatalf("receiver: %v", err)
 	}
-	defer rx.Close()
 
-	h := newHub()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	h, err := newHub(*clientQueue, *queuePolicy)
+	if err != nil {
+		log.Fatalf("hub: %v", err)
+	}
+	// with -thumb-width, the reader hands each frame to the thumbnailer,
+	// which scales it once for all /stream/thumb viewers. It only keeps the
+	// newest frame, so a slow rescale skips frames instead of delaying the
+	// full stream.
+	var thumbs *hub
+	thumbIn := make(chan []byte, 1)
+	if *thumbWidth > 0 {
+		thumbs, _ = newHub(*clientQueue, *queuePolicy)
+		go thumbnailer(thumbIn, thumbs, *thumbWidth, *thumbQuality)
+	}
+	// a private mux, so nothing registered on http.DefaultServeMux is served
+	mux := http.NewServeMux()
 
-	// background reader
+	// background reader. It owns rx until readerDone is closed. The receiver
+	// retries socket errors itself, so a stream that is down shows up as no
+	// frame for -stale: the reader then waits out an exponential backoff and
+	// rejoins with a fresh receiver, logging only as often as it retries.
+	// the receiver in use, for the stats below
+	var current atomic.Pointer[mcast.Receiver]
+	current.Store(rx)
+	readerDone := make(chan struct{})
 	go func() {
+		defer close(readerDone)
+		defer close(thumbIn)
+		var failures int
+		delay := rxBackoffMin
+		next := func() ([]byte, error) {
+			if *stale <= 0 {
+				return rx.NextContext(ctx)
+			}
+			sctx, cancel := context.WithTimeout(ctx, *stale)
+			defer cancel()
+			img, err := rx.NextContext(sctx)
+			if err != nil && ctx.Err() == nil && errors.Is(err, context.DeadlineExceeded) {
+				err = fmt.Errorf("no frame for %s", *stale)
+			}
+			return img, err
+		}
 		for {
-			img, err := rx.Next()
+			img, err := next()
 			if err != nil {
-				log.Printf("rx: %v", err)
-				time.Sleep(500 * time.Millisecond)
+				if ctx.Err() != nil {
+					return
+				}
+				failures++
+				slog.Warn("rx failed, rejoining", "err", err, "failures", failures, "retry_in", delay)
+				select {
+				case <-time.After(delay):
+				case <-ctx.Done():
+					return
+				}
+				delay = min(2*delay, rxBackoffMax)
+				_ = rx.Close()
+				if nrx, err := mcast.NewReceiverWithOptions(*addr, opts); err != nil {
+					slog.Warn("rejoin failed", "err", err)
+				} else {
+					rx = nrx
+					current.Store(rx)
+				}
+				continue
+			}
+			if failures > 0 {
+				slog.Info("rx recovered", "failures", failures)
+				failures, delay = 0, rxBackoffMin
+			}
+			if *validateJPEG && !validJPEG(img) {
+				rejected.Add(1)
+				slog.Debug("skipping invalid JPEG", "bytes", len(img))
 				continue
 			}
 			h.broadcast(img)
+			if thumbs != nil {
+				offerLatest(thumbIn, img)
+			}
 			cnt := atomic.AddUint64(&broadcasted, 1)
 			if cnt%10 == 0 {
-				log.Printf("broadcasted frames: %d", cnt)
+				slog.Info("broadcasted frames", "count", cnt)
 			}
 		}
 	}()
@@ -86,42 +349,74 @@ func main() {
 			h.mu.Lock()
 			clients := len(h.clients)
 			h.mu.Unlock()
-			log.Printf("hub: clients=%d", clients)
+			// how long ago the receiver last assembled a frame, whether
+			// or not it reached viewers
+			rx := current.Load()
+			args := []any{"clients", clients, "rx_frame_age", rx.LastFrameAge().Round(time.Millisecond)}
+			if down := rx.DownInterfaces(); len(down) > 0 {
+				args = append(args, "rx_iface_down", strings.Join(down, ","))
+			}
+			if thumbs != nil {
+				thumbs.mu.Lock()
+				args = append(args, "thumb_clients", len(thumbs.clients))
+				thumbs.mu.Unlock()
+			}
+			if *validateJPEG {
+				args = append(args, "invalid_jpeg", rejected.Load())
+			}
+			slog.Info("hub", args...)
 		}
 	}()
 
-	http.HandleFunc("/stream", func(w http.ResponseWriter, r *http.Request) {
-		flusher, ok := w.(http.Flusher)
-		if !ok {
-			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+	mux.HandleFunc("/stream", streamHandler(h, *clientTimeout))
+	if thumbs != nil {
+		mux.HandleFunc("/stream/thumb", streamHandler(thumbs, *clientTimeout))
+	}
+	// /clients lists who is watching, to find the viewer behind drops
+	mux.HandleFunc("/clients", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(h.list())
+	})
+	// /snapshot serves the latest frame as a still image. Its ETag is a hash
+	// of the frame, so pollers with If-None-Match get a 304 until it changes.
+	mux.HandleFunc("/snapshot", func(w http.ResponseWriter, r *http.Request) {
+		h.mu.Lock()
+		f, etag, changed := h.last, h.lastETag, h.lastChanged
+		h.mu.Unlock()
+		if f == nil {
+			http.Error(w, "no frames received yet", http.StatusServiceUnavailable)
 			return
 		}
-		w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary=frame")
-
-		c := &client{ch: make(chan []byte, 2)}
-		h.add(c)
-		defer h.remove(c)
-
-		// send frames to client until disconnect
-		for {
-			select {
-			case f := <-c.ch:
-				if _, err := fmt.Fprintf(w, "--frame\r\nContent-Type: image/jpeg\r\nContent-Length: %d\r\n\r\n", len(f)); err != nil {
-					return
-				}
-				if _, err := w.Write(f); err != nil {
-					return
-				}
-				if _, err := fmt.Fprint(w, "\r\n"); err != nil {
-					return
-				}
-				flusher.Flush()
-			case <-r.Context().Done():
-				return
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("ETag", etag)
+		// handles If-None-Match, If-Modified-Since and HEAD
+		http.ServeContent(w, r, "", changed, bytes.NewReader(f))
+	})
+	// /livez only says the process is up; /healthz also requires a frame
+	// from the multicast source within -stale
+	mux.HandleFunc("/livez", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		h.mu.Lock()
+		last := h.lastFrame
+		h.mu.Unlock()
+		if last.IsZero() {
+			http.Error(w, "no frames received yet", http.StatusServiceUnavailable)
+			return
+		}
+		if age := time.Since(last); age > *stale {
+			msg := fmt.Sprintf("last frame %s ago", age.Round(time.Second))
+			if down := current.Load().DownInterfaces(); len(down) > 0 {
+				msg += "; interface " + strings.Join(down, ",") + " is down"
 			}
+			http.Error(w, msg, http.StatusServiceUnavailable)
+			return
 		}
+		_, _ = fmt.Fprintln(w, "ok")
 	})
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
 		_, _ = fmt.Fprint(w, `<!doctype html>
 <html>
@@ -139,18 +434,172 @@ func main() {
 </html>`)
 	})
 
-	srv := &http.Server{Addr: *httpAddr}
+	var handler http.Handler = mux
+	if *cors {
+		handler = corsHandler(*corsOrigins, mux)
+	}
+	srv := &http.Server{Addr: *httpAddr, Handler: handler}
 	go func() {
-		log.Printf("http listening %s", *httpAddr)
+		slog.Info("http listening", "addr", *httpAddr)
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("ListenAndServe: %v", err)
 		}
 	}()
 
-	// wait for interrupt and gracefully shutdown
-	stop := make(chan os.Signal, 1)
-	signal.Notify(stop, os.Interrupt)
-	<-stop
-	log.Printf("shutting down http server")
-	_ = srv.Shutdown(context.Background())
+	// wait for interrupt and gracefully shutdown: the reader goroutine exits
+	// on the cancelled context, then the receiver is closed and clients are
+	// disconnected so their streaming handlers return and the server can drain.
+	<-ctx.Done()
+	<-readerDone
+	slog.Info("shutting down receiver")
+	_ = rx.Close()
+	h.closeAll()
+	if thumbs != nil {
+		thumbs.closeAll()
+	}
+	slog.Info("shutting down http server")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_ = srv.Shutdown(shutdownCtx)
+}
+
+// offerLatest puts b on the one-slot ch, replacing a frame still waiting
+// there. Only the reader sends on ch.
+func offerLatest(ch chan []byte, b []byte) {
+	select {
+	case <-ch:
+	default:
+	}
+	ch <- b
+}
+
+// thumbnailer scales each frame from in to width pixels and broadcasts it
+// on thumbs, until in is closed. Frames are only scaled while someone is
+// watching, and an unchanged frame (a keepalive) reuses the last thumbnail.
+func thumbnailer(in <-chan []byte, thumbs *hub, width, quality int) {
+	var lastSum [sha256.Size]byte
+	var last []byte
+	for img := range in {
+		thumbs.mu.Lock()
+		watching := len(thumbs.clients) > 0
+		thumbs.mu.Unlock()
+		if !watching {
+			continue
+		}
+		if sum := sha256.Sum256(img); sum != lastSum || last == nil {
+			t, err := frame.Thumbnail(img, width, quality)
+			if err != nil {
+				slog.Debug("thumbnail", "err", err)
+				continue
+			}
+			lastSum, last = sum, t
+		}
+		thumbs.broadcast(last)
+	}
+}
+
+// corsHandler adds CORS headers for requests from the comma-separated
+// origins, or from any origin if origins is empty, and answers preflight
+// OPTIONS requests itself. Other origins get no CORS headers, so browsers
+// keep blocking them.
+func corsHandler(origins string, next http.Handler) http.Handler {
+	allowed := make(map[string]bool)
+	for _, o := range strings.Split(origins, ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			allowed[strings.TrimSuffix(o, "/")] = true
+		}
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		hd := w.Header()
+		if len(allowed) > 0 {
+			// the answer depends on the origin, so caches must key on it
+			hd.Add("Vary", "Origin")
+		}
+		if origin != "" && (len(allowed) == 0 || allowed[origin]) {
+			if len(allowed) == 0 {
+				hd.Set("Access-Control-Allow-Origin", "*")
+			} else {
+				hd.Set("Access-Control-Allow-Origin", origin)
+			}
+			// lets pollers of /snapshot read its validators
+			hd.Set("Access-Control-Expose-Headers", "ETag, Last-Modified")
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				hd.Set("Access-Control-Allow-Methods", "GET, HEAD, OPTIONS")
+				if h := r.Header.Get("Access-Control-Request-Headers"); h != "" {
+					hd.Set("Access-Control-Allow-Headers", h)
+				}
+				hd.Set("Access-Control-Max-Age", "600")
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// streamHandler serves h's frames as an MJPEG stream, dropping a client
+// whose write of one frame takes longer than clientTimeout (0 for never).
+func streamHandler(h *hub, clientTimeout time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rc := http.NewResponseController(w)
+		w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary=frame")
+
+		c := h.newClient(r.RemoteAddr)
+		h.add(c)
+		defer h.remove(c)
+
+		// send frames to client until disconnect. Each part goes out in one
+		// write under a deadline: after a failed or partial write the stream
+		// can't be resynchronised, so the client is dropped and the
+		// connection closed.
+		var part []byte
+		for {
+			select {
+			case f, ok := <-c.ch:
+				if !ok {
+					return
+				}
+				part = appendPart(part[:0], f)
+				var deadline time.Time // zero: none
+				if clientTimeout > 0 {
+					deadline = time.Now().Add(clientTimeout)
+				}
+				if err := rc.SetWriteDeadline(deadline); err != nil {
+					http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+					return
+				}
+				_, err := w.Write(part)
+				if err == nil {
+					err = rc.Flush()
+				}
+				if err != nil {
+					c.errors.Add(1)
+					slog.Debug("stream write failed, dropping client", "client", c.addr, "err", err)
+					return
+				}
+				c.sent.Add(1)
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}
+}
+
+// startPprof serves net/http/pprof on its own listener at addr. The handlers
+// are mounted on a private mux: importing the package also registers them on
+// http.DefaultServeMux, which must never be served.
+func startPprof(addr string) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("pprof: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	slog.Warn("pprof listening, do not expose this address", "addr", ln.Addr().String())
+	go func() { _ = http.Serve(ln, mux) }()
 }
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"time"
+
+	"mjpeg-multicast/internal/logutil"
+	"mjpeg-multicast/internal/mcast"
+)
+
+// recorder writes frames as numbered JPEG files. Without rotation they go
+// straight into dir; with rotation each segment gets its own subdirectory and
+// only the newest keep segments are retained.
+type recorder struct {
+	dir       string
+	segFrames int
+	segDur    time.Duration
+	keep      int
+
+	segments []string // segment directories, oldest first
+	segDir   string
+	segStart time.Time
+	segN     int // frames in current segment
+	seq      int // segment counter
+}
+
+func (r *recorder) rotating() bool { return r.segFrames > 0 || r.segDur > 0 }
+
+// write stores one frame, starting a new segment first when due.
+func (r *recorder) write(img []byte) error {
+	if r.segDir == "" || (r.rotating() && r.segmentFull()) {
+		if err := r.newSegment(); err != nil {
+			return err
+		}
+	}
+	r.segN++
+	name := filepath.Join(r.segDir, fmt.Sprintf("frame-%06d.jpg", r.segN))
+	return os.WriteFile(name, img, 0o644)
+}
+
+func (r *recorder) segmentFull() bool {
+	if r.segFrames > 0 && r.segN >= r.segFrames {
+		return true
+	}
+	return r.segDur > 0 && time.Since(r.segStart) >= r.segDur
+}
+
+// lastFrame returns the highest frame number recorded in dir, or 0.
+func lastFrame(dir string) (int, error) {
+	names, err := filepath.Glob(filepath.Join(dir, "frame-*.jpg"))
+	if err != nil {
+		return 0, err
+	}
+	last := 0
+	for _, name := range names {
+		var n int
+		if _, err := fmt.Sscanf(filepath.Base(name), "frame-%d.jpg", &n); err == nil {
+			last = max(last, n)
+		}
+	}
+	return last, nil
+}
+
+func (r *recorder) newSegment() error {
+	r.segN = 0
+	r.segStart = time.Now()
+	if !r.rotating() {
+		r.segDir = r.dir
+		if err := os.MkdirAll(r.dir, 0o755); err != nil {
+			return err
+		}
+		// carry on after an earlier run instead of overwriting it
+		n, err := lastFrame(r.dir)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			slog.Info("continuing existing recording", "dir", r.dir, "frames", n)
+		}
+		r.segN = n
+		return nil
+	}
+	r.seq++
+	r.segDir = filepath.Join(r.dir, fmt.Sprintf("seg-%05d-%s", r.seq, r.segStart.Format("20060102-150405")))
+	if err := os.MkdirAll(r.segDir, 0o755); err != nil {
+		return err
+	}
+	r.segments = append(r.segments, r.segDir)
+	slog.Info("new segment", "dir", r.segDir)
+	for r.keep > 0 && len(r.segments) > r.keep {
+		old := r.segments[0]
+		r.segments = r.segments[1:]
+		if err := os.RemoveAll(old); err != nil {
+			slog.Warn("remove old segment", "dir", old, "err", err)
+		} else {
+			slog.Info("removed old segment", "dir", old)
+		}
+	}
+	return nil
+}
+
+func main() {
+	addr := flag.String("addr", "224.0.0.250:5000", "multicast address:port")
+	ifname := flag.String("if", "", "network interface name to use for multicast (optional)")
+	source := flag.String("source", "", "sender's IPv4 address, for a source-specific (SSM) join on networks that require one (optional)")
+	joinAll := flag.Bool("join-all", false, "join the group on every up, multicast-capable interface instead of only the first (not with -if)")
+	key := flag.String("key", "", "pre-shared AES-GCM key the server encrypts frames with, as 32, 48 or 64 hex digits or @file to read them from; frames not encrypted with it are dropped")
+	noLoopback := flag.Bool("no-loopback", false, "don't receive the group's traffic sent from this host (only takes effect on Windows; elsewhere use the server's -no-loopback)")
+	dir := flag.String("dir", "recording", "output directory for numbered JPEG frames; without segments, numbering continues after any frames already there")
+	fps := flag.Float64("fps", 0, "maximum frames per second to record (0 records every frame)")
+	maxFrames := flag.Int("max-frames", 0, "stop after recording this many frames (0 for no limit)")
+	segFrames := flag.Int("segment-frames", 0, "start a new segment directory every N frames (0 disables)")
+	segDur := flag.Duration("segment-duration", 0, "start a new segment directory after this long, e.g. 10m (0 disables)")
+	keep := flag.Int("keep-segments", 0, "delete the oldest segments beyond this many (0 keeps all)")
+	logLevel := flag.String("log-level", "info", "log level: debug, info, warn or error")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nExample:\n  %s -addr 224.0.0.250:5000 -dir ./capture -fps 1 -segment-duration 1h -keep-segments 24\n", os.Args[0])
+	}
+	flag.Parse()
+	if err := logutil.Setup(*logLevel); err != nil {
+		log.Fatalf("log-level: %v", err)
+	}
+
+	var psk []byte
+	if *key != "" {
+		var err error
+		if psk, err = mcast.ParseKey(*key); err != nil {
+			log.Fatalf("key: %v", err)
+		}
+	}
+	rx, err := mcast.NewReceiverWithOptions(*addr, mcast.ReceiverOptions{Interface: *ifname, Source: *source, JoinAllInterfaces: *joinAll, NoLoopback: *noLoopback, Key: psk})
+	if err != nil {
+		log.Fatalf("receiver: %v", err)
+	}
+	defer rx.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		// unblock Next on interrupt
+		<-ctx.Done()
+		_ = rx.Close()
+	}()
+
+	rec := &recorder{dir: *dir, segFrames: *segFrames, segDur: *segDur, keep: *keep}
+	var minGap time.Duration
+	if *fps > 0 {
+		minGap = time.Duration(float64(time.Second) / *fps)
+	}
+	var last time.Time
+	recorded := 0
+	for *maxFrames == 0 || recorded < *maxFrames {
+		img, err := rx.Next()
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			slog.Warn("rx", "err", err)
+			time.Sleep(500 * time.Millisecond)
+			continue
+		}
+		now := time.Now()
+		if minGap > 0 && !last.IsZero() && now.Sub(last) < minGap {
+			continue
+		}
+		last = now
+		if err := rec.write(img); err != nil {
+			log.Fatalf("write: %v", err)
+		}
+		recorded++
+		if recorded%10 == 0 {
+			slog.Info("recorded frames", "count", recorded)
+		}
+	}
+	slog.Info("recording finished", "frames", recorded, "dir", *dir)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"mjpeg-multicast/internal/logutil"
+	"mjpeg-multicast/internal/mcast"
+)
+
+// recordedFrames returns the JPEG files under dir in playback order. record
+// names frames frame-NNNNNN.jpg, in seg-NNNNN-* subdirectories when rotating,
+// so sorting the paths puts them in the order they were captured.
+func recordedFrames(dir string) ([]string, error) {
+	var paths []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if ext := strings.ToLower(filepath.Ext(p)); !d.IsDir() && (ext == ".jpg" || ext == ".jpeg") {
+			paths = append(paths, p)
+		}
+		return nil
+	})
+	sort.Strings(paths)
+	return paths, err
+}
+
+func main() {
+	addr := flag.String("addr", "224.0.0.250:5000", "multicast address:port")
+	ifname := flag.String("if", "", "network interface to send from (optional; must support multicast)")
+	key := flag.String("key", "", "pre-shared AES-GCM key to encrypt frames with, as 32, 48 or 64 hex digits or @file to read them from; receivers need the same -key")
+	ttl := flag.Int("ttl", 1, "multicast TTL (1=local LAN)")
+	dir := flag.String("dir", "recording", "directory of JPEG frames to replay, e.g. one written by record (segment subdirectories included)")
+	fps := flag.Float64("fps", 5, "frames per second to replay at")
+	loop := flag.Bool("loop", false, "start over after the last frame instead of exiting")
+	mtu := flag.Int("mtu", 0, "fragment size in bytes (0 to use the interface MTU)")
+	repeats := flag.Int("repeats", 1, "times to send each fragment")
+	logLevel := flag.String("log-level", "info", "log level: debug, info, warn or error")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nExample:\n  %s -addr 224.0.0.250:5000 -dir ./capture -fps 5 -loop\n", os.Args[0])
+	}
+	flag.Parse()
+	if err := logutil.Setup(*logLevel); err != nil {
+		log.Fatalf("log-level: %v", err)
+	}
+
+	if *fps < 0.1 || *fps > 60 {
+		log.Fatalf("fps: %v out of range (0.1-60)", *fps)
+	}
+	paths, err := recordedFrames(*dir)
+	if err != nil {
+		log.Fatalf("dir: %v", err)
+	}
+	if len(paths) == 0 {
+		log.Fatalf("dir: no JPEG frames in %s", *dir)
+	}
+	slog.Info("replaying", "dir", *dir, "frames", len(paths), "fps", *fps, "loop", *loop)
+
+	var psk []byte
+	if *key != "" {
+		var err error
+		if psk, err = mcast.ParseKey(*key); err != nil {
+			log.Fatalf("key: %v", err)
+		}
+	}
+	s, err := mcast.NewSenderWithOptions(*addr, mcast.SenderOptions{Interface: *ifname, TTL: *ttl, DiscoverMTU: *mtu == 0, Key: psk})
+	if err != nil {
+		log.Fatalf("sender: %v", err)
+	}
+	defer s.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / *fps))
+	defer ticker.Stop()
+	sent := 0
+	for i := 0; ; i++ {
+		if i == len(paths) {
+			if !*loop {
+				break
+			}
+			slog.Debug("looping", "sent", sent)
+			i = 0
+		}
+		select {
+		case <-ctx.Done():
+			slog.Info("replay interrupted", "sent", sent)
+			return
+		case <-ticker.C:
+		}
+		// frames are read as they are sent, so long recordings don't have
+		// to fit in memory
+		img, err := os.ReadFile(paths[i])
+		if err != nil {
+			slog.Warn("read frame", "path", paths[i], "err", err)
+			continue
+		}
+		if err := s.SendFrame(img, *mtu, *repeats); err != nil {
+			slog.Error("send", "path", paths[i], "err", err)
+			continue
+		}
+		sent++
+		if sent%10 == 0 {
+			slog.Info("sent frames", "count", sent)
+		}
+	}
+	slog.Info("replay finished", "sent", sent)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"mjpeg-multicast/internal/frame"
+)
+
+// controlConfig is what GET /config returns and POST /config accepts (as
+// form values, durations in Go syntax such as 5s).
+type controlConfig struct {
+	Quality     int     `json:"quality"`
+	Interval    string  `json:"interval"`
+	Fade        string  `json:"fade"`
+	Timestamp   bool    `json:"timestamp"`
+	Ticker      string  `json:"ticker"`
+	TickerSpeed float64 `json:"ticker_speed"`
+	Paused      bool    `json:"paused"`
+	Slide       int     `json:"slide"`
+	Slides      int     `json:"slides"`
+}
+
+func currentConfig() controlConfig {
+	slide, slides := frame.CurrentSlide()
+	ticker, speed := frame.Ticker()
+	return controlConfig{
+		Quality: frame.Quality(), Interval: frame.Interval().String(), Fade: frame.Fade().String(),
+		Timestamp: frame.Timestamp(), Ticker: ticker, TickerSpeed: speed,
+		Paused: frame.Paused(), Slide: slide, Slides: slides,
+	}
+}
+
+// applyConfig sets whichever of quality, interval, fade, timestamp, ticker
+// and ticker_speed r carries; an empty ticker clears it. Everything is
+// checked before anything is changed.
+func applyConfig(r *http.Request) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+	var set []func()
+	if v := r.FormValue("quality"); v != "" {
+		q, err := strconv.Atoi(v)
+		if err != nil || q < 1 || q > 100 {
+			return fmt.Errorf("quality %q: want 1-100", v)
+		}
+		set = append(set, func() { frame.SetQuality(q) })
+	}
+	if v := r.FormValue("interval"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("interval %q: want a positive duration such as 5s", v)
+		}
+		set = append(set, func() { frame.SetInterval(d) })
+	}
+	if v := r.FormValue("fade"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return fmt.Errorf("fade %q: want a duration such as 2s, or 0 to disable", v)
+		}
+		set = append(set, func() { frame.SetFade(d) })
+	}
+	if v := r.FormValue("timestamp"); v != "" {
+		on, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("timestamp %q: want true or false", v)
+		}
+		set = append(set, func() { frame.SetTimestamp(on) })
+	}
+	if r.Form.Has("ticker") || r.Form.Has("ticker_speed") {
+		text, speed := frame.Ticker()
+		if r.Form.Has("ticker") {
+			text = r.FormValue("ticker")
+		}
+		if v := r.FormValue("ticker_speed"); v != "" {
+			var err error
+			if speed, err = strconv.ParseFloat(v, 64); err != nil || speed < 0 {
+				return fmt.Errorf("ticker_speed %q: want pixels per second, 0 or more", v)
+			}
+		}
+		set = append(set, func() { frame.SetTicker(text, speed) })
+	}
+	for _, f := range set {
+		f()
+	}
+	return nil
+}
+
+// startControl serves the -control HTTP API on its own listener at addr:
+// GET /config, POST /config, and POST /pause, /resume, /next (?fade=1 to
+// transition rather than cut) and /prev. With a token, every request must
+// carry it as "Authorization: Bearer <token>".
+func startControl(addr, token string) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("control: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /config", func(w http.ResponseWriter, r *http.Request) {
+		writeConfig(w)
+	})
+	mux.HandleFunc("POST /config", func(w http.ResponseWriter, r *http.Request) {
+		if err := applyConfig(r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		slog.Info("control: config changed", "from", r.RemoteAddr, "config", currentConfig())
+		writeConfig(w)
+	})
+	actions := map[string]func(r *http.Request) error{
+		"pause":  func(*http.Request) error { frame.Pause(); return nil },
+		"resume": func(*http.Request) error { frame.Resume(); return nil },
+		"next": func(r *http.Request) error {
+			fade, _ := strconv.ParseBool(r.FormValue("fade"))
+			return frame.Next(fade)
+		},
+		"prev": func(*http.Request) error { return frame.Prev() },
+	}
+	for name, act := range actions {
+		mux.HandleFunc("POST /"+name, func(w http.ResponseWriter, r *http.Request) {
+			if err := act(r); err != nil {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			slog.Info("control: "+name, "from", r.RemoteAddr)
+			writeConfig(w)
+		})
+	}
+	var h http.Handler = mux
+	if token != "" {
+		h = requireToken(token, mux)
+	} else {
+		slog.Warn("control API has no -control-token; anyone who can reach it can change the stream", "addr", ln.Addr().String())
+	}
+	slog.Info("control API listening", "addr", ln.Addr().String())
+	go func() { _ = http.Serve(ln, h) }()
+}
+
+func writeConfig(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(currentConfig())
+}
+
+// requireToken rejects requests without the bearer token.
+func requireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -4,14 +4,25 @@ import (
 	"bytes"
 	"context"
 	"crypto/sha256"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"image"
 	"log"
+	"log/slog"
 	"math"
 	"mjpeg-multicast/internal/frame"
+	"mjpeg-multicast/internal/logutil"
 	"mjpeg-multicast/internal/mcast"
+	"mjpeg-multicast/internal/metrics"
+	"net"
+	"net/http"
+	"net/http/pprof"
 	"os"
 	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 )
 
@@ -19,124 +30,573 @@ var lastHash [32]byte
 
 func main() {
 	addr := flag.String("addr", "224.0.0.250:5000", "multicast address:port")
-	ifname := flag.String("if", "", "network interface name to use for multicast (optional)")
-	ttl := flag.Int("ttl", 1, "multicast TTL (1=local LAN)")
-	mtu := flag.Int("mtu", 1200, "MTU to fragment UDP packets to")
+	ifname := flag.String("if", "", "network interface to send from, multicast and -unicast alike (optional; must support multicast)")
+	ttl := flag.Int("ttl", 1, "multicast TTL, 1-255 (1=local LAN)")
+	dscpFlag := flag.String("dscp", "", "mark the stream for QoS with this DSCP, 0-63 or a name such as af41, ef or cs5 (empty for best effort)")
+	fps := flag.Float64("fps", 5, "frames generated (and at most sent) per second, 0.1-60")
+	key := flag.String("key", "", "encrypt every frame with AES-GCM under this pre-shared key, as 32, 48 or 64 hex digits or @file to read them from; receivers need the same -key")
+	noLoopback := flag.Bool("no-loopback", false, "don't loop the stream back to receivers on this host; leave off if a proxy or viewer runs here")
+	compress := flag.Bool("compress", false, "deflate frames that shrink by it (test patterns, flat slides) before sending; receivers inflate them automatically")
+	dimensions := flag.Bool("dimensions", false, "announce each frame's width and height in the stream, so receivers can size buffers without decoding (all receivers must be this version or newer)")
+	lowLatency := flag.Bool("low-latency", false, "for live sources: send every generated frame, changed or not (see -send), with fragments back to back instead of 1ms apart (more bandwidth and burstier traffic)")
+	regions := flag.Bool("regions", false, "send only the changed rectangle of each frame, with a full keyframe every -keyframe-interval (for mostly static content such as a clock overlay; all receivers must be this version or newer)")
+	keyframeInterval := flag.Duration("keyframe-interval", 10*time.Second, "with -regions, how often to send a full frame; receivers that join or miss one wait this long for a picture")
+	minInterval := flag.Duration("min-interval", 0, "minimum time between sends; frames that change faster are coalesced into the newest (0 to send every change)")
+	statusJSON := flag.Duration("status-json", 0, "print a JSON status object to stdout at this interval, for monitoring agents (0 to disable)")
+	sendPolicy := flag.String("send", "", "which frames to send: on-change (only frames whose bytes differ from the last one sent, the default) or always (every generated frame, for a steady cadence); -low-latency implies always unless this is set")
+	keepalive := flag.Int("keepalive", 0, "resend the current frame at least every N seconds even if unchanged, so late joiners get a picture (0 to disable)")
+	mtu := flag.Int("mtu", 1200, "MTU to fragment UDP packets to; 0 to derive it from the interface and back off if fragments don't fit")
+	interleave := flag.Bool("interleave", false, "send fragments in interleaved order so burst loss is spread across the frame")
 	repeats := flag.Int("repeats", 1, "how many times to repeat each fragment for redundancy")
-	slides := flag.String("slides", "", "directory containing images to use as slideshow")
+	slides := flag.String("slides", "", "directory containing images to use as slideshow, an http(s) URL of a JSON manifest or directory index, or - to read a list of image paths from stdin")
+	defaultSlide := flag.String("default-slide", "", "image to show instead of the clock frame while -slides has no images, at start or after they are removed (the show resumes on the next reload)")
+	slidesRefresh := flag.Duration("slides-refresh", 5*time.Minute, "how often to re-fetch slides when -slides is an http(s) URL (0 to disable)")
 	slideInterval := flag.Int("slide-interval", 5, "slideshow interval in seconds")
 	fade := flag.Int("fade", 0, "crossfade duration in seconds (0 to disable)")
+	fadeSteps := flag.Int("fade-steps", 10, "distinct intermediate frames sent during each -fade, regardless of -fps (0 to follow -fps)")
+	gammaBlend := flag.Bool("gamma-blend", false, "crossfade in linear light, avoiding the midtone dip of blending sRGB values (slower)")
+	blendWorkers := flag.Int("blend-workers", 0, "goroutines compositing each transition frame (0 = max(4, CPUs))")
+	transition := flag.String("transition", "fade", "slide transition during -fade: fade, wipe-left, wipe-right, slide or dissolve")
 	quality := flag.Int("quality", 80, "JPEG encoding quality (1-100)")
-	geometry := flag.String("geometry", "1920x1080", "output frame geometry WIDTHxHEIGHT, e.g. 1280x720")
+	targetBytes := flag.Int("target-bytes", 0, "encode each slide at the highest quality that keeps it within about this many bytes, found once per slide, instead of at -quality (0 to disable)")
+	maxMbps := flag.Float64("max-mbps", 0, "hard cap on egress per multicast address, in Mbit/s including headers, repeats and -unicast copies (0 for no cap)")
+	overBudget := flag.String("over-budget", "block", "what -max-mbps does with a frame that doesn't fit the budget: block (send it more slowly) or drop (skip it whole)")
+	maxFrameBytes := flag.Int("max-frame-bytes", 0, "re-encode frames larger than this many bytes at a lower quality, and skip ones that don't fit even at quality 1 (0 for no cap)")
+	progressive := flag.Bool("progressive", false, "encode progressive JPEGs, which browsers draw coarse-to-fine (slower to encode than baseline)")
+	geometry := flag.String("geometry", "1920x1080", "output frame geometry WIDTHxHEIGHT, e.g. 1280x720, or vga, 720p, 1080p, 4k")
+	bg := flag.String("bg", "#000000", "background colour behind letterboxed slides and of the no-slides frame, as #rrggbb or #rgb")
 	timestamp := flag.Bool("timestamp", false, "enable timestamp overlay on frames")
+	ticker := flag.String("ticker", "", "scroll this text along the bottom of every frame as a news ticker")
+	stationID := flag.String("station-id", "", "burn this identifier, e.g. a display or site name, into every frame as a small faint tag, so a photographed screen can be traced")
+	stationCorner := flag.String("station-id-corner", "bottom-right", "corner of the -station-id tag: top-left, top-right, bottom-left or bottom-right")
+	stationOpacity := flag.Float64("station-id-opacity", 0.1, "opacity of the -station-id tag, from just above 0 (invisible) to 1")
+	stationScale := flag.Int("station-id-scale", 1, "size of the -station-id tag, 1-8 times the 13 pixel font")
+	stationTime := flag.Bool("station-id-time", false, "add the frame time to the -station-id tag")
+	tickerSpeed := flag.Float64("ticker-speed", 120, "scroll speed of -ticker in pixels per second")
+	unicast := flag.String("unicast", "", "comma-separated host:port list to also send every frame to over unicast")
+	logLevel := flag.String("log-level", "info", "log level: debug, info, warn or error")
+	pattern := flag.String("pattern", "", "test pattern when no -slides are given: bars, gradient or sysmon")
+	cacheDir := flag.String("cache-dir", "", "keep decoded, scaled slides in this directory so restarts skip decoding (local -slides only)")
+	lazy := flag.Bool("lazy", false, "decode local slides only when they are about to be shown, keeping a few in memory (for very large -slides directories)")
+	scaleMode := flag.String("scale-mode", "fit", "how slides are sized to -geometry: fit (letterbox), fill (crop to cover) or stretch")
+	order := flag.String("order", "name", "slide order: name, natural, mtime or shuffle")
+	addrLo := flag.String("addr-lo", "", "also simulcast a smaller, cheaper copy of every frame to this multicast address:port")
+	geometryLo := flag.String("geometry-lo", "640x360", "frame geometry of the -addr-lo layer")
+	qualityLo := flag.Int("quality-lo", 50, "JPEG quality (1-100) of the -addr-lo layer")
+	nackListen := flag.String("nack-listen", "", "accept retransmission requests (NACKs) from receivers on this UDP address, e.g. :5001")
+	selftest := flag.Bool("selftest", false, "check that a test frame sent to -addr (and -addr-lo) comes back to a receiver on this host, print PASS or FAIL and exit, nonzero on failure")
+	dryRun := flag.Bool("dry-run", false, "generate, encode and log frames and bandwidth without opening a socket or sending anything")
+	control := flag.String("control", "", "serve an HTTP API to change quality, interval, fade, timestamp and ticker and to pause or step the slideshow at runtime on this address, e.g. :9090 (off by default)")
+	controlToken := flag.String("control-token", "", "require this bearer token on every -control request")
+	pprofAddr := flag.String("pprof", "", "serve net/http/pprof on this separate address, e.g. localhost:6060 (off by default)")
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
 		flag.PrintDefaults()
 		fmt.Fprintf(os.Stderr, "\nExample:\n  %s -slides \"/path/to/slides\" -slide-interval 5 -fade 2 -quality 70 -geometry 1280x720\n", os.Args[0])
 	}
 	flag.Parse()
+	if err := logutil.Setup(*logLevel); err != nil {
+		log.Fatalf("log-level: %v", err)
+	}
+	if *pprofAddr != "" {
+		startPprof(*pprofAddr)
+	}
+
+	if *fps < 0.1 || *fps > 60 {
+		log.Fatalf("fps: %v out of range (0.1-60)", *fps)
+	}
+	if *ttl < 1 || *ttl > 255 {
+		log.Fatalf("ttl: %d out of range (1-255)", *ttl)
+	}
+	var dscp int
+	if *dscpFlag != "" {
+		var err error
+		if dscp, err = mcast.ParseDSCP(*dscpFlag); err != nil {
+			log.Fatalf("dscp: %v", err)
+		}
+	}
+	frameInterval := time.Duration(float64(time.Second) / *fps)
+	var psk []byte
+	if *key != "" {
+		var err error
+		if psk, err = mcast.ParseKey(*key); err != nil {
+			log.Fatalf("key: %v", err)
+		}
+	}
+	if *maxMbps < 0 {
+		log.Fatalf("max-mbps must be 0 or more")
+	}
+	switch *sendPolicy {
+	case "":
+		*sendPolicy = "on-change"
+		if *lowLatency {
+			*sendPolicy = "always"
+		}
+	case "on-change", "always":
+	default:
+		log.Fatalf("send: want on-change or always, got %q", *sendPolicy)
+	}
+	alwaysSend := *sendPolicy == "always"
+	if alwaysSend && *keepalive > 0 {
+		slog.Info("-keepalive has no effect with -send always: every frame is sent")
+	}
+	if *overBudget != "block" && *overBudget != "drop" {
+		log.Fatalf("over-budget: want block or drop, got %q", *overBudget)
+	}
 
-	// parse geometry WIDTHxHEIGHT
-	var gw, gh int
-	if _, err := fmt.Sscanf(*geometry, "%dx%d", &gw, &gh); err == nil {
-		if gw > 0 && gh > 0 {
-			frame.SetGeometry(gw, gh)
+	if *selftest {
+		// the same interface and socket options as going live
+		groups := []string{*addr}
+		if *addrLo != "" {
+			groups = append(groups, *addrLo)
 		}
+		failed := false
+		for _, g := range groups {
+			sopts := mcast.SenderOptions{Interface: *ifname, TTL: *ttl, DSCP: dscp, Compress: *compress, Key: psk}
+			if err := mcast.SelfTest(g, sopts, mcast.ReceiverOptions{Interface: *ifname, Key: psk}, 5*time.Second); err != nil {
+				fmt.Printf("FAIL %s: %v\n", g, err)
+				failed = true
+				continue
+			}
+			fmt.Printf("PASS %s\n", g)
+		}
+		if failed {
+			os.Exit(1)
+		}
+		return
+	}
+
+	// parse geometry WIDTHxHEIGHT or an alias such as 720p
+	gw, gh, err := frame.ParseGeometry(*geometry)
+	if err != nil {
+		log.Fatalf("geometry: %v", err)
+	}
+	frame.SetGeometry(gw, gh)
+	var loLayers []frame.Layer
+	if *addrLo != "" {
+		lw, lh, err := frame.ParseGeometry(*geometryLo)
+		if err != nil {
+			log.Fatalf("geometry-lo: %v", err)
+		}
+		loLayers = []frame.Layer{{Width: lw, Height: lh, Quality: *qualityLo}}
+	}
+	if *regions && *addrLo != "" {
+		log.Fatalf("-regions cannot be combined with -addr-lo")
 	}
 
+	bgColor, err := frame.ParseColor(*bg)
+	if err != nil {
+		log.Fatalf("bg: %v", err)
+	}
+	frame.SetBackground(bgColor)
+	if err := frame.SetPattern(*pattern); err != nil {
+		log.Fatalf("pattern: %v", err)
+	}
+	if *quality != 80 {
+		frame.SetQuality(*quality)
+	}
+	frame.SetProgressive(*progressive)
+	frame.SetTargetBytes(*targetBytes)
+	frame.SetMaxBytes(*maxFrameBytes)
+	// timestamp overlay is opt-in; default is off
+	if *timestamp {
+		frame.SetTimestamp(true)
+	}
+	if *tickerSpeed < 0 {
+		log.Fatalf("ticker-speed must be 0 or more")
+	}
+	frame.SetTicker(*ticker, *tickerSpeed)
+	if err := frame.SetStationIDStyle(frame.StationIDStyle{Corner: *stationCorner, Opacity: *stationOpacity, Scale: *stationScale, Timestamp: *stationTime}); err != nil {
+		log.Fatalf("station-id: %v", err)
+	}
+	frame.SetStationID(*stationID)
+
 	if *slides != "" {
+		if err := frame.SetOrder(*order); err != nil {
+			log.Fatalf("order: %v", err)
+		}
+		if err := frame.SetScaleMode(*scaleMode); err != nil {
+			log.Fatalf("scale-mode: %v", err)
+		}
+		if err := frame.SetCacheDir(*cacheDir); err != nil {
+			log.Fatalf("cache-dir: %v", err)
+		}
+		frame.SetLazyLoad(*lazy)
+		if err := frame.SetDefaultSlide(*defaultSlide); err != nil {
+			log.Fatalf("default-slide: %v", err)
+		}
 		if err := frame.StartSlideshow(*slides, time.Duration(*slideInterval)*time.Second); err != nil {
 			log.Fatalf("StartSlideshow: %v", err)
 		}
 		if *fade > 0 {
 			frame.SetFade(time.Duration(*fade) * time.Second)
+			frame.SetFadeSteps(*fadeSteps)
 		}
-		if *quality != 80 {
-			frame.SetQuality(*quality)
+		if err := frame.SetTransition(*transition); err != nil {
+			log.Fatalf("transition: %v", err)
 		}
-		// timestamp overlay is opt-in; default is off
-		if *timestamp {
-			frame.SetTimestamp(true)
+		frame.SetBlendWorkers(*blendWorkers)
+		frame.SetGammaCorrectBlend(*gammaBlend)
+		if remote := strings.HasPrefix(*slides, "http://") || strings.HasPrefix(*slides, "https://"); remote && *slidesRefresh > 0 {
+			go func() {
+				for range time.Tick(*slidesRefresh) {
+					// on failure the last good set of slides stays up
+					if err := frame.Reload(); err != nil {
+						slog.Warn("refresh slides", "src", *slides, "err", err)
+					}
+				}
+			}()
 		}
+		// SIGHUP re-reads the slides (the next playlist for -slides -)
+		// while the stream keeps running on the current ones
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go func() {
+			for range hup {
+				_, before := frame.CurrentSlide()
+				if err := frame.Reload(); err != nil {
+					slog.Warn("SIGHUP: reload slides", "src", *slides, "err", err)
+					continue
+				}
+				_, after := frame.CurrentSlide()
+				slog.Info("SIGHUP: reloaded slides", "src", *slides, "before", before, "after", after)
+			}
+		}()
 	}
 
-	sender, err := mcast.NewSender(*addr, *ifname, *ttl)
-	if err != nil {
-		log.Fatalf("sender: %v", err)
+	if *control != "" {
+		startControl(*control, *controlToken)
+	}
+
+	// the full stream goes to -addr and the optional simulcast copy to
+	// -addr-lo; in dry-run mode there are no sockets at all and senders stay nil
+	layers := []*layer{{addr: *addr}}
+	if *addrLo != "" {
+		layers = append(layers, &layer{name: "lo", addr: *addrLo})
+	}
+	// the frame size of each layer, for -dimensions and error frames
+	sizes := []image.Point{{gw, gh}}
+	for _, l := range loLayers {
+		sizes = append(sizes, image.Pt(l.Width, l.Height))
+	}
+	if *dryRun {
+		slog.Info("dry run: frames are generated and measured but not sent")
+	}
+	for i, l := range layers {
+		l.rate = metrics.NewRateEstimator(5 * time.Second)
+		if *dryRun {
+			continue
+		}
+		opts := mcast.SenderOptions{Interface: *ifname, TTL: *ttl, DSCP: dscp, InterleaveFragments: *interleave, DiscoverMTU: *mtu == 0, NoPacing: *lowLatency, Compress: *compress, NoLoopback: *noLoopback,
+			MaxBytesPerSec: int(*maxMbps * 1e6 / 8), DropOverBudget: *overBudget == "drop", Key: psk}
+		if i == 0 {
+			// NACKs are only served for the main layer
+			opts.NACKListen = *nackListen
+		}
+		l.sender, err = mcast.NewSenderWithOptions(l.addr, opts)
+		if err != nil {
+			log.Fatalf("sender %s: %v", l.addr, err)
+		}
+		defer l.sender.Close()
+		if *dimensions {
+			l.sender.SetDimensions(sizes[i].X, sizes[i].Y)
+		}
+		if *mtu == 0 {
+			slog.Info("using interface mtu", "addr", l.addr, "mtu", l.sender.MTU())
+		}
+	}
+	if *unicast != "" && !*dryRun {
+		for _, t := range strings.Split(*unicast, ",") {
+			if err := layers[0].sender.AddUnicastTarget(strings.TrimSpace(t)); err != nil {
+				log.Fatalf("unicast target %q: %v", t, err)
+			}
+			slog.Info("relaying to unicast target", "addr", t)
+		}
 	}
-	defer sender.Close()
 
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer stop()
 
-	ticker := time.NewTicker(time.Second / 5)
-	defer ticker.Stop()
+	// frames are generated every frameInterval, except around fades where
+	// the frame package asks for one per fade step
+	timer := time.NewTimer(frame.NextFrameIn(frameInterval))
+	defer timer.Stop()
 	sent := 0
-	var lastSendTime time.Time
-	var ewmaBps float64
-	// EWMA time constant in seconds (5s)
-	const tau = 5.0
+	var lastSent time.Time
+	// consecutive GenerateLayers (or GenerateDelta) errors
+	failures := 0
+	// with -regions, needKey is set from when a keyframe is due or was
+	// generated until one is sent: a region is only valid against the
+	// keyframe receivers have
+	needKey := true
+	var lastKey time.Time
+	// at is where a -regions update goes, nil for whole frames
+	sendAll := func(imgs [][]byte, at *image.Point, h [sha256.Size]byte) {
+		lastHash = h
+		lastSent = time.Now()
+		if at == nil {
+			needKey, lastKey = false, lastSent
+		}
+		for i, l := range layers {
+			l.send(ctx, imgs[i], at, *mtu, *repeats)
+		}
+		sent++
+		if sent%10 == 0 {
+			slog.Info("sent frames", "count", sent)
+		}
+	}
+	// with -min-interval, the newest frame that changed too soon after the
+	// last send waits here until flush fires
+	var pending [][]byte
+	var pendingAt *image.Point
+	var pendingHash [sha256.Size]byte
+	var flush <-chan time.Time
+	var statusC <-chan time.Time
+	if *statusJSON > 0 {
+		t := time.NewTicker(*statusJSON)
+		defer t.Stop()
+		statusC = t.C
+	}
+	status := json.NewEncoder(os.Stdout)
+	// the hook runs inside GenerateLayers, on this goroutine
+	var lastEncode time.Duration
+	lastQuality := frame.Quality()
+	// -max-frame-bytes warnings are logged once per run of capped or
+	// skipped frames, not for every frame
+	var capped, oversized bool
+	frame.SetFrameHook(func(fi frame.FrameInfo) {
+		lastEncode, lastQuality = fi.Encode, fi.Quality
+		if fi.Capped && !capped {
+			slog.Warn("frame over -max-frame-bytes, sending it at lower quality", "quality", fi.Quality, "bytes", fi.Bytes)
+		} else if !fi.Capped && capped {
+			slog.Info("frames fit -max-frame-bytes again")
+		}
+		capped, oversized = fi.Capped, false
+	})
 	for {
 		select {
 		case <-ctx.Done():
-			log.Printf("shutting down server")
+			slog.Info("shutting down server")
 			return
-		case <-ticker.C:
-			img, err := frame.GenerateFrame()
-			if err != nil {
-				log.Printf("frame: %v", err)
-				continue
+		case <-timer.C:
+			timer.Reset(frame.NextFrameIn(frameInterval))
+			// every layer is encoded from the same render
+			var imgs [][]byte
+			var at *image.Point
+			var err error
+			if *regions {
+				var b []byte
+				var pt image.Point
+				var isKey bool
+				b, pt, isKey, err = frame.GenerateDelta(needKey || time.Since(lastKey) >= *keyframeInterval)
+				if err == nil {
+					imgs = [][]byte{b}
+					if isKey {
+						needKey = true
+					} else {
+						at = &pt
+					}
+				}
+			} else {
+				imgs, err = frame.GenerateLayers(loLayers)
 			}
-			// default behavior: only send when encoded bytes change
-			h := sha256.Sum256(img)
-			if bytes.Equal(h[:], lastHash[:]) {
-				// same frame, skip sending
+			if errors.Is(err, frame.ErrFrameTooLarge) {
+				// a safety valve, not a failure: nothing goes out until
+				// the content shrinks
+				if !oversized {
+					slog.Warn("frame over -max-frame-bytes even at quality 1, not sending", "max_bytes", *maxFrameBytes)
+					oversized = true
+				}
 				continue
 			}
-			lastHash = h
-			if err := sender.SendFrame(img, *mtu, *repeats); err != nil {
-				log.Printf("send: %v", err)
-			} else {
-				// estimate bandwidth for this frame on-wire
-				// fragment header size matches internal/mcast fragHeaderSize (1+4+2+2=9)
-				const fragHeader = 9
-				const ipUdpOverhead = 28
-				mtuVal := *mtu
-				payloadPer := mtuVal - fragHeader
-				if payloadPer <= 0 {
-					payloadPer = 1191
+			if err != nil {
+				failures++
+				// log the first failure and then only now and then
+				if failures == 1 || failures%50 == 0 {
+					slog.Error("generate frame", "err", err, "consecutive", failures)
 				}
-				payloadLen := len(img)
-				fragments := (payloadLen + payloadPer - 1) / payloadPer
-				bytesOnWire := payloadLen + fragments*(fragHeader+ipUdpOverhead)
-				bytesWithRepeats := bytesOnWire * (*repeats)
-				// fps is the ticker frequency (5Hz); we compute instant bps from actual send interval below
-				// compute instant bps using delta time since last send
-				now := time.Now()
-				var instBps float64
-				if !lastSendTime.IsZero() {
-					dt := now.Sub(lastSendTime).Seconds()
-					if dt > 0 {
-						instBps = float64(bytesWithRepeats) * 8.0 / dt
-					}
+				if failures < maxGenerateFailures {
+					continue
 				}
-				lastSendTime = now
-				// update EWMA: alpha = 1 - exp(-dt/tau)
-				var alpha float64 = 0.0
-				if ewmaBps == 0 {
-					ewmaBps = instBps
-				} else {
-					// use dt from 1/fps if instBps==0 (shouldn't happen)
-					dt := 1.0 / 5.0
-					alpha = 1 - math.Exp(-dt/tau)
-					ewmaBps = alpha*instBps + (1-alpha)*ewmaBps
+				if failures == maxGenerateFailures {
+					slog.Warn("frames keep failing; sending error frames until they recover")
 				}
-				log.Printf("frame: bytes=%d fragments=%d bytes_on_wire=%d repeats=%d inst=%.3f Mbps ewma=%.3f Mbps", payloadLen, fragments, bytesWithRepeats, *repeats, instBps/1e6, ewmaBps/1e6)
+				// keep failing visibly rather than freezing on the last
+				// good frame
+				if imgs = errorFrames(sizes, err); imgs == nil {
+					continue
+				}
+				pending = nil
+				if h := sha256.Sum256(imgs[0]); alwaysSend || h != lastHash || (*keepalive > 0 && time.Since(lastSent) >= time.Duration(*keepalive)*time.Second) {
+					sendAll(imgs, nil, h)
+				}
+				// receivers took the error frame as their keyframe
+				needKey = true
+				continue
+			}
+			if failures > 0 {
+				slog.Info("frame generation recovered", "failures", failures)
+				failures = 0
+			}
+			// with -send on-change, only send when encoded bytes change,
+			// unless the keepalive interval has passed since the last send
+			h := sha256.Sum256(imgs[0])
+			if at != nil {
+				// the same region bytes elsewhere are a different frame
+				d := sha256.New()
+				d.Write(imgs[0])
+				fmt.Fprintf(d, "@%d,%d", at.X, at.Y)
+				d.Sum(h[:0])
+			}
+			if !alwaysSend && bytes.Equal(h[:], lastHash[:]) && (*keepalive <= 0 || time.Since(lastSent) < time.Duration(*keepalive)*time.Second) {
+				// same frame, skip sending (and drop anything held back:
+				// the picture is back to what receivers already have)
+				pending = nil
+				continue
 			}
-			sent++
-			if sent%10 == 0 {
-				log.Printf("sent frames: %d", sent)
+			if wait := *minInterval - time.Since(lastSent); wait > 0 {
+				// too soon: keep only the newest frame until the interval is up
+				pending, pendingAt, pendingHash = imgs, at, h
+				if flush == nil {
+					flush = time.After(wait)
+				}
+				continue
+			}
+			pending = nil
+			sendAll(imgs, at, h)
+		case now := <-statusC:
+			slide, slides := frame.CurrentSlide()
+			_, ewma := layers[0].rate.Mbps()
+			_ = status.Encode(statusLine{
+				Time: now.UTC(), Sent: sent, Quality: lastQuality, Slide: slide, Slides: slides,
+				EWMAMbps: math.Round(ewma*1000) / 1000, LastFrameBytes: layers[0].lastBytes,
+				LastEncodeMs: math.Round(lastEncode.Seconds()*1e6) / 1000,
+			})
+		case <-flush:
+			flush = nil
+			if pending != nil {
+				sendAll(pending, pendingAt, pendingHash)
+				pending = nil
+			}
+		}
+	}
+}
+
+// maxGenerateFailures is how many frames in a row may fail to generate before
+// the server sends error frames in their place.
+const maxGenerateFailures = 3
+
+// errorFrames renders an error frame saying err for each layer size, or
+// returns nil if even that fails.
+func errorFrames(sizes []image.Point, err error) [][]byte {
+	imgs := make([][]byte, len(sizes))
+	for i, sz := range sizes {
+		b, ferr := frame.ErrorFrame(sz.X, sz.Y, err.Error())
+		if ferr != nil {
+			slog.Error("error frame", "err", ferr)
+			return nil
+		}
+		imgs[i] = b
+	}
+	return imgs
+}
+
+// statusLine is the JSON object -status-json prints to stdout.
+type statusLine struct {
+	Time           time.Time `json:"time"`
+	Sent           int       `json:"sent_frames"`
+	Quality        int       `json:"quality"`
+	Slide          int       `json:"slide"`
+	Slides         int       `json:"slides"`
+	EWMAMbps       float64   `json:"ewma_mbps"`
+	LastFrameBytes int       `json:"last_frame_bytes"`
+	LastEncodeMs   float64   `json:"last_encode_ms"`
+}
+
+// layer is one multicast group the rendered frames are sent to.
+type layer struct {
+	name   string // empty for the main stream, logged otherwise
+	addr   string
+	sender *mcast.Sender // nil in dry-run mode
+	// on-wire bandwidth, smoothed with a 5s time constant
+	rate       *metrics.RateEstimator
+	lastBytes  int  // size of the last frame sent
+	overBudget bool // the last frame was dropped by -over-budget drop
+}
+
+// send transmits img, or with at set the -regions update img to be drawn at
+// at, and logs its size and the layer's estimated bandwidth. A whole frame
+// is abandoned part-way once ctx is done.
+func (l *layer) send(ctx context.Context, img []byte, at *image.Point, mtu, repeats int) {
+	if l.sender != nil {
+		var err error
+		if at != nil {
+			err = l.sender.SendRegion(img, at.X, at.Y, frame.Quality(), mtu, repeats)
+		} else {
+			err = l.sender.SendFrameContext(ctx, img, mtu, repeats)
+		}
+		if errors.Is(err, context.Canceled) {
+			return
+		}
+		if errors.Is(err, mcast.ErrOverBudget) {
+			if !l.overBudget {
+				slog.Warn("dropping frames over -max-mbps", "addr", l.addr, "bytes", len(img))
+				l.overBudget = true
 			}
+			return
+		}
+		if err != nil {
+			slog.Error("send", "addr", l.addr, "err", err)
+			return
+		}
+		if l.overBudget {
+			slog.Info("frames fit -max-mbps again", "addr", l.addr)
+			l.overBudget = false
 		}
 	}
+	// estimate bandwidth for this frame on-wire
+	const ipUdpOverhead = 28
+	if mtu == 0 {
+		mtu = mcast.DefaultMTU
+		if l.sender != nil {
+			mtu = l.sender.MTU()
+		}
+	}
+	payloadPer := mtu - mcast.FragmentHeaderSize
+	if payloadPer <= 0 {
+		payloadPer = 1200 - mcast.FragmentHeaderSize
+	}
+	payloadLen := len(img)
+	l.lastBytes = payloadLen
+	fragments := (payloadLen + payloadPer - 1) / payloadPer
+	bytesOnWire := payloadLen + fragments*(mcast.FragmentHeaderSize+ipUdpOverhead)
+	bytesWithRepeats := bytesOnWire * repeats
+	instMbps, ewmaMbps := l.rate.Add(bytesWithRepeats, time.Now())
+	args := []any{"bytes", payloadLen, "fragments", fragments, "bytes_on_wire", bytesWithRepeats, "repeats", repeats,
+		"inst_mbps", fmt.Sprintf("%.3f", instMbps), "ewma_mbps", fmt.Sprintf("%.3f", ewmaMbps)}
+	if l.name != "" {
+		args = append([]any{"layer", l.name}, args...)
+	}
+	if at != nil {
+		args = append(args, "region_at", *at)
+	}
+	slog.Info("frame", args...)
+}
+
+// startPprof serves net/http/pprof on its own listener at addr. The handlers
+// are mounted on a private mux: importing the package also registers them on
+// http.DefaultServeMux, which must never be served.
+func startPprof(addr string) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("pprof: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	slog.Warn("pprof listening, do not expose this address", "addr", ln.Addr().String())
+	go func() { _ = http.Serve(ln, mux) }()
 }
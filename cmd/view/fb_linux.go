@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// fbioGetVScreenInfo is FBIOGET_VSCREENINFO from linux/fb.h.
+const fbioGetVScreenInfo = 0x4600
+
+// varScreenInfo is the start of struct fb_var_screeninfo, padded to its full
+// 160 bytes.
+type varScreenInfo struct {
+	xres, yres               uint32 // visible resolution
+	xresVirtual, yresVirtual uint32
+	xoffset, yoffset         uint32 // visible area within the virtual one
+	bitsPerPixel             uint32
+	_                        [33]uint32
+}
+
+// framebuffer draws frames on a Linux fbdev device. Only 32 bits per pixel
+// (BGRX) layouts are supported, which covers the common Raspberry Pi and
+// virtual console setups.
+type framebuffer struct {
+	f      *os.File
+	w, h   int
+	stride int
+	offset int64 // of the visible area's first pixel, when panned
+	row    []byte
+}
+
+func openFramebuffer(dev string) (*framebuffer, error) {
+	f, err := os.OpenFile(dev, os.O_WRONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	fb, err := newFramebuffer(f, filepath.Join("/sys/class/graphics", filepath.Base(dev)))
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return fb, nil
+}
+
+// newFramebuffer sizes frames to the visible mode, which on double-buffered
+// or panned framebuffers (common on a Raspberry Pi) is smaller than the
+// virtual area sysfs reports; virtual_size is only used if the mode can't
+// be read.
+func newFramebuffer(f *os.File, sys string) (*framebuffer, error) {
+	var w, h, bpp int
+	var xoff, yoff int
+	var vi varScreenInfo
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, f.Fd(), fbioGetVScreenInfo, uintptr(unsafe.Pointer(&vi))); errno == 0 && vi.xres > 0 && vi.yres > 0 {
+		w, h, bpp = int(vi.xres), int(vi.yres), int(vi.bitsPerPixel)
+		xoff, yoff = int(vi.xoffset), int(vi.yoffset)
+	} else {
+		size, err := readSysfs(sys, "virtual_size")
+		if err != nil {
+			return nil, err
+		}
+		if _, err := fmt.Sscanf(size, "%d,%d", &w, &h); err != nil {
+			return nil, fmt.Errorf("parse virtual_size %q: %w", size, err)
+		}
+		if bpp, err = readSysfsInt(sys, "bits_per_pixel"); err != nil {
+			return nil, err
+		}
+	}
+	if bpp != 32 {
+		return nil, fmt.Errorf("unsupported framebuffer depth %d bpp (need 32)", bpp)
+	}
+	stride, err := readSysfsInt(sys, "stride")
+	if err != nil {
+		stride = w * 4
+	}
+	if stride < (xoff+w)*4 {
+		return nil, fmt.Errorf("framebuffer stride %d is too small for %d pixels at 32 bpp", stride, xoff+w)
+	}
+	return &framebuffer{f: f, w: w, h: h, stride: stride, offset: int64(yoff*stride + xoff*4), row: make([]byte, w*4)}, nil
+}
+
+func (fb *framebuffer) show(m image.Image) error {
+	img := fit(m, fb.w, fb.h)
+	for y := 0; y < fb.h; y++ {
+		src := img.Pix[y*img.Stride : y*img.Stride+fb.w*4]
+		for x := 0; x < fb.w; x++ {
+			i := x * 4
+			fb.row[i] = src[i+2]
+			fb.row[i+1] = src[i+1]
+			fb.row[i+2] = src[i]
+			fb.row[i+3] = 0xff
+		}
+		if _, err := fb.f.WriteAt(fb.row, fb.offset+int64(y*fb.stride)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (fb *framebuffer) Close() error { return fb.f.Close() }
+
+func readSysfs(dir, name string) (string, error) {
+	b, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+func readSysfsInt(dir, name string) (int, error) {
+	s, err := readSysfs(dir, name)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(s)
+}
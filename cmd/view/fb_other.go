@@ -0,0 +1,18 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"image"
+)
+
+type framebuffer struct{}
+
+func openFramebuffer(dev string) (*framebuffer, error) {
+	return nil, errors.New("framebuffer output is only supported on Linux")
+}
+
+func (fb *framebuffer) show(m image.Image) error { return nil }
+
+func (fb *framebuffer) Close() error { return nil }
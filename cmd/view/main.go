@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	draw2 "golang.org/x/image/draw"
+
+	"mjpeg-multicast/internal/logutil"
+	"mjpeg-multicast/internal/mcast"
+)
+
+func main() {
+	addr := flag.String("addr", "224.0.0.250:5000", "multicast address:port")
+	ifname := flag.String("if", "", "network interface name to use for multicast (optional)")
+	source := flag.String("source", "", "sender's IPv4 address, for a source-specific (SSM) join on networks that require one (optional)")
+	joinAll := flag.Bool("join-all", false, "join the group on every up, multicast-capable interface instead of only the first (not with -if)")
+	key := flag.String("key", "", "pre-shared AES-GCM key the server encrypts frames with, as 32, 48 or 64 hex digits or @file to read them from; frames not encrypted with it are dropped")
+	noLoopback := flag.Bool("no-loopback", false, "don't receive the group's traffic sent from this host (only takes effect on Windows; elsewhere use the server's -no-loopback)")
+	fbPath := flag.String("fb", "", "Linux framebuffer device to draw frames on, e.g. /dev/fb0 (empty for headless stats only)")
+	interval := flag.Duration("stats-interval", time.Second, "how often to print stats")
+	pending := flag.Bool("pending", false, "after each stats line, also list the frames still being reassembled with how many fragments each has")
+	logLevel := flag.String("log-level", "warn", "log level: debug, info, warn or error")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nExample:\n  %s -addr 224.0.0.250:5000\n  %s -addr 224.0.0.250:5000 -fb /dev/fb0\n", os.Args[0], os.Args[0])
+	}
+	flag.Parse()
+	if err := logutil.Setup(*logLevel); err != nil {
+		log.Fatalf("log-level: %v", err)
+	}
+
+	var fb *framebuffer
+	if *fbPath != "" {
+		var err error
+		if fb, err = openFramebuffer(*fbPath); err != nil {
+			log.Fatalf("framebuffer: %v", err)
+		}
+		defer fb.Close()
+	}
+
+	var psk []byte
+	if *key != "" {
+		var err error
+		if psk, err = mcast.ParseKey(*key); err != nil {
+			log.Fatalf("key: %v", err)
+		}
+	}
+	rx, err := mcast.NewReceiverWithOptions(*addr, mcast.ReceiverOptions{Interface: *ifname, Source: *source, JoinAllInterfaces: *joinAll, NoLoopback: *noLoopback, Key: psk})
+	if err != nil {
+		log.Fatalf("receiver: %v", err)
+	}
+	defer rx.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		_ = rx.Close()
+	}()
+	go printStats(ctx, rx, *interval, *pending)
+
+	for {
+		img, err := rx.Next()
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			slog.Warn("rx", "err", err)
+			time.Sleep(500 * time.Millisecond)
+			continue
+		}
+		if fb == nil {
+			continue
+		}
+		m, err := jpeg.Decode(bytes.NewReader(img))
+		if err != nil {
+			slog.Warn("decode", "err", err)
+			continue
+		}
+		if err := fb.show(m); err != nil {
+			slog.Warn("framebuffer", "err", err)
+		}
+	}
+}
+
+// printStats writes one line per interval with frame rate and loss counters,
+// followed with pending set by one line per partial frame.
+func printStats(ctx context.Context, rx *mcast.Receiver, interval time.Duration, pending bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	prev := rx.Stats()
+	prevT := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			st := rx.Stats()
+			dt := now.Sub(prevT).Seconds()
+			fps := float64(st.Frames-prev.Frames) / dt
+			lost := st.Incomplete - prev.Incomplete
+			var loss float64
+			if n := st.Frames - prev.Frames + lost; n > 0 {
+				loss = 100 * float64(lost) / float64(n)
+			}
+			fmt.Printf("fps=%.2f pps=%.0f frames=%d lost=%d dropped=%d loss=%.1f%%\n",
+				fps, float64(st.Packets-prev.Packets)/dt, st.Frames, st.Incomplete, st.Dropped, loss)
+			if down := rx.DownInterfaces(); len(down) > 0 {
+				fmt.Printf("  interface down: %s\n", strings.Join(down, ","))
+			}
+			if pending {
+				for _, p := range rx.PendingFrames() {
+					fmt.Printf("  pending frame=%d fragments=%d/%d age=%v\n", p.FrameID, p.Received, p.Total, p.Age.Round(time.Millisecond))
+				}
+			}
+			prev, prevT = st, now
+		}
+	}
+}
+
+// fit scales m to fill w x h preserving aspect ratio, centered on black.
+func fit(m image.Image, w, h int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	sw, sh := m.Bounds().Dx(), m.Bounds().Dy()
+	scale := float64(w) / float64(sw)
+	if s := float64(h) / float64(sh); s < scale {
+		scale = s
+	}
+	nw, nh := int(float64(sw)*scale), int(float64(sh)*scale)
+	offX, offY := (w-nw)/2, (h-nh)/2
+	draw2.ApproxBiLinear.Scale(dst, image.Rect(offX, offY, offX+nw, offY+nh), m, m.Bounds(), draw2.Src, nil)
+	return dst
+}
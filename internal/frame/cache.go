@@ -0,0 +1,149 @@
+package frame
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// The slide cache keeps decoded and scaled slides on disk as raw RGBA, so a
+// restart with the same slides and settings skips decoding and rescaling.
+// Entries are keyed by everything that affects the pixels (source path, size
+// and mtime, geometry, scale mode, background and scaler), so a changed file
+// or setting simply misses and gets a new entry; orphaned entries are never
+// read again and can be deleted at any time.
+
+// cacheScaler names the scaling code; bump it when decodeSlide or renderSVG
+// start producing different pixels.
+const cacheScaler = "approx-bilinear/1"
+
+var cacheMagic = [8]byte{'c', 'b', 't', 'v', 'r', 'g', 'b', 'a'}
+
+var cacheDir string
+
+// SetCacheDir enables the on-disk cache of decoded slides in dir, creating it
+// if needed; "" disables it. Only local slide directories are cached.
+func SetCacheDir(dir string) error {
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+	mu.Lock()
+	cacheDir = dir
+	mu.Unlock()
+	return nil
+}
+
+// cacheKey identifies the rendering of file p with the current settings, or
+// returns "" if p can't be stat'ed.
+func cacheKey(p string) string {
+	fi, err := os.Stat(p)
+	if err != nil {
+		return ""
+	}
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		abs = p
+	}
+	mu.RLock()
+	r, g, b, a := background.RGBA()
+	key := fmt.Sprintf("%s\x00%d\x00%d\x00%dx%d\x00%s\x00%04x%04x%04x%04x\x00%s",
+		abs, fi.Size(), fi.ModTime().UnixNano(), frameW, frameH, scaleMode, r, g, b, a, cacheScaler)
+	mu.RUnlock()
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// cacheLoad returns the cached slide for key, or nil on a miss.
+func cacheLoad(dir, key string) *image.RGBA {
+	b, err := os.ReadFile(filepath.Join(dir, key+".rgba"))
+	if err != nil || len(b) < 16 || !bytes.Equal(b[:8], cacheMagic[:]) {
+		return nil
+	}
+	w, h := int(binary.BigEndian.Uint32(b[8:12])), int(binary.BigEndian.Uint32(b[12:16]))
+	if w <= 0 || h <= 0 || w > MaxDimension || h > MaxDimension || len(b) != 16+w*h*4 {
+		return nil
+	}
+	return &image.RGBA{Pix: b[16:], Stride: w * 4, Rect: image.Rect(0, 0, w, h)}
+}
+
+// cacheStore writes img under key, atomically so a crash never leaves a
+// truncated entry behind.
+func cacheStore(dir, key string, img *image.RGBA) error {
+	f, err := os.CreateTemp(dir, key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	var hdr [16]byte
+	copy(hdr[:8], cacheMagic[:])
+	binary.BigEndian.PutUint32(hdr[8:12], uint32(img.Rect.Dx()))
+	binary.BigEndian.PutUint32(hdr[12:16], uint32(img.Rect.Dy()))
+	if _, err := f.Write(hdr[:]); err != nil {
+		f.Close()
+		return err
+	}
+	for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+		off := img.PixOffset(img.Rect.Min.X, y)
+		if _, err := f.Write(img.Pix[off : off+img.Rect.Dx()*4]); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filepath.Join(dir, key+".rgba"))
+}
+
+// decodeLocal decodes the slide files at paths like decodeAll, going through
+// the slide cache when one is configured.
+func decodeLocal(paths []string) ([]image.Image, []error) {
+	open := func(p string) (io.ReadCloser, error) { return os.Open(p) }
+	mu.RLock()
+	dir := cacheDir
+	mu.RUnlock()
+	if dir == "" {
+		return decodeAll(paths, open)
+	}
+	var imgs []image.Image
+	var errs []error
+	hits := 0
+	for _, p := range paths {
+		key := cacheKey(p)
+		if key != "" {
+			if img := cacheLoad(dir, key); img != nil {
+				imgs = append(imgs, img)
+				hits++
+				continue
+			}
+		}
+		got, e := decodeAll([]string{p}, open)
+		errs = append(errs, e...)
+		if len(got) == 0 {
+			continue
+		}
+		imgs = append(imgs, got[0])
+		if key != "" {
+			if err := cacheStore(dir, key, got[0].(*image.RGBA)); err != nil {
+				slog.Warn("slide cache write failed", "path", p, "err", err)
+			}
+		}
+	}
+	// lazy loading decodes one slide at a time: keep that out of the info log
+	lvl := slog.LevelInfo
+	if len(paths) == 1 {
+		lvl = slog.LevelDebug
+	}
+	slog.Log(context.Background(), lvl, "slide cache", "dir", dir, "hits", hits, "misses", len(paths)-hits)
+	return imgs, errs
+}
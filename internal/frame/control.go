@@ -0,0 +1,99 @@
+package frame
+
+import "errors"
+
+// Runtime slideshow control, for kiosks and operators: Pause holds the slide
+// on air, and Next and Prev change slides by hand whether paused or not.
+
+var (
+	paused bool
+	// manualFade is set while a fade started by Next(true), or one that was
+	// running when Pause was called, plays out; it completes even when paused
+	manualFade bool
+)
+
+var errNoSlideshow = errors.New("no slideshow running")
+
+// Pause stops automatic slide changes. A fade already under way still
+// finishes, and the slide it leads to is then held.
+func Pause() {
+	mu.Lock()
+	defer mu.Unlock()
+	if paused {
+		return
+	}
+	paused = true
+	if len(slides) > 0 && fadeDuration > 0 && clock().Sub(lastAdvance) >= interval-fadeDuration {
+		manualFade = true
+	}
+}
+
+// Resume restarts automatic slide changes after Pause. The slide on air is
+// then shown for a full interval.
+func Resume() {
+	mu.Lock()
+	defer mu.Unlock()
+	if !paused {
+		return
+	}
+	paused = false
+	if !manualFade {
+		lastAdvance = clock()
+	}
+}
+
+// Paused reports whether the slideshow is paused.
+func Paused() bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return paused
+}
+
+// Next moves to the next slide now. With fade set and a fade duration
+// configured (see SetFade) it runs the usual transition first instead of
+// cutting; a fade already under way is left to finish.
+func Next(fade bool) error {
+	mu.Lock()
+	defer mu.Unlock()
+	if len(slides) == 0 {
+		return errNoSlideshow
+	}
+	now := clock()
+	if fade && fadeDuration > 0 {
+		if start := interval - fadeDuration; now.Sub(lastAdvance) < start {
+			// jump to the start of the fade window
+			lastAdvance = now.Add(-start)
+		}
+		manualFade = true
+		return nil
+	}
+	advance()
+	lastAdvance, manualFade = now, false
+	prefetch(upcoming())
+	return nil
+}
+
+// Prev moves back to the previous slide now, always as a cut: transitions
+// only run towards the next slide. In shuffle mode, going back from the
+// first slide of a cycle shows the last one of the same cycle.
+func Prev() error {
+	mu.Lock()
+	defer mu.Unlock()
+	if len(slides) == 0 {
+		return errNoSlideshow
+	}
+	cur--
+	if cur < 0 {
+		cur = len(slides) - 1
+	}
+	lastAdvance, manualFade = clock(), false
+	prefetch(slides[cur])
+	return nil
+}
+
+// holding reports whether the slide on air must stay as it is: when it is
+// the only one, there is nothing to fade or advance to, and when paused
+// there is no fade left to finish. mu must be held.
+func holding() bool {
+	return len(slides) <= 1 || paused && !manualFade
+}
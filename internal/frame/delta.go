@@ -0,0 +1,100 @@
+package frame
+
+import (
+	"bytes"
+	"image"
+	"image/draw"
+)
+
+// deltaBlock is the grid changed rectangles are widened to, matching JPEG's
+// 16x16 MCUs so a region's block edges line up with the keyframe's.
+const deltaBlock = 16
+
+// deltaMaxArea is the fraction of the frame above which a change is sent as
+// a keyframe rather than a region.
+const deltaMaxArea = 0.5
+
+// deltaKey is the last keyframe returned by GenerateDelta, guarded by mu.
+var deltaKey *image.RGBA
+
+// GenerateDelta renders a frame like GenerateFrame, for streams that send
+// only what changed. It returns the whole frame, which becomes the new
+// keyframe, when key is set, when there is no keyframe at the current
+// geometry or when more than half of the frame changed. Otherwise it returns
+// the smallest rectangle on a 16 pixel grid that holds every difference from
+// the keyframe, encoded on its own, and that rectangle's top-left corner; a
+// frame identical to the keyframe gives its top-left block. Receivers
+// rebuild the frame by drawing the region over the keyframe.
+func GenerateDelta(key bool) (b []byte, at image.Point, isKey bool, err error) {
+	img, info, release := render(true)
+	defer release()
+	rgba, ok := img.(*image.RGBA)
+	mu.RLock()
+	prev := deltaKey
+	mu.RUnlock()
+	if key || !ok || prev == nil || prev.Rect != rgba.Rect {
+		return keyframe(img, info)
+	}
+	r := changedRect(prev, rgba)
+	if r.Empty() {
+		r = image.Rect(0, 0, deltaBlock, deltaBlock).Intersect(rgba.Rect)
+	}
+	if float64(r.Dx()*r.Dy()) > deltaMaxArea*float64(rgba.Rect.Dx()*rgba.Rect.Dy()) {
+		return keyframe(img, info)
+	}
+	b, err = encodeFrame(rgba.SubImage(r), info)
+	return b, r.Min, false, err
+}
+
+// keyframe encodes img in full and keeps a copy of it as the keyframe.
+func keyframe(img image.Image, info FrameInfo) ([]byte, image.Point, bool, error) {
+	b, err := encodeFrame(img, info)
+	if err != nil {
+		return nil, image.Point{}, false, err
+	}
+	mu.Lock()
+	if deltaKey == nil || deltaKey.Rect != img.Bounds() {
+		deltaKey = image.NewRGBA(img.Bounds())
+	}
+	key := deltaKey
+	mu.Unlock()
+	if rgba, ok := img.(*image.RGBA); ok && rgba.Stride == key.Stride {
+		copy(key.Pix, rgba.Pix)
+	} else {
+		draw.Draw(key, key.Rect, img, img.Bounds().Min, draw.Src)
+	}
+	return b, image.Point{}, true, nil
+}
+
+// changedRect returns the bounding box of the pixels that differ between a
+// and b, which have the same bounds, widened to the deltaBlock grid; it is
+// empty if they are identical.
+func changedRect(a, b *image.RGBA) image.Rectangle {
+	w := a.Rect.Dx() * 4
+	minX, minY, maxX, maxY := w, -1, -1, -1
+	for y := range a.Rect.Dy() {
+		ra := a.Pix[y*a.Stride : y*a.Stride+w]
+		rb := b.Pix[y*b.Stride : y*b.Stride+w]
+		if bytes.Equal(ra, rb) {
+			continue
+		}
+		if minY < 0 {
+			minY = y
+		}
+		maxY = y
+		l := 0
+		for ra[l] == rb[l] {
+			l++
+		}
+		r := w - 1
+		for ra[r] == rb[r] {
+			r--
+		}
+		minX, maxX = min(minX, l/4), max(maxX, r/4)
+	}
+	if minY < 0 {
+		return image.Rectangle{}
+	}
+	r := image.Rect(minX&^(deltaBlock-1), minY&^(deltaBlock-1), (maxX+deltaBlock)&^(deltaBlock-1), (maxY+deltaBlock)&^(deltaBlock-1))
+	return r.Add(a.Rect.Min).Intersect(a.Rect)
+}
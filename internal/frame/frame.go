@@ -3,25 +3,30 @@ package frame
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"image"
 	"image/color"
 	"image/draw"
 	"image/jpeg"
+	"io"
 	"io/fs"
+	"log/slog"
 	"os"
+	"path"
 	"path/filepath"
 	"runtime"
-	"sort"
+	"strings"
 	"sync"
 	"time"
 
+	_ "github.com/gen2brain/avif"
 	_ "golang.org/x/image/bmp"
 	draw2 "golang.org/x/image/draw"
 	xfont "golang.org/x/image/font"
 	"golang.org/x/image/font/basicfont"
 	"golang.org/x/image/math/fixed"
+	_ "golang.org/x/image/webp"
 	_ "image/gif"
-	_ "image/jpeg"
 	_ "image/png"
 )
 
@@ -31,15 +36,29 @@ var (
 	frameH = 1080
 
 	mu            sync.RWMutex
+	source        string // directory or URL given to StartSlideshow
 	slides        []image.Image
+	pending       []image.Image // next cycle's order in shuffle mode
 	cur           int
 	lastAdvance   time.Time
 	interval      = 1 * time.Second
 	fadeDuration  = 0 * time.Second
 	quality       = 80
 	showTimestamp = false
+	order         = OrderName
+	// defaultSlide is shown instead of the pattern or clock frame while
+	// there are no slides; see SetDefaultSlide
+	defaultSlide *image.RGBA
 )
 
+// errNoImages is wrapped by the errors for a slide source that is there
+// but holds no images, as opposed to one that can't be read.
+var errNoImages = errors.New("no images found")
+
+// clock is the time source for slide changes, fades and the timestamp
+// overlay; tests replace it to control elapsed time.
+var clock = time.Now
+
 // SetGeometry sets the output frame width and height (in pixels).
 func SetGeometry(w, h int) {
 	if w <= 0 || h <= 0 {
@@ -51,25 +70,141 @@ func SetGeometry(w, h int) {
 	mu.Unlock()
 }
 
-// StartSlideshow loads images from dir and begins cycling them every dt.
+// MaxDimension is the largest frame width or height ParseGeometry accepts.
+const MaxDimension = 8192
+
+// geometryAliases maps common resolution names to WIDTHxHEIGHT.
+var geometryAliases = map[string][2]int{
+	"vga":   {640, 480},
+	"svga":  {800, 600},
+	"xga":   {1024, 768},
+	"720p":  {1280, 720},
+	"1080p": {1920, 1080},
+	"1440p": {2560, 1440},
+	"2160p": {3840, 2160},
+	"4k":    {3840, 2160},
+}
+
+// ParseGeometry parses WIDTHxHEIGHT (e.g. "1280x720") or one of the aliases
+// vga, svga, xga, 720p, 1080p, 1440p, 2160p and 4k.
+func ParseGeometry(s string) (w, h int, err error) {
+	if g, ok := geometryAliases[strings.ToLower(strings.TrimSpace(s))]; ok {
+		return g[0], g[1], nil
+	}
+	var rest string
+	if n, _ := fmt.Sscanf(strings.ToLower(s), "%dx%d%s", &w, &h, &rest); n != 2 {
+		return 0, 0, fmt.Errorf("bad geometry %q: want WIDTHxHEIGHT or an alias like 720p", s)
+	}
+	if w <= 0 || h <= 0 || w > MaxDimension || h > MaxDimension {
+		return 0, 0, fmt.Errorf("bad geometry %q: each dimension must be between 1 and %d", s, MaxDimension)
+	}
+	return w, h, nil
+}
+
+// ParseColor parses a hex colour: "#rgb" or "#rrggbb", with or without the #.
+func ParseColor(s string) (color.RGBA, error) {
+	h := strings.TrimPrefix(strings.TrimSpace(s), "#")
+	if len(h) == 3 {
+		h = string([]byte{h[0], h[0], h[1], h[1], h[2], h[2]})
+	}
+	var c color.RGBA
+	if n, err := fmt.Sscanf(h, "%02x%02x%02x", &c.R, &c.G, &c.B); len(h) != 6 || n != 3 || err != nil {
+		return color.RGBA{}, fmt.Errorf("bad colour %q: want #rrggbb or #rgb", s)
+	}
+	c.A = 255
+	return c, nil
+}
+
+// StartSlideshow loads images from dir and begins cycling them every dt. dir
+// may also be an http(s) URL pointing to a JSON manifest or a directory index,
+// or Stdin to read a playlist of paths, shown in the given order, from
+// standard input. Each Reload then reads the next playlist. If a default
+// slide is set (see SetDefaultSlide), a source with no images yet is not an
+// error: the default slide is shown until a Reload finds some.
 func StartSlideshow(dir string, dt time.Duration) error {
 	imgs, err := loadImages(dir)
-	if err != nil {
+	if err == nil && len(imgs) == 0 {
+		err = errNoImages
+	}
+	mu.RLock()
+	standby := defaultSlide != nil
+	mu.RUnlock()
+	if err != nil && !(standby && errors.Is(err, errNoImages)) {
 		return err
 	}
-	if len(imgs) == 0 {
-		return errors.New("no images found")
+	if err != nil {
+		slog.Info("no slides yet, showing the default slide", "src", dir, "reason", err)
 	}
 
 	mu.Lock()
+	source = dir
 	slides = imgs
+	pending = nil
+	clear(slideQuality)
 	cur = 0
-	lastAdvance = time.Now()
+	lastAdvance, manualFade = clock(), false
 	interval = dt
 	mu.Unlock()
 	return nil
 }
 
+// Reload re-reads the slides from the source given to StartSlideshow and
+// swaps them in without restarting the show. If loading fails or finds no
+// images the current slides are kept and an error is returned, except that
+// a source left with no images switches to the default slide when one is set.
+func Reload() error {
+	mu.RLock()
+	src := source
+	mu.RUnlock()
+	if src == "" {
+		return errors.New("no slideshow running")
+	}
+	imgs, err := loadImages(src)
+	if err == nil && len(imgs) == 0 {
+		err = errNoImages
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if err != nil {
+		if defaultSlide == nil || !errors.Is(err, errNoImages) {
+			return err
+		}
+		if len(slides) > 0 {
+			slog.Info("slides gone, showing the default slide", "src", src, "reason", err)
+		}
+	}
+	if len(slides) == 0 {
+		// back from the default slide: give the first one a full interval
+		lastAdvance, manualFade = clock(), false
+	}
+	slides = imgs
+	pending = nil
+	clear(slideQuality)
+	if cur >= len(slides) {
+		cur = 0
+	}
+	return nil
+}
+
+// SetDefaultSlide loads the image at path, scaled to the current geometry
+// like any slide, to show whenever the slideshow has no slides: when its
+// source is empty at start or is emptied later, instead of the test pattern
+// or clock frame. An empty path removes it.
+func SetDefaultSlide(path string) error {
+	var img *image.RGBA
+	if path != "" {
+		imgs, errs := decodeAll([]string{path}, func(p string) (io.ReadCloser, error) { return os.Open(p) })
+		if len(errs) > 0 {
+			return errs[0]
+		}
+		img = imgs[0].(*image.RGBA)
+	}
+	mu.Lock()
+	defaultSlide = img
+	mu.Unlock()
+	return nil
+}
+
 // SetFade sets a crossfade duration between slides. A zero duration disables fading.
 func SetFade(d time.Duration) {
 	mu.Lock()
@@ -77,6 +212,31 @@ func SetFade(d time.Duration) {
 	mu.Unlock()
 }
 
+// Fade returns the crossfade duration set with SetFade.
+func Fade() time.Duration {
+	mu.RLock()
+	defer mu.RUnlock()
+	return fadeDuration
+}
+
+// SetInterval changes how long each slide is shown, from the slideshow's
+// next frame on. Non-positive durations are ignored.
+func SetInterval(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	mu.Lock()
+	interval = d
+	mu.Unlock()
+}
+
+// Interval returns how long each slide is shown.
+func Interval() time.Duration {
+	mu.RLock()
+	defer mu.RUnlock()
+	return interval
+}
+
 // SetQuality sets the JPEG encoding quality (1-100)
 func SetQuality(q int) {
 	if q < 1 {
@@ -90,6 +250,33 @@ func SetQuality(q int) {
 	mu.Unlock()
 }
 
+// Quality returns the JPEG encoding quality.
+func Quality() int {
+	mu.RLock()
+	defer mu.RUnlock()
+	return quality
+}
+
+// CurrentSlide returns the index of the slide on air and the number of
+// slides; both are 0 when there is no slideshow.
+func CurrentSlide() (index, count int) {
+	mu.RLock()
+	defer mu.RUnlock()
+	return cur, len(slides)
+}
+
+var background color.Color = color.Black
+
+// SetBackground sets the colour behind letterboxed slides (and transparent
+// areas of slides) and of the plain frame shown when there are no slides.
+// Slides are letterboxed when loaded, so set it before StartSlideshow or
+// call Reload afterwards.
+func SetBackground(c color.Color) {
+	mu.Lock()
+	background = c
+	mu.Unlock()
+}
+
 // SetTimestamp enables or disables drawing the timestamp overlay.
 func SetTimestamp(enabled bool) {
 	mu.Lock()
@@ -97,9 +284,39 @@ func SetTimestamp(enabled bool) {
 	mu.Unlock()
 }
 
+// Timestamp reports whether the timestamp overlay is drawn.
+func Timestamp() bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return showTimestamp
+}
+
+// SetOrder selects how slides are ordered when the slideshow is loaded: one of
+// "name" (lexicographic, the default), "natural" (numeric-aware), "mtime"
+// (oldest first) or "shuffle" (random, reshuffled on every full cycle).
+func SetOrder(mode string) error {
+	switch mode {
+	case OrderName, OrderNatural, OrderMtime, OrderShuffle:
+	default:
+		return fmt.Errorf("unknown slide order %q", mode)
+	}
+	mu.Lock()
+	order = mode
+	mu.Unlock()
+	return nil
+}
+
 // loadImages finds supported image files in the directory and decodes them.
+// An http(s) URL is fetched as a manifest or directory index instead.
 func loadImages(dir string) ([]image.Image, error) {
+	if isRemote(dir) {
+		return loadRemote(dir)
+	}
+	if dir == Stdin {
+		return loadPlaylist()
+	}
 	var paths []string
+	files := 0
 	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
@@ -107,9 +324,8 @@ func loadImages(dir string) ([]image.Image, error) {
 		if d.IsDir() {
 			return nil
 		}
-		ext := filepath.Ext(p)
-		switch ext {
-		case ".jpg", ".jpeg", ".png", ".gif", ".bmp":
+		files++
+		if isImageExt(filepath.Ext(p)) {
 			paths = append(paths, p)
 		}
 		return nil
@@ -117,156 +333,302 @@ func loadImages(dir string) ([]image.Image, error) {
 	if err != nil {
 		return nil, err
 	}
-	sort.Strings(paths)
+	if files == 0 {
+		return nil, fmt.Errorf("%w: %s has no files", errNoImages, dir)
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("%w: %s has no supported images (%d files skipped by extension)", errNoImages, dir, files)
+	}
+	mu.RLock()
+	mode := order
+	mu.RUnlock()
+	sortPaths(paths, mode)
+	return decodePaths(dir, paths)
+}
+
+// decodePaths loads the slide files at paths, in order, for source src.
+func decodePaths(src string, paths []string) ([]image.Image, error) {
+	mu.RLock()
+	lazy := lazyLoad
+	mu.RUnlock()
+	var imgs []image.Image
+	var errs []error
+	if lazy {
+		imgs, errs = lazySlides(paths)
+	} else {
+		imgs, errs = decodeLocal(paths)
+	}
+	return imgs, loadSummary(src, len(paths), imgs, errs)
+}
+
+// loadSummary reports how loading n candidate slides from src went: an error
+// wrapping every per-file failure if nothing loaded, otherwise a warning per
+// failed file and a one-line summary in the log.
+func loadSummary(src string, n int, imgs []image.Image, errs []error) error {
+	if len(imgs) == 0 && len(errs) > 0 {
+		return fmt.Errorf("all %d images in %s failed to load: %w", n, src, errors.Join(errs...))
+	}
+	for _, err := range errs {
+		slog.Warn("skipping slide", "err", err)
+	}
+	if len(errs) > 0 {
+		slog.Info(fmt.Sprintf("loaded %d of %d images, %d failed", len(imgs), n, len(errs)), "src", src)
+	}
+	return nil
+}
+
+// isImageExt reports whether ext (including the dot) is a supported slide format.
+func isImageExt(ext string) bool {
+	switch strings.ToLower(ext) {
+	case ".jpg", ".jpeg", ".png", ".gif", ".bmp", ".webp", ".avif", ".svg":
+		return true
+	}
+	return false
+}
+
+// decodeAll opens and decodes each named slide in order, skipping the ones
+// that fail and returning an error for each of those.
+func decodeAll(names []string, open func(string) (io.ReadCloser, error)) ([]image.Image, []error) {
 	var imgs []image.Image
-	for _, p := range paths {
-		f, err := os.Open(p)
+	var errs []error
+	for _, p := range names {
+		f, err := open(p)
 		if err != nil {
+			errs = append(errs, err)
 			continue
 		}
-		img, _, err := image.Decode(f)
+		var img *image.RGBA
+		if strings.EqualFold(path.Ext(p), ".svg") {
+			img, err = renderSVG(f)
+		} else {
+			img, err = decodeSlide(f)
+		}
 		f.Close()
 		if err != nil {
+			errs = append(errs, fmt.Errorf("decode %s: %w", p, err))
 			continue
 		}
-		// scale / center to configured geometry
-		mu.RLock()
-		fw, fh := frameW, frameH
-		mu.RUnlock()
-		dst := image.NewRGBA(image.Rect(0, 0, fw, fh))
-		draw2.Draw(dst, dst.Bounds(), &image.Uniform{C: color.Black}, image.Point{}, draw2.Src)
-		// fit preserving aspect
-		w := img.Bounds().Dx()
-		h := img.Bounds().Dy()
-		rw := float64(fw) / float64(w)
-		rh := float64(fh) / float64(h)
-		scale := rw
-		if rh < rw {
-			scale = rh
-		}
-		nw := int(float64(w) * scale)
-		nh := int(float64(h) * scale)
-		// center
-		offX := (fw - nw) / 2
-		offY := (fh - nh) / 2
-		tmp := image.NewRGBA(image.Rect(0, 0, nw, nh))
-		draw2.ApproxBiLinear.Scale(tmp, tmp.Bounds(), img, img.Bounds(), draw2.Over, nil)
-		draw.Draw(dst, image.Rect(offX, offY, offX+nw, offY+nh), tmp, image.Point{}, draw.Src)
-		imgs = append(imgs, dst)
+		imgs = append(imgs, img)
+	}
+	return imgs, errs
+}
+
+// decodeSlide decodes an image and scales / centers it to the configured geometry.
+func decodeSlide(r io.Reader) (*image.RGBA, error) {
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return nil, err
 	}
-	return imgs, nil
+	return fitSlide(img), nil
+}
+
+// fitSlide scales / centers img on a new canvas at the configured geometry,
+// the form every slide is kept in.
+func fitSlide(img image.Image) *image.RGBA {
+	mu.RLock()
+	fw, fh, bg, mode := frameW, frameH, background, scaleMode
+	mu.RUnlock()
+	dst := image.NewRGBA(image.Rect(0, 0, fw, fh))
+	draw2.Draw(dst, dst.Bounds(), &image.Uniform{C: bg}, image.Point{}, draw2.Src)
+	// scale into place per the scale mode; Scale clips an overhanging
+	// rectangle (fill) to dst, and Over lets the background show through
+	// transparent areas
+	dr := placementRect(mode, img.Bounds(), fw, fh)
+	draw2.ApproxBiLinear.Scale(dst, dr, img, img.Bounds(), draw2.Over, nil)
+	return dst
 }
 
 // GenerateFrame returns the current slide as a JPEG, advancing if interval elapsed.
 func GenerateFrame() ([]byte, error) {
+	img, info, release := render(true)
+	defer release()
+	return encodeFrame(img, info)
+}
+
+// Snapshot returns the frame currently on air as a JPEG, like GenerateFrame
+// but without changing the slideshow: a slide that is due is not advanced
+// to, so previews and thumbnails can call it at any time.
+func Snapshot() ([]byte, error) {
+	img, info, release := render(false)
+	defer release()
+	return encode(img, info.Quality)
+}
+
+// ErrorFrame returns a w x h JPEG that says ENCODER ERROR with msg, for
+// putting a failing stream's problem on screen. It draws on its own canvas
+// and encodes with the standard baseline encoder, so it doesn't depend on
+// whatever made GenerateFrame fail.
+func ErrorFrame(w, h int, msg string) ([]byte, error) {
+	// draw small and scale up, as the label font is only 13 pixels high
+	scale := max(1, w/480)
+	small := image.NewRGBA(image.Rect(0, 0, max(w/scale, 1), max(h/scale, 1)))
+	draw.Draw(small, small.Rect, &image.Uniform{C: color.RGBA{128, 0, 0, 255}}, image.Point{}, draw.Src)
+	if n := (small.Rect.Dx() - 40) / 7; n > 3 && len(msg) > n {
+		msg = msg[:n-3] + "..."
+	}
+	y := small.Rect.Dy()/2 - 13
+	for _, line := range []string{"ENCODER ERROR", msg, clock().Format("2006-01-02 15:04:05")} {
+		addLabel(small, 20, y, line)
+		y += 20
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw2.NearestNeighbor.Scale(dst, dst.Rect, small, small.Rect, draw2.Src, nil)
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 75}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// render produces the current frame at the configured geometry and returns
+// it with a FrameInfo holding the JPEG quality to encode it at, the slide and
+// whether it is a fade frame. It advances the slideshow when
+// the current slide is due if advancing is set. The image may be a shared slide
+// or a pooled canvas: it must not be modified, and release must be called
+// once it is no longer needed.
+func render(advancing bool) (image.Image, FrameInfo, func()) {
+	noop := func() {}
 	mu.Lock()
 	fw, fh := frameW, frameH
-	if len(slides) == 0 {
+	bg := background
+	ov := frameOverlay(showTimestamp)
+	info := FrameInfo{Quality: quality}
+	now := clock()
+	if len(slides) == 0 && defaultSlide == nil {
+		pat := patternImage(fw, fh)
+		sysmon := pattern == PatternSysmon
+		if sysmon {
+			// carries its own clock
+			ov = frameOverlay(false)
+		} else if pat == nil {
+			// the fallback frame is just the timestamp
+			ov = frameOverlay(true)
+		}
 		mu.Unlock()
-		// fallback: generate a simple timestamp image
-		dst := image.NewRGBA(image.Rect(0, 0, fw, fh))
-		draw2.Draw(dst, dst.Bounds(), &image.Uniform{C: color.Black}, image.Point{}, draw2.Src)
-		addLabel(dst, 20, fh-30, time.Now().Format("2006-01-02 15:04:05"))
-		var buf bytes.Buffer
-		mu.RLock()
-		q := quality
-		mu.RUnlock()
-		if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: q}); err != nil {
-			return nil, err
+		dst := getCanvas(fw, fh)
+		if sysmon {
+			drawSysmon(dst)
+		} else if pat != nil {
+			// test pattern, with the overlays on top
+			draw.Draw(dst, dst.Bounds(), pat, image.Point{}, draw.Src)
+		} else {
+			// fallback: a plain background under the timestamp
+			draw2.Draw(dst, dst.Bounds(), &image.Uniform{C: bg}, image.Point{}, draw2.Src)
+		}
+		if ov != nil {
+			ov(dst, now)
 		}
-		return buf.Bytes(), nil
+		return dst, info, func() { putCanvas(dst) }
 	}
-	now := time.Now()
 	elapsed := now.Sub(lastAdvance)
 	var img image.Image
 	// determine if we should advance slide or produce a blended frame
-	if elapsed >= interval {
-		cur = (cur + 1) % len(slides)
-		lastAdvance = now
+	if len(slides) == 0 {
+		img = defaultSlide
+		mu.Unlock()
+	} else if advancing && elapsed >= interval && !holding() {
+		advance()
+		lastAdvance, manualFade = now, false
 		img = slides[cur]
+		info.Slide = cur
+		prefetch(upcoming())
 		mu.Unlock()
-	} else if fadeDuration > 0 && elapsed >= interval-fadeDuration {
+	} else if !holding() && fadeDuration > 0 && elapsed >= interval-fadeDuration && elapsed < interval {
 		// produce blended image between cur and next
-		next := (cur + 1) % len(slides)
 		// copy references while holding lock then release
-		a := slides[cur].(*image.RGBA)
-		b := slides[next].(*image.RGBA)
+		na, nb := slides[cur], upcoming()
+		kind := transition
+		alpha := fadeProgress(elapsed)
+		workers := blendWorkers()
+		gamma := gammaCorrect
+		info.Slide, info.Fade = cur, true
+		info.Quality = fadeQuality(na, nb, info.Quality)
 		mu.Unlock()
-		// compute alpha in [0,1]
-		alpha := float64(elapsed-(interval-fadeDuration)) / float64(fadeDuration)
-		if alpha < 0 {
-			alpha = 0
-		}
-		if alpha > 1 {
-			alpha = 1
+		a, b := decoded(na), decoded(nb)
+		// composite the transition in parallel by rows
+		rgba := getCanvas(fw, fh)
+		parallelRows(transitionRow(kind, gamma, alpha, a, b, rgba), fh, workers)
+		// the blended canvas is ours, so overlays can go straight on it
+		if ov != nil {
+			ov(rgba, now)
 		}
-		// blend per-pixel in parallel by rows
-		rgba := image.NewRGBA(image.Rect(0, 0, fw, fh))
-		apix := a.Pix
-		bpix := b.Pix
-		dpix := rgba.Pix
-		stride := rgba.Stride
-		// decide workers
-		workers := 4
-		if n := runtime.NumCPU(); n > workers {
-			workers = n
-		}
-		var wg sync.WaitGroup
-		rowsPer := fh / workers
-		for w := 0; w < workers; w++ {
-			startRow := w * rowsPer
-			endRow := startRow + rowsPer
-			if w == workers-1 {
-				endRow = fh
-			}
-			wg.Add(1)
-			go func(sr, er int) {
-				defer wg.Done()
-				for y := sr; y < er; y++ {
-					rowStart := y * stride
-					for x := 0; x < fw; x++ {
-						i := rowStart + x*4
-						ar := float64(apix[i])
-						ag := float64(apix[i+1])
-						ab := float64(apix[i+2])
-						aa := float64(apix[i+3])
-						br := float64(bpix[i])
-						bg := float64(bpix[i+1])
-						bb := float64(bpix[i+2])
-						ba := float64(bpix[i+3])
-						dpix[i] = uint8((1-alpha)*ar + alpha*br)
-						dpix[i+1] = uint8((1-alpha)*ag + alpha*bg)
-						dpix[i+2] = uint8((1-alpha)*ab + alpha*bb)
-						dpix[i+3] = uint8((1-alpha)*aa + alpha*ba)
-					}
-				}
-			}(startRow, endRow)
-		}
-		wg.Wait()
-		img = rgba
+		return rgba, info, func() { putCanvas(rgba) }
 	} else {
 		img = slides[cur]
+		info.Slide = cur
 		mu.Unlock()
 	}
+	slide := img
+	img = decoded(img)
+	info.Quality = targetQuality(slide, img, info.Quality)
 
-	// overlay timestamp (optional)
-	rgba := image.NewRGBA(image.Rect(0, 0, fw, fh))
+	// slides are shared, so only copy one when overlays need drawing (or
+	// the geometry changed since it was loaded)
+	if ov == nil && img.Bounds() == image.Rect(0, 0, fw, fh) {
+		return img, info, noop
+	}
+	rgba := getCanvas(fw, fh)
 	draw.Draw(rgba, rgba.Bounds(), img, image.Point{}, draw.Src)
-	mu.RLock()
-	ts := showTimestamp
-	mu.RUnlock()
-	if ts {
-		addLabel(rgba, 20, fh-30, time.Now().Format("2006-01-02 15:04:05"))
+	if ov != nil {
+		ov(rgba, now)
 	}
+	return rgba, info, func() { putCanvas(rgba) }
+}
 
-	var buf bytes.Buffer
-	mu.RLock()
-	q := quality
-	mu.RUnlock()
-	if err := jpeg.Encode(&buf, rgba, &jpeg.Options{Quality: q}); err != nil {
-		return nil, err
+var numBlendWorkers int // 0 means max(4, NumCPU)
+
+// blendWorkers returns the number of goroutines to composite transitions
+// with: the SetBlendWorkers value, or max(4, NumCPU). mu must be held.
+func blendWorkers() int {
+	if numBlendWorkers > 0 {
+		return numBlendWorkers
 	}
-	return buf.Bytes(), nil
+	return max(4, runtime.NumCPU())
+}
+
+// SetBlendWorkers sets how many goroutines composite each transition frame.
+// Blending is memory-bound, so on hyperthreaded or many-core machines fewer
+// workers than CPUs can be faster; see BenchmarkBlendWorkers. 0 restores the
+// default of max(4, NumCPU).
+func SetBlendWorkers(n int) {
+	mu.Lock()
+	numBlendWorkers = max(n, 0)
+	mu.Unlock()
+}
+
+// parallelRows calls row for every y in [0, h), split into contiguous bands
+// across up to workers goroutines (see rowBands).
+func parallelRows(row func(y int), h, workers int) {
+	var wg sync.WaitGroup
+	for _, band := range rowBands(h, workers) {
+		wg.Add(1)
+		go func(sr, er int) {
+			defer wg.Done()
+			for y := sr; y < er; y++ {
+				row(y)
+			}
+		}(band[0], band[1])
+	}
+	wg.Wait()
+}
+
+// rowBands splits [0, h) into at most workers non-empty [start, end) bands
+// whose sizes differ by at most one row: the first h%workers bands take one
+// extra row each.
+func rowBands(h, workers int) [][2]int {
+	workers = max(min(workers, h), 1)
+	base, extra := h/workers, h%workers
+	bands := make([][2]int, 0, workers)
+	start := 0
+	for w := 0; w < workers && start < h; w++ {
+		n := base
+		if w < extra {
+			n++
+		}
+		bands = append(bands, [2]int{start, start + n})
+		start += n
+	}
+	return bands
 }
 
 func addLabel(img *image.RGBA, x, y int, label string) {
@@ -1,7 +1,26 @@
 package frame
 
 import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestGenerateFrame(t *testing.T) {
@@ -13,3 +32,1485 @@ func TestGenerateFrame(t *testing.T) {
 		t.Fatalf("frame too small: %d", len(b))
 	}
 }
+
+func TestNaturalLess(t *testing.T) {
+	paths := []string{"img10.jpg", "img2.jpg", "img1.jpg", "a/img02.jpg", "img.jpg"}
+	sortPaths(paths, OrderNatural)
+	want := []string{"a/img02.jpg", "img.jpg", "img1.jpg", "img2.jpg", "img10.jpg"}
+	for i := range want {
+		if paths[i] != want[i] {
+			t.Fatalf("natural order = %v, want %v", paths, want)
+		}
+	}
+}
+
+func TestPatternBars(t *testing.T) {
+	if err := SetPattern(PatternBars); err != nil {
+		t.Fatalf("SetPattern: %v", err)
+	}
+	defer SetPattern(PatternNone)
+	mu.Lock()
+	img := patternImage(700, 300)
+	mu.Unlock()
+	// first bar is 75% grey, last top bar is 75% blue
+	if c := img.RGBAAt(50, 10); c.R != 191 || c.G != 191 || c.B != 191 {
+		t.Fatalf("first bar = %v", c)
+	}
+	if c := img.RGBAAt(650, 10); c.R != 0 || c.G != 0 || c.B != 191 {
+		t.Fatalf("last bar = %v", c)
+	}
+	b, err := GenerateFrame()
+	if err != nil || len(b) < 100 {
+		t.Fatalf("GenerateFrame with pattern: %d bytes, %v", len(b), err)
+	}
+}
+
+func TestParseGeometry(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		w, h int
+		ok   bool
+	}{
+		{"1280x720", 1280, 720, true},
+		{"720p", 1280, 720, true},
+		{"4K", 3840, 2160, true},
+		{"vga", 640, 480, true},
+		{"1280x", 0, 0, false},
+		{"1280*720", 0, 0, false},
+		{"1280x720junk", 0, 0, false},
+		{"9000x100", 0, 0, false},
+		{"0x100", 0, 0, false},
+	} {
+		w, h, err := ParseGeometry(tc.in)
+		if (err == nil) != tc.ok || w != tc.w || h != tc.h {
+			t.Errorf("ParseGeometry(%q) = %d, %d, %v", tc.in, w, h, err)
+		}
+	}
+}
+
+// resetSlideshow clears package state a test may have changed.
+func resetSlideshow() {
+	mu.Lock()
+	source, slides, pending, cur = "", nil, nil, 0
+	paused, manualFade = false, false
+	mu.Unlock()
+}
+
+// showSlides starts a slideshow of in-memory images, fitted to the current
+// geometry like loaded slides, and restores the package state when t ends.
+func showSlides(t *testing.T, dt time.Duration, imgs ...image.Image) {
+	t.Helper()
+	fitted := make([]image.Image, len(imgs))
+	for i, img := range imgs {
+		fitted[i] = fitSlide(img)
+	}
+	mu.Lock()
+	oldInterval, oldFade, oldQuality := interval, fadeDuration, quality
+	slides, pending, cur = fitted, nil, 0
+	lastAdvance = clock()
+	interval = dt
+	mu.Unlock()
+	t.Cleanup(func() {
+		resetSlideshow()
+		mu.Lock()
+		interval, fadeDuration, quality = oldInterval, oldFade, oldQuality
+		mu.Unlock()
+	})
+}
+
+// fakeClock stops the package clock until t ends; it only moves when the
+// returned function is called.
+func fakeClock(t *testing.T) (advance func(time.Duration)) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	var cmu sync.Mutex
+	mu.Lock()
+	clock = func() time.Time {
+		cmu.Lock()
+		defer cmu.Unlock()
+		return now
+	}
+	mu.Unlock()
+	t.Cleanup(func() {
+		mu.Lock()
+		clock = time.Now
+		mu.Unlock()
+	})
+	return func(d time.Duration) {
+		cmu.Lock()
+		now = now.Add(d)
+		cmu.Unlock()
+	}
+}
+
+func pngBytes(t *testing.T, c color.Color) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	draw.Draw(img, img.Bounds(), &image.Uniform{C: c}, image.Point{}, draw.Src)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("png: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestRemoteSlides(t *testing.T) {
+	red, blue := pngBytes(t, color.RGBA{255, 0, 0, 255}), pngBytes(t, color.RGBA{0, 0, 255, 255})
+	var broken atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/index/":
+			if broken.Load() {
+				http.Error(w, "down", http.StatusInternalServerError)
+				return
+			}
+			fmt.Fprint(w, `<html><a href="../">..</a><a href="b.png">b.png</a><a href="a.png">a.png</a><a href="notes.txt">notes</a></html>`)
+		case "/manifest.json":
+			fmt.Fprint(w, `{"images": ["/index/b.png", "index/a.png"]}`)
+		case "/index/a.png":
+			w.Write(red)
+		case "/index/b.png":
+			w.Write(blue)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	defer resetSlideshow()
+	SetGeometry(8, 8)
+	defer SetGeometry(1920, 1080)
+
+	// directory index: sorted by name, non-images ignored
+	if err := StartSlideshow(srv.URL+"/index/", time.Hour); err != nil {
+		t.Fatalf("StartSlideshow(index): %v", err)
+	}
+	mu.RLock()
+	n, first := len(slides), slides[0].(*image.RGBA).RGBAAt(4, 4)
+	mu.RUnlock()
+	if n != 2 || first.R != 255 {
+		t.Fatalf("index: %d slides, first %v; want 2, red first", n, first)
+	}
+
+	// a failed refresh keeps the last good set
+	broken.Store(true)
+	if err := Reload(); err == nil {
+		t.Fatalf("Reload succeeded against a failing server")
+	}
+	mu.RLock()
+	n = len(slides)
+	mu.RUnlock()
+	if n != 2 {
+		t.Fatalf("slides after failed reload = %d, want 2", n)
+	}
+
+	// JSON manifest: listed order is kept
+	if err := StartSlideshow(srv.URL+"/manifest.json", time.Hour); err != nil {
+		t.Fatalf("StartSlideshow(manifest): %v", err)
+	}
+	mu.RLock()
+	first = slides[0].(*image.RGBA).RGBAAt(4, 4)
+	mu.RUnlock()
+	if first.B != 255 {
+		t.Fatalf("manifest: first slide %v, want blue", first)
+	}
+}
+
+func TestLoadImagesErrors(t *testing.T) {
+	SetGeometry(8, 8)
+	defer SetGeometry(1920, 1080)
+	write := func(dir, name string, b []byte) {
+		if err := os.WriteFile(filepath.Join(dir, name), b, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	empty := t.TempDir()
+	if _, err := loadImages(empty); err == nil || !strings.Contains(err.Error(), "no files") {
+		t.Errorf("empty dir: %v", err)
+	}
+
+	filtered := t.TempDir()
+	write(filtered, "notes.txt", []byte("hi"))
+	if _, err := loadImages(filtered); err == nil || !strings.Contains(err.Error(), "skipped by extension") {
+		t.Errorf("filtered dir: %v", err)
+	}
+
+	corrupt := t.TempDir()
+	write(corrupt, "bad.jpg", []byte("not a jpeg"))
+	if _, err := loadImages(corrupt); err == nil || !strings.Contains(err.Error(), "bad.jpg") {
+		t.Errorf("corrupt dir: %v", err)
+	}
+
+	write(corrupt, "good.png", pngBytes(t, color.White))
+	imgs, err := loadImages(corrupt)
+	if err != nil || len(imgs) != 1 {
+		t.Errorf("partial dir: %d images, %v", len(imgs), err)
+	}
+}
+
+func TestLoadModernFormats(t *testing.T) {
+	SetGeometry(64, 48)
+	defer SetGeometry(1920, 1080)
+	imgs, err := loadImages("testdata")
+	if err != nil {
+		t.Fatalf("loadImages: %v", err)
+	}
+	if len(imgs) != 3 {
+		t.Fatalf("loaded %d slides, want 3 (avif, svg and webp)", len(imgs))
+	}
+	// the square red SVG is fitted into the middle of the 64x48 frame
+	svg := imgs[1].(*image.RGBA)
+	if c := svg.RGBAAt(32, 24); c.R != 255 || c.G != 0 {
+		t.Fatalf("svg center = %v, want red", c)
+	}
+	if c := svg.RGBAAt(2, 24); c.R != 0 {
+		t.Fatalf("svg letterbox = %v, want black", c)
+	}
+	for _, img := range imgs {
+		if b := img.Bounds(); b.Dx() != 64 || b.Dy() != 48 {
+			t.Fatalf("slide bounds %v, want 64x48", b)
+		}
+	}
+}
+
+func TestTransitions(t *testing.T) {
+	solid := func(v uint8) *image.RGBA {
+		img := image.NewRGBA(image.Rect(0, 0, 8, 2))
+		draw.Draw(img, img.Bounds(), &image.Uniform{C: color.RGBA{v, v, v, 255}}, image.Point{}, draw.Src)
+		return img
+	}
+	a, b := solid(0), solid(255)
+	render := func(kind string, p float64) *image.RGBA {
+		dst := image.NewRGBA(a.Bounds())
+		row := transitionRow(kind, false, p, a, b, dst)
+		for y := 0; y < 2; y++ {
+			row(y)
+		}
+		return dst
+	}
+	for _, kind := range []string{TransitionFade, TransitionWipeLeft, TransitionWipeRight, TransitionSlide, TransitionDissolve} {
+		if c := render(kind, 0).RGBAAt(3, 1); c.R != 0 {
+			t.Errorf("%s at 0: %v, want outgoing slide", kind, c)
+		}
+		if c := render(kind, 1).RGBAAt(3, 1); c.R != 255 {
+			t.Errorf("%s at 1: %v, want incoming slide", kind, c)
+		}
+	}
+	if c := render(TransitionFade, 0.5).RGBAAt(0, 0); c.R < 126 || c.R > 128 {
+		t.Errorf("fade midpoint = %v", c)
+	}
+	if m := render(TransitionWipeRight, 0.5); m.RGBAAt(0, 0).R != 255 || m.RGBAAt(7, 0).R != 0 {
+		t.Errorf("wipe-right midpoint: left %v right %v", m.RGBAAt(0, 0), m.RGBAAt(7, 0))
+	}
+	if m := render(TransitionWipeLeft, 0.5); m.RGBAAt(0, 0).R != 0 || m.RGBAAt(7, 0).R != 255 {
+		t.Errorf("wipe-left midpoint: left %v right %v", m.RGBAAt(0, 0), m.RGBAAt(7, 0))
+	}
+	if m := render(TransitionSlide, 0.5); m.RGBAAt(0, 0).R != 0 || m.RGBAAt(7, 0).R != 255 {
+		t.Errorf("slide midpoint: left %v right %v", m.RGBAAt(0, 0), m.RGBAAt(7, 0))
+	}
+}
+
+// blendRowFloat is the original float64 crossfade, kept as a reference.
+func blendRowFloat(dst, a, b []byte, t float64) {
+	for i := 0; i < len(dst); i += 4 {
+		dst[i] = uint8((1-t)*float64(a[i]) + t*float64(b[i]))
+		dst[i+1] = uint8((1-t)*float64(a[i+1]) + t*float64(b[i+1]))
+		dst[i+2] = uint8((1-t)*float64(a[i+2]) + t*float64(b[i+2]))
+		dst[i+3] = uint8((1-t)*float64(a[i+3]) + t*float64(b[i+3]))
+	}
+}
+
+func randomRow(n int, seed byte) []byte {
+	row := make([]byte, n)
+	for i := range row {
+		row[i] = byte(i*31) ^ seed
+	}
+	return row
+}
+
+func TestBlendRowMatchesFloat(t *testing.T) {
+	a, b := randomRow(4096, 0x5a), randomRow(4096, 0xc3)
+	got, want := make([]byte, len(a)), make([]byte, len(a))
+	for _, p := range []float64{0, 0.1, 0.25, 0.5, 0.77, 0.999, 1} {
+		newBlendLUT(p).row(got, a, b)
+		blendRowFloat(want, a, b, p)
+		for i := range got {
+			if d := int(got[i]) - int(want[i]); d < -1 || d > 1 {
+				t.Fatalf("t=%v i=%d: fixed %d, float %d", p, i, got[i], want[i])
+			}
+		}
+	}
+	newBlendLUT(1).row(got, a, b)
+	if !bytes.Equal(got, b) {
+		t.Fatalf("t=1 did not yield the incoming row")
+	}
+}
+
+func BenchmarkBlendRowFloat(b *testing.B) {
+	x, y, dst := randomRow(1920*4, 1), randomRow(1920*4, 2), make([]byte, 1920*4)
+	b.SetBytes(int64(len(dst)))
+	for i := 0; i < b.N; i++ {
+		blendRowFloat(dst, x, y, 0.37)
+	}
+}
+
+func BenchmarkBlendRow(b *testing.B) {
+	x, y, dst := randomRow(1920*4, 1), randomRow(1920*4, 2), make([]byte, 1920*4)
+	lut := newBlendLUT(0.37)
+	b.SetBytes(int64(len(dst)))
+	for i := 0; i < b.N; i++ {
+		lut.row(dst, x, y)
+	}
+}
+
+// BenchmarkGenerateFrame reports per-frame allocations; canvases and encode
+// buffers come from pools, so only the returned JPEG should be allocated.
+func BenchmarkGenerateFrame(b *testing.B) {
+	solid := func(v uint8) image.Image {
+		img := image.NewRGBA(image.Rect(0, 0, 1280, 720))
+		draw.Draw(img, img.Bounds(), &image.Uniform{C: color.RGBA{v, v, v, 255}}, image.Point{}, draw.Src)
+		return img
+	}
+	SetGeometry(1280, 720)
+	defer SetGeometry(1920, 1080)
+	mu.Lock()
+	oldInterval := interval
+	slides, cur = []image.Image{solid(0), solid(255)}, 0
+	interval, lastAdvance = time.Hour, time.Now()
+	mu.Unlock()
+	defer func() {
+		resetSlideshow()
+		mu.Lock()
+		interval = oldInterval
+		mu.Unlock()
+	}()
+	SetTimestamp(true)
+	defer SetTimestamp(false)
+
+	for _, fade := range []time.Duration{0, time.Hour} {
+		name := "static"
+		if fade > 0 {
+			name = "fade"
+		}
+		b.Run(name, func(b *testing.B) {
+			SetFade(fade)
+			defer SetFade(0)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := GenerateFrame(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestFadePacing(t *testing.T) {
+	mu.Lock()
+	oldInterval := interval
+	// a single slide never fades, so pace a pair
+	slides, cur = []image.Image{image.NewRGBA(image.Rect(0, 0, 1, 1)), image.NewRGBA(image.Rect(0, 0, 1, 1))}, 0
+	interval, fadeDuration, fadeSteps = 10*time.Second, time.Second, 4
+	mu.Unlock()
+	defer func() {
+		resetSlideshow()
+		mu.Lock()
+		interval, fadeDuration, fadeSteps = oldInterval, 0, 0
+		mu.Unlock()
+	}()
+
+	// the fade starts at 9s; steps are 200ms apart and give 4 distinct
+	// values strictly between the two slides
+	mu.Lock()
+	var got []float64
+	for ms := 9000; ms < 10000; ms += 100 {
+		p := fadeProgress(time.Duration(ms) * time.Millisecond)
+		if len(got) == 0 || got[len(got)-1] != p {
+			got = append(got, p)
+		}
+	}
+	mu.Unlock()
+	if want := []float64{0, 0.2, 0.4, 0.6, 0.8}; !slices.Equal(got, want) {
+		t.Fatalf("fade progress steps = %v, want %v", got, want)
+	}
+
+	advance := fakeClock(t)
+	at := func(elapsed time.Duration) time.Duration {
+		mu.Lock()
+		lastAdvance = clock()
+		mu.Unlock()
+		advance(elapsed)
+		return NextFrameIn(time.Second)
+	}
+	if d := at(2 * time.Second); d != time.Second {
+		t.Errorf("before fade: %v, want base", d)
+	}
+	if d := at(8500 * time.Millisecond); d != 500*time.Millisecond {
+		t.Errorf("approaching fade: %v, want wake at fade start", d)
+	}
+	if d := at(9050 * time.Millisecond); d != 150*time.Millisecond {
+		t.Errorf("during fade: %v, want next step", d)
+	}
+}
+
+func TestGenerateLayers(t *testing.T) {
+	SetGeometry(320, 180)
+	defer SetGeometry(1920, 1080)
+	out, err := GenerateLayers([]Layer{{Width: 160, Height: 90, Quality: 50}})
+	if err != nil {
+		t.Fatalf("GenerateLayers: %v", err)
+	}
+	if len(out) != 2 {
+		t.Fatalf("got %d encodings, want 2", len(out))
+	}
+	for i, want := range []image.Point{{320, 180}, {160, 90}} {
+		cfg, err := jpeg.DecodeConfig(bytes.NewReader(out[i]))
+		if err != nil {
+			t.Fatalf("layer %d: %v", i, err)
+		}
+		if got := image.Pt(cfg.Width, cfg.Height); got != want {
+			t.Errorf("layer %d is %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestThumbnail(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 640, 360))
+	fill(img, img.Rect, color.RGBA{0, 128, 255, 255})
+	var src bytes.Buffer
+	if err := jpeg.Encode(&src, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct{ width, wantW, wantH int }{{320, 320, 180}, {1000, 640, 360}} {
+		b, err := Thumbnail(src.Bytes(), tc.width, 60)
+		if err != nil {
+			t.Fatalf("Thumbnail(%d): %v", tc.width, err)
+		}
+		cfg, err := jpeg.DecodeConfig(bytes.NewReader(b))
+		if err != nil || cfg.Width != tc.wantW || cfg.Height != tc.wantH {
+			t.Errorf("Thumbnail(%d) = %dx%d, %v; want %dx%d", tc.width, cfg.Width, cfg.Height, err, tc.wantW, tc.wantH)
+		}
+	}
+	if _, err := Thumbnail([]byte("not a jpeg"), 320, 60); err == nil {
+		t.Error("Thumbnail of garbage succeeded")
+	}
+}
+
+func TestSysmon(t *testing.T) {
+	cpu, err := parseCPU("cpu  100 0 50 800 50 0 0 0 0 0\ncpu0 1 2 3 4 5\n")
+	if err != nil || cpu.total != 1000 || cpu.idle != 850 {
+		t.Fatalf("parseCPU = %+v, %v", cpu, err)
+	}
+	total, avail, err := parseMeminfo("MemTotal:       16384 kB\nMemFree:  1 kB\nMemAvailable:    4096 kB\n")
+	if err != nil || total != 16384*1024 || avail != 4096*1024 {
+		t.Fatalf("parseMeminfo = %d, %d, %v", total, avail, err)
+	}
+
+	if err := SetPattern(PatternSysmon); err != nil {
+		t.Fatalf("SetPattern: %v", err)
+	}
+	defer SetPattern(PatternNone)
+	SetGeometry(640, 360)
+	defer SetGeometry(1920, 1080)
+	b, err := GenerateFrame()
+	if err != nil {
+		t.Fatalf("GenerateFrame: %v", err)
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(b)); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+}
+
+func TestParallelRowsCoversEveryRow(t *testing.T) {
+	for _, c := range []struct{ h, workers int }{{1080, 8}, {3, 8}, {7, 8}, {5, 1}, {4, 0}} {
+		var hits [2000]atomic.Int32
+		parallelRows(func(y int) { hits[y].Add(1) }, c.h, c.workers)
+		for y := 0; y < len(hits); y++ {
+			want := int32(0)
+			if y < c.h {
+				want = 1
+			}
+			if got := hits[y].Load(); got != want {
+				t.Fatalf("h=%d workers=%d: row %d visited %d times", c.h, c.workers, y, got)
+			}
+		}
+	}
+}
+
+func BenchmarkBlendWorkers(b *testing.B) {
+	for _, res := range []image.Point{{1280, 720}, {1920, 1080}, {3840, 2160}} {
+		src := image.NewRGBA(image.Rect(0, 0, res.X, res.Y))
+		dst := image.NewRGBA(src.Rect)
+		row := transitionRow(TransitionFade, false, 0.4, src, src, dst)
+		for _, w := range []int{1, 2, 4, 8, 16, 0} {
+			name := fmt.Sprintf("%dx%d/workers=%d", res.X, res.Y, w)
+			if w == 0 {
+				name = fmt.Sprintf("%dx%d/workers=auto", res.X, res.Y)
+				w = max(4, runtime.NumCPU())
+			}
+			b.Run(name, func(b *testing.B) {
+				b.SetBytes(int64(len(dst.Pix)))
+				for i := 0; i < b.N; i++ {
+					parallelRows(row, res.Y, w)
+				}
+			})
+		}
+	}
+}
+
+func TestRowBands(t *testing.T) {
+	for _, c := range []struct{ h, workers, bands int }{{7, 8, 7}, {1080, 8, 8}, {1083, 8, 8}, {9, 4, 4}, {1, 16, 1}, {5, 0, 1}} {
+		bands := rowBands(c.h, c.workers)
+		if len(bands) != c.bands {
+			t.Fatalf("h=%d workers=%d: %d bands, want %d", c.h, c.workers, len(bands), c.bands)
+		}
+		next, lo, hi := 0, c.h, 0
+		for _, b := range bands {
+			if b[0] != next || b[1] <= b[0] {
+				t.Fatalf("h=%d workers=%d: bad band %v in %v", c.h, c.workers, b, bands)
+			}
+			next = b[1]
+			lo, hi = min(lo, b[1]-b[0]), max(hi, b[1]-b[0])
+		}
+		if next != c.h || hi-lo > 1 {
+			t.Fatalf("h=%d workers=%d: bands %v uneven or incomplete", c.h, c.workers, bands)
+		}
+	}
+}
+
+func TestGammaCorrectBlend(t *testing.T) {
+	black := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	white := image.NewRGBA(black.Rect)
+	draw.Draw(black, black.Rect, &image.Uniform{C: color.Black}, image.Point{}, draw.Src)
+	draw.Draw(white, white.Rect, &image.Uniform{C: color.White}, image.Point{}, draw.Src)
+	mid := func(gamma bool, p float64) color.RGBA {
+		dst := image.NewRGBA(black.Rect)
+		transitionRow(TransitionFade, gamma, p, black, white, dst)(0)
+		return dst.RGBAAt(1, 0)
+	}
+	// half the light of white is sRGB 0.735, i.e. 188 rather than 128
+	if c := mid(true, 0.5); c.R < 187 || c.R > 189 || c.G != c.R || c.B != c.R || c.A != 255 {
+		t.Errorf("linear 50%% blend = %v, want about 188", c)
+	}
+	if c := mid(false, 0.5); c.R < 127 || c.R > 128 {
+		t.Errorf("sRGB 50%% blend = %v, want about 128", c)
+	}
+	for _, p := range []float64{0, 1} {
+		if c, want := mid(true, p), uint8(255*p); c.R != want {
+			t.Errorf("linear blend at %v = %v, want %d", p, c, want)
+		}
+	}
+}
+
+func TestBackground(t *testing.T) {
+	for in, want := range map[string]color.RGBA{"#202020": {0x20, 0x20, 0x20, 255}, "a0b1c2": {0xa0, 0xb1, 0xc2, 255}, "#fa0": {0xff, 0xaa, 0, 255}} {
+		if got, err := ParseColor(in); err != nil || got != want {
+			t.Errorf("ParseColor(%q) = %v, %v, want %v", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "#12345", "#gggggg", "red"} {
+		if _, err := ParseColor(in); err == nil {
+			t.Errorf("ParseColor(%q) accepted", in)
+		}
+	}
+
+	bg := color.RGBA{0x20, 0x40, 0x60, 255}
+	SetBackground(bg)
+	defer SetBackground(color.Black)
+	SetGeometry(8, 4)
+	defer SetGeometry(1920, 1080)
+	// a square slide on a 2:1 frame is pillarboxed
+	img, err := decodeSlide(bytes.NewReader(pngBytes(t, color.RGBA{255, 0, 0, 255})))
+	if err != nil {
+		t.Fatalf("decodeSlide: %v", err)
+	}
+	if c := img.RGBAAt(0, 0); c != bg {
+		t.Errorf("letterbox = %v, want %v", c, bg)
+	}
+	if c := img.RGBAAt(4, 2); c.R != 255 {
+		t.Errorf("slide centre = %v, want red", c)
+	}
+	// transparent areas show the background too
+	img, err = decodeSlide(bytes.NewReader(pngBytes(t, color.RGBA{})))
+	if err != nil {
+		t.Fatalf("decodeSlide: %v", err)
+	}
+	if c := img.RGBAAt(4, 2); c != bg {
+		t.Errorf("transparent slide = %v, want %v", c, bg)
+	}
+}
+
+func TestScaleModes(t *testing.T) {
+	// a red 40x40 slide whose top rows are green, shown on an 80x40 frame
+	src := image.NewRGBA(image.Rect(0, 0, 40, 40))
+	draw.Draw(src, src.Rect, &image.Uniform{C: color.RGBA{255, 0, 0, 255}}, image.Point{}, draw.Src)
+	draw.Draw(src, image.Rect(0, 0, 40, 5), &image.Uniform{C: color.RGBA{0, 255, 0, 255}}, image.Point{}, draw.Src)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, src); err != nil {
+		t.Fatal(err)
+	}
+	SetGeometry(80, 40)
+	defer SetGeometry(1920, 1080)
+	defer SetScaleMode(ScaleFit)
+
+	for _, c := range []struct {
+		mode      string
+		edge, top string // pixel (0,20) and (40,0)
+	}{
+		{ScaleFit, "bg", "green"},      // pillarboxed, whole image visible
+		{ScaleFill, "red", "red"},      // 80x80, top and bottom quarters cropped
+		{ScaleStretch, "red", "green"}, // 80x40, nothing cropped
+	} {
+		if err := SetScaleMode(c.mode); err != nil {
+			t.Fatal(err)
+		}
+		img, err := decodeSlide(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("%s: %v", c.mode, err)
+		}
+		name := func(p color.RGBA) string {
+			switch {
+			case p.R > 200 && p.G < 50:
+				return "red"
+			case p.G > 200 && p.R < 50:
+				return "green"
+			case p.R == 0 && p.G == 0 && p.B == 0:
+				return "bg"
+			}
+			return fmt.Sprint(p)
+		}
+		if got := name(img.RGBAAt(0, 20)); got != c.edge {
+			t.Errorf("%s: left edge is %s, want %s", c.mode, got, c.edge)
+		}
+		if got := name(img.RGBAAt(40, 0)); got != c.top {
+			t.Errorf("%s: top centre is %s, want %s", c.mode, got, c.top)
+		}
+	}
+	if err := SetScaleMode("zoom"); err == nil {
+		t.Error("SetScaleMode accepted an unknown mode")
+	}
+}
+
+func TestSlideCache(t *testing.T) {
+	src, cache := t.TempDir(), t.TempDir()
+	slide := filepath.Join(src, "a.png")
+	if err := os.WriteFile(slide, pngBytes(t, color.RGBA{255, 0, 0, 255}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetCacheDir(cache); err != nil {
+		t.Fatal(err)
+	}
+	defer SetCacheDir("")
+	SetGeometry(8, 4)
+	defer SetGeometry(1920, 1080)
+
+	first, err := loadImages(src)
+	if err != nil || len(first) != 1 {
+		t.Fatalf("loadImages = %d, %v", len(first), err)
+	}
+	entries, _ := filepath.Glob(filepath.Join(cache, "*.rgba"))
+	if len(entries) != 1 {
+		t.Fatalf("cache has %d entries, want 1", len(entries))
+	}
+	// a hit returns the same pixels without decoding the source
+	key := cacheKey(slide)
+	cached := cacheLoad(cache, key)
+	if cached == nil || !bytes.Equal(cached.Pix, first[0].(*image.RGBA).Pix) {
+		t.Fatalf("cached slide differs from the decoded one")
+	}
+
+	// other settings or a changed file miss
+	SetGeometry(16, 8)
+	if cacheKey(slide) == key {
+		t.Error("geometry change kept the cache key")
+	}
+	SetGeometry(8, 4)
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(slide, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if cacheKey(slide) == key {
+		t.Error("mtime change kept the cache key")
+	}
+	if _, err := loadImages(src); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := filepath.Glob(filepath.Join(cache, "*.rgba")); len(entries) != 2 {
+		t.Fatalf("cache has %d entries after the source changed, want 2", len(entries))
+	}
+}
+
+func TestLazyLoad(t *testing.T) {
+	dir := t.TempDir()
+	colors := []color.RGBA{{255, 0, 0, 255}, {0, 255, 0, 255}, {0, 0, 255, 255}, {255, 255, 0, 255}, {0, 255, 255, 255}}
+	for i, c := range colors {
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("%d.png", i)), pngBytes(t, c), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "broken.png"), []byte("not a png"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	SetGeometry(8, 4)
+	defer SetGeometry(1920, 1080)
+	SetLazyLoad(true)
+	defer SetLazyLoad(false)
+
+	imgs, err := loadImages(dir)
+	if err != nil || len(imgs) != len(colors) {
+		t.Fatalf("loadImages = %d, %v", len(imgs), err)
+	}
+	for i, img := range imgs {
+		if _, ok := img.(*lazySlide); !ok {
+			t.Fatalf("slide %d is %T, want *lazySlide", i, img)
+		}
+		if got := decoded(img).RGBAAt(4, 2); got != colors[i] {
+			t.Errorf("slide %d = %v, want %v", i, got, colors[i])
+		}
+		lazyMu.Lock()
+		n := lazyLRU.Len()
+		lazyMu.Unlock()
+		if n > lazyKeep {
+			t.Fatalf("%d slides decoded, want at most %d", n, lazyKeep)
+		}
+	}
+	// a hit returns the same decoded slide
+	if decoded(imgs[4]) != decoded(imgs[4]) {
+		t.Error("decoded slide was not cached")
+	}
+}
+
+func TestReadPlaylist(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("\n/b.png\n# a comment\n/a.png\n\n/c.png\n"))
+	for _, want := range [][]string{{"/b.png", "/a.png"}, {"/c.png"}} {
+		got, err := readPlaylist(r)
+		if err != nil || !slices.Equal(got, want) {
+			t.Fatalf("readPlaylist = %q, %v; want %q", got, err, want)
+		}
+	}
+	if _, err := readPlaylist(r); err == nil {
+		t.Error("readPlaylist at EOF: no error")
+	}
+}
+
+func TestSnapshotDoesNotAdvance(t *testing.T) {
+	SetGeometry(8, 4)
+	defer SetGeometry(1920, 1080)
+	solid := func(c color.RGBA) image.Image {
+		img := image.NewRGBA(image.Rect(0, 0, 8, 4))
+		fill(img, img.Rect, c)
+		return img
+	}
+	red := color.RGBA{255, 0, 0, 255}
+	mu.Lock()
+	oldInterval := interval
+	slides, cur = []image.Image{solid(red), solid(color.RGBA{0, 0, 255, 255})}, 0
+	interval = time.Second
+	// the next slide is overdue
+	due := clock().Add(-5 * time.Second)
+	lastAdvance = due
+	mu.Unlock()
+	defer func() {
+		resetSlideshow()
+		mu.Lock()
+		interval = oldInterval
+		mu.Unlock()
+	}()
+
+	for range 3 {
+		b, err := Snapshot()
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := jpeg.Decode(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r, _, bl, _ := img.At(4, 2).RGBA(); r>>8 < 200 || bl>>8 > 50 {
+			t.Fatalf("snapshot shows %v, want the current (red) slide", img.At(4, 2))
+		}
+	}
+	if idx, _ := CurrentSlide(); idx != 0 || !lastAdvance.Equal(due) {
+		t.Fatalf("Snapshot advanced the slideshow to %d", idx)
+	}
+	if _, err := GenerateFrame(); err != nil {
+		t.Fatal(err)
+	}
+	if idx, _ := CurrentSlide(); idx != 1 {
+		t.Fatalf("GenerateFrame left slide %d, want 1", idx)
+	}
+}
+
+func TestProgressiveRoundTrip(t *testing.T) {
+	SetProgressive(true)
+	defer SetProgressive(false)
+	// odd sizes exercise the partial blocks and MCUs at the edges
+	for _, size := range []image.Point{{64, 32}, {37, 23}, {1, 1}} {
+		src := image.NewRGBA(image.Rect(0, 0, size.X, size.Y))
+		for y := range size.Y {
+			for x := range size.X {
+				src.SetRGBA(x, y, color.RGBA{uint8(x * 255 / size.X), uint8(y * 255 / size.Y), 128, 255})
+			}
+		}
+		b, err := encode(src, 90)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Contains(b, []byte{0xff, 0xc2}) {
+			t.Fatalf("%v: no SOF2 marker, not a progressive JPEG", size)
+		}
+		img, err := jpeg.Decode(bytes.NewReader(b))
+		if err != nil {
+			t.Fatalf("%v: decode: %v", size, err)
+		}
+		if img.Bounds() != src.Bounds() {
+			t.Fatalf("%v: decoded bounds %v", size, img.Bounds())
+		}
+		var diff, n int
+		for y := range size.Y {
+			for x := range size.X {
+				r1, g1, b1, _ := img.At(x, y).RGBA()
+				want := src.RGBAAt(x, y)
+				for _, d := range []int{int(r1>>8) - int(want.R), int(g1>>8) - int(want.G), int(b1>>8) - int(want.B)} {
+					diff += max(d, -d)
+					n++
+				}
+			}
+		}
+		if avg := float64(diff) / float64(n); avg > 4 {
+			t.Errorf("%v: mean error %.2f per channel, want <= 4", size, avg)
+		}
+	}
+}
+
+func TestSlideshowAdvance(t *testing.T) {
+	SetGeometry(32, 16)
+	defer SetGeometry(1920, 1080)
+	// slides of other image types and sizes are fitted to the geometry
+	red := image.NewNRGBA(image.Rect(0, 0, 4, 2))
+	draw.Draw(red, red.Bounds(), &image.Uniform{C: color.RGBA{255, 0, 0, 255}}, image.Point{}, draw.Src)
+	blue := image.NewRGBA(image.Rect(0, 0, 64, 32))
+	fill(blue, blue.Rect, color.RGBA{0, 0, 255, 255})
+	advance := fakeClock(t)
+	showSlides(t, 10*time.Second, red, blue)
+	SetFade(2 * time.Second)
+
+	// frame generates a frame and returns its centre pixel and the slide on air
+	frame := func() (color.RGBA, int) {
+		t.Helper()
+		b, err := GenerateFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := jpeg.Decode(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if img.Bounds() != image.Rect(0, 0, 32, 16) {
+			t.Fatalf("frame bounds %v, want the 32x16 geometry", img.Bounds())
+		}
+		r, g, b2, _ := img.At(16, 8).RGBA()
+		idx, _ := CurrentSlide()
+		return color.RGBA{uint8(r >> 8), uint8(g >> 8), uint8(b2 >> 8), 255}, idx
+	}
+	near := func(c, want color.RGBA) bool {
+		d := func(a, b uint8) int { return max(int(a)-int(b), int(b)-int(a)) }
+		return d(c.R, want.R) <= 16 && d(c.G, want.G) <= 16 && d(c.B, want.B) <= 16
+	}
+
+	steps := []struct {
+		at    time.Duration // since the show started
+		want  color.RGBA
+		slide int
+	}{
+		{time.Second, color.RGBA{255, 0, 0, 255}, 0},
+		// halfway through the fade that starts 2s before the change
+		{9 * time.Second, color.RGBA{128, 0, 128, 255}, 0},
+		{9999 * time.Millisecond, color.RGBA{0, 0, 255, 255}, 0},
+		{10 * time.Second, color.RGBA{0, 0, 255, 255}, 1},
+		{19 * time.Second, color.RGBA{128, 0, 128, 255}, 1},
+		// and round again
+		{20 * time.Second, color.RGBA{255, 0, 0, 255}, 0},
+	}
+	var at time.Duration
+	for _, s := range steps {
+		advance(s.at - at)
+		at = s.at
+		c, idx := frame()
+		if !near(c, s.want) || idx != s.slide {
+			t.Errorf("at %v: slide %d showing %v, want slide %d showing %v", s.at, idx, c, s.slide, s.want)
+		}
+	}
+}
+
+func TestSlideshowControl(t *testing.T) {
+	SetGeometry(32, 16)
+	defer SetGeometry(1920, 1080)
+	var imgs []image.Image
+	for _, c := range []color.RGBA{{255, 0, 0, 255}, {0, 255, 0, 255}, {0, 0, 255, 255}} {
+		img := image.NewRGBA(image.Rect(0, 0, 32, 16))
+		fill(img, img.Rect, c)
+		imgs = append(imgs, img)
+	}
+	advance := fakeClock(t)
+	showSlides(t, 10*time.Second, imgs...)
+	SetFade(2 * time.Second)
+
+	// check generates a frame and compares the slide on air and whether it
+	// is a fade frame
+	check := func(what string, slide int, fade bool) {
+		t.Helper()
+		var info FrameInfo
+		SetFrameHook(func(i FrameInfo) { info = i })
+		defer SetFrameHook(nil)
+		if _, err := GenerateFrame(); err != nil {
+			t.Fatal(err)
+		}
+		if info.Slide != slide || info.Fade != fade {
+			t.Errorf("%s: slide %d fade %v, want slide %d fade %v", what, info.Slide, info.Fade, slide, fade)
+		}
+	}
+
+	Pause()
+	if !Paused() {
+		t.Fatal("Paused() = false after Pause")
+	}
+	advance(time.Minute)
+	check("paused", 0, false)
+	if err := Next(false); err != nil {
+		t.Fatal(err)
+	}
+	check("Next while paused", 1, false)
+	if err := Prev(); err != nil {
+		t.Fatal(err)
+	}
+	if err := Prev(); err != nil {
+		t.Fatal(err)
+	}
+	check("Prev wraps around", 2, false)
+
+	// a fading Next runs the transition, then holds the new slide
+	if err := Next(true); err != nil {
+		t.Fatal(err)
+	}
+	advance(time.Second)
+	check("fading Next", 2, true)
+	advance(time.Second)
+	check("after fading Next", 0, false)
+	advance(time.Minute)
+	check("held after fading Next", 0, false)
+
+	// resuming gives the slide on air a full interval
+	Resume()
+	advance(7 * time.Second)
+	check("resumed", 0, false)
+	advance(2 * time.Second)
+	check("resumed fade", 0, true)
+	advance(time.Second)
+	check("resumed advance", 1, false)
+
+	resetSlideshow()
+	if err := Next(false); err == nil {
+		t.Error("Next without a slideshow succeeded")
+	}
+}
+
+func TestSingleSlide(t *testing.T) {
+	SetGeometry(32, 16)
+	defer SetGeometry(1920, 1080)
+	img := image.NewRGBA(image.Rect(0, 0, 32, 16))
+	fill(img, img.Rect, color.RGBA{255, 0, 0, 255})
+	advance := fakeClock(t)
+	showSlides(t, 10*time.Second, img)
+	SetFade(2 * time.Second)
+
+	var info FrameInfo
+	SetFrameHook(func(i FrameInfo) { info = i })
+	defer SetFrameHook(nil)
+	first, err := GenerateFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// inside the fade window and across the slide boundary
+	for _, d := range []time.Duration{9 * time.Second, 500 * time.Millisecond, time.Second} {
+		advance(d)
+		if in := NextFrameIn(time.Second); in != time.Second {
+			t.Errorf("NextFrameIn = %v, want the base interval", in)
+		}
+		b, err := GenerateFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Fade || !bytes.Equal(b, first) {
+			t.Errorf("frame changed (fade %v) with a single slide", info.Fade)
+		}
+	}
+}
+
+func TestQuality(t *testing.T) {
+	SetGeometry(64, 32)
+	defer SetGeometry(1920, 1080)
+	noise := image.NewRGBA(image.Rect(0, 0, 64, 32))
+	for i := range noise.Pix {
+		noise.Pix[i] = byte(i * 7919 >> 3)
+	}
+	showSlides(t, time.Hour, noise)
+
+	size := func(q int) int {
+		SetQuality(q)
+		if Quality() != q {
+			t.Fatalf("Quality() = %d after SetQuality(%d)", Quality(), q)
+		}
+		b, err := GenerateFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(b)
+	}
+	if lo, hi := size(20), size(95); lo >= hi {
+		t.Fatalf("quality 20 gave %d bytes, quality 95 %d; want fewer", lo, hi)
+	}
+}
+
+func TestFrameHook(t *testing.T) {
+	SetGeometry(16, 8)
+	defer SetGeometry(1920, 1080)
+	advance := fakeClock(t)
+	showSlides(t, 10*time.Second, image.NewRGBA(image.Rect(0, 0, 16, 8)), image.NewRGBA(image.Rect(0, 0, 16, 8)))
+	SetFade(2 * time.Second)
+	SetQuality(70)
+	var got []FrameInfo
+	SetFrameHook(func(fi FrameInfo) { got = append(got, fi) })
+	defer SetFrameHook(nil)
+
+	var sizes []int
+	for _, d := range []time.Duration{time.Second, 8 * time.Second, time.Second} {
+		advance(d)
+		b, err := GenerateFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sizes = append(sizes, len(b))
+	}
+	if _, err := Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 {
+		t.Fatalf("hook called %d times, want 3 (not for Snapshot)", len(got))
+	}
+	want := []struct {
+		slide int
+		fade  bool
+	}{{0, false}, {0, true}, {1, false}}
+	for i, fi := range got {
+		if fi.Slide != want[i].slide || fi.Fade != want[i].fade || fi.Quality != 70 || fi.Bytes != sizes[i] || fi.Encode <= 0 {
+			t.Errorf("frame %d: %+v, want slide %d, fade %v, quality 70, %d bytes", i, fi, want[i].slide, want[i].fade, sizes[i])
+		}
+	}
+}
+
+func TestGenerateDelta(t *testing.T) {
+	SetGeometry(64, 48)
+	defer SetGeometry(1920, 1080)
+	base := image.NewRGBA(image.Rect(0, 0, 64, 48))
+	fill(base, base.Rect, color.RGBA{128, 128, 128, 255})
+	showSlides(t, time.Hour, base)
+	mu.Lock()
+	deltaKey = nil
+	mu.Unlock()
+	// show swaps the slide on air for img
+	show := func(img *image.RGBA) {
+		mu.Lock()
+		slides[0] = img
+		mu.Unlock()
+	}
+	delta := func(key bool) (image.Rectangle, bool) {
+		t.Helper()
+		b, at, isKey, err := GenerateDelta(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := jpeg.Decode(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return img.Bounds().Add(at), isKey
+	}
+
+	if r, key := delta(false); !key || r != image.Rect(0, 0, 64, 48) {
+		t.Fatalf("first frame: %v key %v, want a full keyframe", r, key)
+	}
+	if r, key := delta(false); key || r != image.Rect(0, 0, 16, 16) {
+		t.Fatalf("unchanged frame: %v key %v, want the top-left block", r, key)
+	}
+	// a small change is widened to the 16 pixel grid
+	small := image.NewRGBA(base.Rect)
+	copy(small.Pix, base.Pix)
+	fill(small, image.Rect(40, 20, 44, 34), color.RGBA{255, 0, 0, 255})
+	show(small)
+	if r, key := delta(false); key || r != image.Rect(32, 16, 48, 48) {
+		t.Fatalf("small change: %v key %v, want region (32,16)-(48,48)", r, key)
+	}
+	if _, key := delta(true); !key {
+		t.Fatal("requested keyframe not sent")
+	}
+	// the region is relative to the new keyframe, which has the change
+	if r, key := delta(false); key || r != image.Rect(0, 0, 16, 16) {
+		t.Fatalf("after new keyframe: %v key %v", r, key)
+	}
+	big := image.NewRGBA(base.Rect)
+	fill(big, big.Rect, color.RGBA{0, 0, 255, 255})
+	show(big)
+	if _, key := delta(false); !key {
+		t.Fatal("change of the whole frame sent as a region")
+	}
+}
+
+func TestErrorFrame(t *testing.T) {
+	for _, size := range []image.Point{{1920, 1080}, {320, 180}, {1, 1}} {
+		b, err := ErrorFrame(size.X, size.Y, strings.Repeat("jpeg: something went wrong ", 20))
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := jpeg.Decode(bytes.NewReader(b))
+		if err != nil {
+			t.Fatalf("%v: %v", size, err)
+		}
+		if img.Bounds().Size() != size {
+			t.Fatalf("error frame is %v, want %v", img.Bounds().Size(), size)
+		}
+	}
+}
+
+func TestTargetBytes(t *testing.T) {
+	SetGeometry(160, 90)
+	defer SetGeometry(1920, 1080)
+	noise := image.NewRGBA(image.Rect(0, 0, 160, 90))
+	for i := range noise.Pix {
+		noise.Pix[i] = byte(i * 7919 >> 3)
+	}
+	flat := image.NewRGBA(image.Rect(0, 0, 160, 90))
+	fill(flat, flat.Rect, color.RGBA{40, 80, 120, 255})
+	advance := fakeClock(t)
+	showSlides(t, time.Second, noise, flat)
+	SetQuality(90)
+	const target = 6000
+	SetTargetBytes(target)
+	defer SetTargetBytes(0)
+	var infos []FrameInfo
+	SetFrameHook(func(fi FrameInfo) { infos = append(infos, fi) })
+	defer SetFrameHook(nil)
+
+	for range 2 {
+		if _, err := GenerateFrame(); err != nil {
+			t.Fatal(err)
+		}
+		advance(time.Second)
+	}
+	noisy, plain := infos[0], infos[1]
+	if noisy.Bytes > target || noisy.Quality >= 90 {
+		t.Errorf("noise slide: %d bytes at quality %d, want at most %d bytes", noisy.Bytes, noisy.Quality, target)
+	}
+	// one step up would have been over the target
+	if b, _ := encode(fitSlide(noise), noisy.Quality+1); len(b) <= target {
+		t.Errorf("quality %d fits in %d bytes, above the chosen %d", noisy.Quality+1, len(b), noisy.Quality)
+	}
+	if plain.Quality != 100 {
+		t.Errorf("flat slide chose quality %d, want 100 (it fits at any quality)", plain.Quality)
+	}
+	mu.RLock()
+	cached := len(slideQuality)
+	mu.RUnlock()
+	if cached != 2 {
+		t.Errorf("%d slide qualities cached, want 2", cached)
+	}
+}
+
+func TestMaxBytes(t *testing.T) {
+	SetGeometry(160, 90)
+	defer SetGeometry(1920, 1080)
+	noise := image.NewRGBA(image.Rect(0, 0, 160, 90))
+	for i := range noise.Pix {
+		noise.Pix[i] = byte(i * 7919 >> 3)
+	}
+	showSlides(t, time.Hour, noise)
+	SetQuality(90)
+	var info FrameInfo
+	SetFrameHook(func(fi FrameInfo) { info = fi })
+	defer SetFrameHook(nil)
+	defer SetMaxBytes(0)
+
+	full, err := GenerateFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Capped {
+		t.Fatal("frame capped without SetMaxBytes")
+	}
+	limit := len(full) / 2
+	SetMaxBytes(limit)
+	// twice: the second reuses the quality found by the first
+	for i := range 2 {
+		b, err := GenerateFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(b) > limit || !info.Capped || info.Quality >= 90 || info.Bytes != len(b) {
+			t.Fatalf("frame %d: %d bytes (cap %d) at quality %d, capped %v", i, len(b), limit, info.Quality, info.Capped)
+		}
+	}
+	if b, _ := encode(fitSlide(noise), info.Quality+1); len(b) <= limit {
+		t.Errorf("quality %d fits in %d bytes, above the chosen %d", info.Quality+1, len(b), info.Quality)
+	}
+	// a hint left low by larger content still searches up from there
+	mu.Lock()
+	capQuality = 1
+	mu.Unlock()
+	img := fitSlide(noise)
+	b, _ := encode(img, 90)
+	if _, q, err := capSize(img, b, 90); err != nil || q != info.Quality {
+		t.Errorf("capped from a hint of 1 at quality %d (err %v), want %d", q, err, info.Quality)
+	}
+
+	SetMaxBytes(100)
+	if _, err := GenerateFrame(); !errors.Is(err, ErrFrameTooLarge) {
+		t.Fatalf("frame over the cap at quality 1: err = %v, want ErrFrameTooLarge", err)
+	}
+}
+
+func TestOverlay(t *testing.T) {
+	SetGeometry(64, 32)
+	defer SetGeometry(1920, 1080)
+	black := image.NewRGBA(image.Rect(0, 0, 64, 32))
+	fill(black, black.Rect, color.RGBA{0, 0, 0, 255})
+	fakeClock(t)
+	showSlides(t, time.Hour, black)
+
+	var got time.Time
+	SetOverlay(func(dst *image.RGBA, now time.Time) {
+		got = now
+		fill(dst, image.Rect(0, 0, 16, 16), color.RGBA{255, 0, 0, 255})
+	})
+	defer SetOverlay(nil)
+	corner := func() color.RGBA {
+		t.Helper()
+		b, err := GenerateFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := jpeg.Decode(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, g, bl, _ := img.At(4, 4).RGBA()
+		return color.RGBA{uint8(r >> 8), uint8(g >> 8), uint8(bl >> 8), 255}
+	}
+	if c := corner(); c.R < 200 || c.G > 50 {
+		t.Errorf("corner %v with the overlay, want red", c)
+	}
+	if !got.Equal(clock()) {
+		t.Errorf("overlay called with %v, want the frame time %v", got, clock())
+	}
+	// the slide itself is shared and must not have been drawn on
+	mu.RLock()
+	slide := slides[0].(*image.RGBA)
+	mu.RUnlock()
+	if c := slide.RGBAAt(4, 4); c.R != 0 {
+		t.Errorf("overlay drew on the slide: %v", c)
+	}
+	SetOverlay(nil)
+	if c := corner(); c.R > 50 {
+		t.Errorf("corner %v after removing the overlay, want black", c)
+	}
+}
+
+func TestTicker(t *testing.T) {
+	SetGeometry(64, 32)
+	defer SetGeometry(1920, 1080)
+	black := image.NewRGBA(image.Rect(0, 0, 64, 32))
+	fill(black, black.Rect, color.RGBA{0, 0, 0, 255})
+	advance := fakeClock(t)
+	showSlides(t, time.Hour, black)
+
+	SetTicker("codebits", 100)
+	defer SetTicker("", 0)
+	if text, speed := Ticker(); text != "codebits" || speed != 100 {
+		t.Errorf("Ticker() = %q, %v", text, speed)
+	}
+	frameAt := func() image.Image {
+		t.Helper()
+		b, err := GenerateFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := jpeg.Decode(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return img
+	}
+	// brightness sums the red channel over rows [y0,y1)
+	brightness := func(img image.Image, y0, y1 int) (sum int) {
+		for y := y0; y < y1; y++ {
+			for x := 0; x < 64; x++ {
+				r, _, _, _ := img.At(x, y).RGBA()
+				sum += int(r >> 8)
+			}
+		}
+		return sum
+	}
+	a := frameAt()
+	if brightness(a, 0, 8) > 64*8*16 {
+		t.Error("ticker drew above the bottom band")
+	}
+	if brightness(a, 20, 32) < 64*12*8 {
+		t.Error("no ticker text in the bottom band")
+	}
+	advance(50 * time.Millisecond)
+	b := frameAt()
+	diff := 0
+	for y := 20; y < 32; y++ {
+		for x := 0; x < 64; x++ {
+			ra, _, _, _ := a.At(x, y).RGBA()
+			rb, _, _, _ := b.At(x, y).RGBA()
+			if d := int(ra>>8) - int(rb>>8); d > 0 {
+				diff += d
+			} else {
+				diff -= d
+			}
+		}
+	}
+	if diff < 64*12*8 {
+		t.Errorf("ticker did not scroll: diff %d", diff)
+	}
+	SetTicker("", 0)
+	if s := brightness(frameAt(), 0, 32); s > 64*32*16 {
+		t.Errorf("brightness %d after removing the ticker, want black", s)
+	}
+}
+
+func TestDefaultSlide(t *testing.T) {
+	SetGeometry(8, 8)
+	defer SetGeometry(1920, 1080)
+	defer resetSlideshow()
+	standby := filepath.Join(t.TempDir(), "standby.png")
+	if err := os.WriteFile(standby, pngBytes(t, color.RGBA{0, 255, 0, 255}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetDefaultSlide(filepath.Join(t.TempDir(), "missing.png")); err == nil {
+		t.Error("SetDefaultSlide accepted a missing file")
+	}
+	dir := t.TempDir()
+	if err := StartSlideshow(dir, time.Hour); err == nil {
+		t.Fatal("StartSlideshow of an empty dir succeeded without a default slide")
+	}
+	if err := SetDefaultSlide(standby); err != nil {
+		t.Fatal(err)
+	}
+	defer SetDefaultSlide("")
+
+	pixel := func() color.RGBA {
+		t.Helper()
+		img, _, release := render(true)
+		defer release()
+		r, g, b, _ := img.At(4, 4).RGBA()
+		return color.RGBA{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), 255}
+	}
+	if err := StartSlideshow(dir, time.Hour); err != nil {
+		t.Fatalf("StartSlideshow of an empty dir with a default slide: %v", err)
+	}
+	if c := pixel(); c.G != 255 {
+		t.Errorf("empty dir shows %v, want the green default slide", c)
+	}
+	slide := filepath.Join(dir, "a.png")
+	if err := os.WriteFile(slide, pngBytes(t, color.RGBA{255, 0, 0, 255}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if c := pixel(); c.R != 255 || c.G != 0 {
+		t.Errorf("after adding a slide %v, want red", c)
+	}
+	if err := os.Remove(slide); err != nil {
+		t.Fatal(err)
+	}
+	if err := Reload(); err != nil {
+		t.Fatalf("Reload of an emptied dir with a default slide: %v", err)
+	}
+	if c := pixel(); c.G != 255 {
+		t.Errorf("emptied dir shows %v, want the default slide", c)
+	}
+	// unreadable sources still keep what is on air
+	if err := os.Remove(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := Reload(); err == nil {
+		t.Error("Reload of a missing dir succeeded")
+	}
+}
+
+func TestStationID(t *testing.T) {
+	SetGeometry(200, 60)
+	defer SetGeometry(1920, 1080)
+	grey := image.NewRGBA(image.Rect(0, 0, 200, 60))
+	fill(grey, grey.Rect, color.RGBA{128, 128, 128, 255})
+	fakeClock(t)
+	showSlides(t, time.Hour, grey)
+
+	for _, s := range []StationIDStyle{
+		{Corner: "middle", Opacity: 0.1, Scale: 1},
+		{Corner: "top-left", Opacity: 0, Scale: 1},
+		{Corner: "top-left", Opacity: 0.1, Scale: 9},
+	} {
+		if err := SetStationIDStyle(s); err == nil {
+			t.Errorf("SetStationIDStyle(%+v) succeeded", s)
+		}
+	}
+	defer SetStationIDStyle(StationIDStyle{Corner: "bottom-right", Opacity: 0.1, Scale: 1})
+	SetStationID("LOBBY-3")
+	defer SetStationID("")
+
+	// changed returns how far the frame strays from grey in each half
+	changed := func() (left, right int) {
+		t.Helper()
+		img, _, release := render(false)
+		defer release()
+		for y := 0; y < 60; y++ {
+			for x := 0; x < 200; x++ {
+				r, _, _, _ := img.At(x, y).RGBA()
+				d := int(r>>8) - 128
+				if d < 0 {
+					d = -d
+				}
+				if x < 100 {
+					left += d
+				} else {
+					right += d
+				}
+			}
+		}
+		return left, right
+	}
+	left, faint := changed()
+	if left != 0 || faint == 0 {
+		t.Errorf("bottom-right tag changed left %d, right %d", left, faint)
+	}
+	if err := SetStationIDStyle(StationIDStyle{Corner: "top-left", Opacity: 1, Scale: 1}); err != nil {
+		t.Fatal(err)
+	}
+	left, right := changed()
+	if left <= faint*5 || right != 0 {
+		t.Errorf("solid top-left tag changed left %d, right %d; faint one %d", left, right, faint)
+	}
+	// the time makes the tag run on into the right half
+	if err := SetStationIDStyle(StationIDStyle{Corner: "top-left", Opacity: 1, Scale: 1, Timestamp: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, right := changed(); right == 0 {
+		t.Error("no timestamp after the station ID")
+	}
+	SetStationID("")
+	if left, right := changed(); left != 0 || right != 0 {
+		t.Errorf("after removing the tag: left %d, right %d", left, right)
+	}
+}
@@ -0,0 +1,51 @@
+package frame
+
+import (
+	"image"
+	"time"
+)
+
+// FrameInfo describes a frame produced by GenerateFrame or GenerateLayers.
+type FrameInfo struct {
+	Bytes   int           // size of the encoded JPEG
+	Encode  time.Duration // time spent encoding it
+	Quality int           // JPEG quality it was encoded at
+	Slide   int           // index of the slide on air; 0 when there is no slideshow
+	Fade    bool          // whether it blends two slides mid-transition
+	Capped  bool          // re-encoded at a lower Quality to fit SetMaxBytes
+}
+
+var frameHook func(FrameInfo)
+
+// SetFrameHook registers fn to be called with every frame GenerateFrame and
+// GenerateLayers encode (not Snapshot, nor the extra layers), or removes the
+// hook if fn is nil. fn runs on the generating goroutine, so it should be
+// quick.
+func SetFrameHook(fn func(FrameInfo)) {
+	mu.Lock()
+	defer mu.Unlock()
+	frameHook = fn
+}
+
+// encodeFrame encodes a rendered frame at info.Quality, or lower to fit
+// SetMaxBytes, and reports it to the frame hook.
+func encodeFrame(img image.Image, info FrameInfo) ([]byte, error) {
+	start := time.Now()
+	b, err := encode(img, info.Quality)
+	if err != nil {
+		return nil, err
+	}
+	q := info.Quality
+	if b, info.Quality, err = capSize(img, b, q); err != nil {
+		return nil, err
+	}
+	info.Capped = info.Quality != q
+	mu.RLock()
+	hook := frameHook
+	mu.RUnlock()
+	if hook != nil {
+		info.Bytes, info.Encode = len(b), time.Since(start)
+		hook(info)
+	}
+	return b, nil
+}
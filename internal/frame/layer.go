@@ -0,0 +1,58 @@
+package frame
+
+import (
+	"bytes"
+	"image/jpeg"
+
+	draw2 "golang.org/x/image/draw"
+)
+
+// Layer is an additional encoding of every rendered frame, for simulcasting
+// a smaller or cheaper copy of the stream alongside the full one.
+type Layer struct {
+	Width, Height int
+	Quality       int // JPEG quality, 1-100
+}
+
+// GenerateLayers renders a single frame, exactly as GenerateFrame would, and
+// returns it encoded once at the configured geometry and quality followed by
+// one encoding per layer, scaled to the layer's geometry.
+func GenerateLayers(layers []Layer) ([][]byte, error) {
+	img, info, release := render(true)
+	defer release()
+	out := make([][]byte, 0, 1+len(layers))
+	b, err := encodeFrame(img, info)
+	if err != nil {
+		return nil, err
+	}
+	out = append(out, b)
+	for _, l := range layers {
+		dst := getCanvas(l.Width, l.Height)
+		draw2.ApproxBiLinear.Scale(dst, dst.Bounds(), img, img.Bounds(), draw2.Src, nil)
+		b, err := encode(dst, min(max(l.Quality, 1), 100))
+		putCanvas(dst)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, b)
+	}
+	return out, nil
+}
+
+// Thumbnail decodes the JPEG b and re-encodes it scaled to width pixels
+// wide, keeping its aspect ratio, at quality. Images no wider than width
+// keep their size.
+func Thumbnail(b []byte, width, quality int) ([]byte, error) {
+	img, err := jpeg.Decode(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	sw, sh := img.Bounds().Dx(), img.Bounds().Dy()
+	if width <= 0 || width > sw {
+		width = sw
+	}
+	dst := getCanvas(width, max(1, sh*width/sw))
+	defer putCanvas(dst)
+	draw2.ApproxBiLinear.Scale(dst, dst.Bounds(), img, img.Bounds(), draw2.Src, nil)
+	return encode(dst, min(max(quality, 1), 100))
+}
@@ -0,0 +1,141 @@
+package frame
+
+import (
+	"container/list"
+	"image"
+	"image/color"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// lazyKeep is how many decoded slides lazy mode keeps: the current one, the
+// next one (decoded ahead for the fade) and one spare.
+const lazyKeep = 3
+
+var lazyLoad = false
+
+// SetLazyLoad makes StartSlideshow and Reload keep only the paths of local
+// slides and decode each one when it is about to be shown, holding at most a
+// few decoded slides in memory. That bounds memory for libraries of hundreds
+// of images (a 1080p slide is 8 MiB decoded), at the cost of a decode per
+// slide change, done in the background while the previous slide is shown.
+// Remote (http) slides are always loaded eagerly.
+func SetLazyLoad(enabled bool) {
+	mu.Lock()
+	lazyLoad = enabled
+	mu.Unlock()
+}
+
+// lazySlide stands in for a decoded slide in lazy mode. The frame code
+// resolves it with decoded; At is only a fallback and decodes on demand.
+type lazySlide struct {
+	path string
+	rect image.Rectangle // geometry when loaded
+}
+
+func (s *lazySlide) ColorModel() color.Model { return color.RGBAModel }
+func (s *lazySlide) Bounds() image.Rectangle { return s.rect }
+func (s *lazySlide) At(x, y int) color.Color { return s.get().At(x, y) }
+
+// lazyEntry is a decoded (or decoding) slide in the LRU.
+type lazyEntry struct {
+	slide *lazySlide
+	ready chan struct{} // closed once img is set
+	img   *image.RGBA
+}
+
+var (
+	lazyMu  sync.Mutex
+	lazyLRU = list.New() // of *lazyEntry, most recently used first
+	lazyIdx = map[*lazySlide]*list.Element{}
+)
+
+// get returns the decoded slide, decoding it (once, even if several callers
+// ask concurrently) on a miss and evicting the least recently used one.
+func (s *lazySlide) get() *image.RGBA {
+	lazyMu.Lock()
+	if el, ok := lazyIdx[s]; ok {
+		lazyLRU.MoveToFront(el)
+		e := el.Value.(*lazyEntry)
+		lazyMu.Unlock()
+		<-e.ready
+		return e.img
+	}
+	e := &lazyEntry{slide: s, ready: make(chan struct{})}
+	lazyIdx[s] = lazyLRU.PushFront(e)
+	for lazyLRU.Len() > lazyKeep {
+		old := lazyLRU.Remove(lazyLRU.Back()).(*lazyEntry)
+		delete(lazyIdx, old.slide)
+	}
+	lazyMu.Unlock()
+
+	imgs, errs := decodeLocal([]string{s.path})
+	if len(imgs) == 1 {
+		e.img = imgs[0].(*image.RGBA)
+	} else {
+		// the file went away or broke since loading: show the background
+		// rather than stall the stream
+		for _, err := range errs {
+			slog.Warn("lazy slide", "err", err)
+		}
+		mu.RLock()
+		bg := background
+		mu.RUnlock()
+		e.img = image.NewRGBA(s.rect)
+		fill(e.img, e.img.Rect, color.RGBAModel.Convert(bg).(color.RGBA))
+	}
+	close(e.ready)
+	return e.img
+}
+
+// decoded returns img as an RGBA, decoding it first if it is a lazy slide.
+func decoded(img image.Image) *image.RGBA {
+	if s, ok := img.(*lazySlide); ok {
+		return s.get()
+	}
+	return img.(*image.RGBA)
+}
+
+// prefetch decodes img in the background if it is a lazy slide, so it is
+// ready by the time it is shown.
+func prefetch(img image.Image) {
+	if s, ok := img.(*lazySlide); ok {
+		go s.get()
+	}
+}
+
+// lazySlides checks that each path looks like a decodable image without
+// decoding it and returns placeholders for the good ones.
+func lazySlides(paths []string) ([]image.Image, []error) {
+	mu.RLock()
+	rect := image.Rect(0, 0, frameW, frameH)
+	mu.RUnlock()
+	lazyMu.Lock()
+	// slides from a previous load may have been decoded with other settings
+	lazyLRU.Init()
+	clear(lazyIdx)
+	lazyMu.Unlock()
+
+	var imgs []image.Image
+	var errs []error
+	for _, p := range paths {
+		if !strings.EqualFold(filepath.Ext(p), ".svg") {
+			f, err := os.Open(p)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			_, _, err = image.DecodeConfig(f)
+			f.Close()
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+		}
+		imgs = append(imgs, &lazySlide{path: p, rect: rect})
+	}
+	return imgs, errs
+}
@@ -0,0 +1,128 @@
+package frame
+
+import (
+	"image"
+	"math/rand/v2"
+	"os"
+	"sort"
+)
+
+// Slide ordering modes accepted by SetOrder.
+const (
+	OrderName    = "name"
+	OrderNatural = "natural"
+	OrderMtime   = "mtime"
+	OrderShuffle = "shuffle"
+)
+
+// sortPaths orders slide paths in place according to mode.
+func sortPaths(paths []string, mode string) {
+	switch mode {
+	case OrderNatural:
+		sort.SliceStable(paths, func(i, j int) bool { return naturalLess(paths[i], paths[j]) })
+	case OrderMtime:
+		sort.Strings(paths)
+		mtimes := make(map[string]int64, len(paths))
+		for _, p := range paths {
+			if fi, err := os.Stat(p); err == nil {
+				mtimes[p] = fi.ModTime().UnixNano()
+			}
+		}
+		sort.SliceStable(paths, func(i, j int) bool { return mtimes[paths[i]] < mtimes[paths[j]] })
+	case OrderShuffle:
+		rand.Shuffle(len(paths), func(i, j int) { paths[i], paths[j] = paths[j], paths[i] })
+	default:
+		sort.Strings(paths)
+	}
+}
+
+// naturalLess compares strings treating runs of digits as numbers, so that
+// "img2.jpg" sorts before "img10.jpg".
+func naturalLess(a, b string) bool {
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		ca, cb := a[i], b[j]
+		if isDigit(ca) && isDigit(cb) {
+			si, sj := i, j
+			for i < len(a) && isDigit(a[i]) {
+				i++
+			}
+			for j < len(b) && isDigit(b[j]) {
+				j++
+			}
+			// compare numerically: strip leading zeros, then by length, then lexically
+			na, nb := trimZeros(a[si:i]), trimZeros(b[sj:j])
+			if len(na) != len(nb) {
+				return len(na) < len(nb)
+			}
+			if na != nb {
+				return na < nb
+			}
+			// equal values: fewer leading zeros first
+			if i-si != j-sj {
+				return i-si < j-sj
+			}
+			continue
+		}
+		if ca != cb {
+			return ca < cb
+		}
+		i++
+		j++
+	}
+	return len(a)-i < len(b)-j
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+func trimZeros(s string) string {
+	for len(s) > 1 && s[0] == '0' {
+		s = s[1:]
+	}
+	return s
+}
+
+// upcoming returns the slide that follows cur. In shuffle mode the slide after
+// the last one is the first of the next cycle's (pre-computed) order, so fades
+// into a new cycle blend toward the right image. mu must be held.
+func upcoming() image.Image {
+	if cur+1 < len(slides) {
+		return slides[cur+1]
+	}
+	if order == OrderShuffle {
+		if pending == nil {
+			pending = shuffled(slides)
+		}
+		return pending[0]
+	}
+	return slides[0]
+}
+
+// advance moves to the next slide, switching to a freshly shuffled order at the
+// end of each cycle in shuffle mode. mu must be held.
+func advance() {
+	cur++
+	if cur < len(slides) {
+		return
+	}
+	if order == OrderShuffle {
+		if pending == nil {
+			pending = shuffled(slides)
+		}
+		slides = pending
+		pending = nil
+	}
+	cur = 0
+}
+
+// shuffled returns a random permutation of imgs that does not start with the
+// last image of imgs, so a slide is never shown twice in a row across cycles.
+func shuffled(imgs []image.Image) []image.Image {
+	out := make([]image.Image, len(imgs))
+	copy(out, imgs)
+	rand.Shuffle(len(out), func(i, j int) { out[i], out[j] = out[j], out[i] })
+	if len(out) > 1 && out[0] == imgs[len(imgs)-1] {
+		out[0], out[1] = out[1], out[0]
+	}
+	return out
+}
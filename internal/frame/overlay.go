@@ -0,0 +1,58 @@
+package frame
+
+import (
+	"image"
+	"time"
+)
+
+var overlay func(dst *image.RGBA, now time.Time)
+
+// SetOverlay registers fn to draw on every frame after the slide, fade or
+// test pattern is composited and before it is encoded, or removes it if fn
+// is nil. dst is the whole frame at the configured geometry and now is the
+// frame's time; fn may draw anything on it with image/draw and the like. It
+// runs on the generating goroutine for every frame, so it must be fast, and
+// it must be safe to call from whichever goroutine generates frames. The
+// ticker and timestamp overlays (see SetTicker and SetTimestamp) are drawn
+// first, and the station ID (see SetStationID) last.
+func SetOverlay(fn func(dst *image.RGBA, now time.Time)) {
+	mu.Lock()
+	defer mu.Unlock()
+	overlay = fn
+}
+
+// drawTimestamp is the built-in overlay SetTimestamp enables.
+func drawTimestamp(dst *image.RGBA, now time.Time) {
+	addLabel(dst, 20, dst.Bounds().Dy()-30, now.Format("2006-01-02 15:04:05"))
+}
+
+// frameOverlay returns a function drawing every overlay due on a frame, in
+// order: the ticker, the timestamp if timestamp is set, the SetOverlay
+// callback, then the station ID. It returns nil when there are none, so slides can be encoded
+// without a copy. mu must be held for writing.
+func frameOverlay(timestamp bool) func(*image.RGBA, time.Time) {
+	var fns []func(*image.RGBA, time.Time)
+	if t := tickerOverlay(frameH); t != nil {
+		fns = append(fns, t)
+	}
+	if timestamp {
+		fns = append(fns, drawTimestamp)
+	}
+	if overlay != nil {
+		fns = append(fns, overlay)
+	}
+	if id := stationIDOverlay(); id != nil {
+		fns = append(fns, id)
+	}
+	switch len(fns) {
+	case 0:
+		return nil
+	case 1:
+		return fns[0]
+	}
+	return func(dst *image.RGBA, now time.Time) {
+		for _, fn := range fns {
+			fn(dst, now)
+		}
+	}
+}
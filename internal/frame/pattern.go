@@ -0,0 +1,121 @@
+package frame
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// Built-in test patterns accepted by SetPattern, rendered when no slideshow is
+// running.
+const (
+	PatternNone     = ""
+	PatternBars     = "bars"
+	PatternGradient = "gradient"
+	PatternSysmon   = "sysmon"
+)
+
+var (
+	pattern      = PatternNone
+	patternCache *image.RGBA // rendered pattern at the current geometry
+)
+
+// SetPattern selects the test pattern shown when no slides are loaded: "bars"
+// (SMPTE-style colour bars), "gradient" (grey and RGB ramps), "sysmon" (live
+// CPU, memory, load and temperature of the host) or "" for the plain black
+// timestamp frame.
+func SetPattern(name string) error {
+	switch name {
+	case PatternNone, PatternBars, PatternGradient, PatternSysmon:
+	default:
+		return fmt.Errorf("unknown pattern %q", name)
+	}
+	mu.Lock()
+	pattern = name
+	patternCache = nil
+	mu.Unlock()
+	return nil
+}
+
+// patternImage returns the configured pattern rendered at w x h, or nil when
+// no pattern is set or it changes on every frame (sysmon). mu must be held.
+func patternImage(w, h int) *image.RGBA {
+	if pattern == PatternNone || pattern == PatternSysmon {
+		return nil
+	}
+	if patternCache != nil && patternCache.Bounds().Dx() == w && patternCache.Bounds().Dy() == h {
+		return patternCache
+	}
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	switch pattern {
+	case PatternBars:
+		drawBars(img)
+	case PatternGradient:
+		drawGradient(img)
+	}
+	patternCache = img
+	return img
+}
+
+func fill(img *image.RGBA, r image.Rectangle, c color.RGBA) {
+	draw.Draw(img, r, &image.Uniform{C: c}, image.Point{}, draw.Src)
+}
+
+// drawBars renders SMPTE ECR 1-1978 style bars: seven 75% bars, a reverse
+// castellation strip, and a bottom row with -I, white, +Q and PLUGE.
+func drawBars(img *image.RGBA) {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	top := []color.RGBA{
+		{191, 191, 191, 255}, {191, 191, 0, 255}, {0, 191, 191, 255}, {0, 191, 0, 255},
+		{191, 0, 191, 255}, {191, 0, 0, 255}, {0, 0, 191, 255},
+	}
+	mid := []color.RGBA{
+		{0, 0, 191, 255}, {19, 19, 19, 255}, {191, 0, 191, 255}, {19, 19, 19, 255},
+		{0, 191, 191, 255}, {19, 19, 19, 255}, {191, 191, 191, 255},
+	}
+	y1 := h * 2 / 3
+	y2 := h * 3 / 4
+	barX := func(i int) int { return w * i / 7 }
+	for i := range top {
+		fill(img, image.Rect(barX(i), 0, barX(i+1), y1), top[i])
+		fill(img, image.Rect(barX(i), y1, barX(i+1), y2), mid[i])
+	}
+	// bottom: the first five bar widths hold -I, white, +Q and black
+	bottom := []color.RGBA{{0, 33, 76, 255}, {255, 255, 255, 255}, {50, 0, 106, 255}, {19, 19, 19, 255}}
+	five := barX(5)
+	for i, c := range bottom {
+		fill(img, image.Rect(five*i/4, y2, five*(i+1)/4, h), c)
+	}
+	// PLUGE under the sixth bar (blacker than black, black, lighter than black)
+	pluge := []color.RGBA{{9, 9, 9, 255}, {19, 19, 19, 255}, {29, 29, 29, 255}}
+	six := barX(6)
+	for i, c := range pluge {
+		fill(img, image.Rect(five+(six-five)*i/3, y2, five+(six-five)*(i+1)/3, h), c)
+	}
+	fill(img, image.Rect(six, y2, w, h), color.RGBA{19, 19, 19, 255})
+}
+
+// drawGradient renders four horizontal 0-255 ramps: grey, red, green, blue.
+func drawGradient(img *image.RGBA) {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	for y := 0; y < h; y++ {
+		band := y * 4 / h
+		for x := 0; x < w; x++ {
+			v := uint8(0)
+			if w > 1 {
+				v = uint8(x * 255 / (w - 1))
+			}
+			c := color.RGBA{v, v, v, 255}
+			switch band {
+			case 1:
+				c = color.RGBA{v, 0, 0, 255}
+			case 2:
+				c = color.RGBA{0, v, 0, 255}
+			case 3:
+				c = color.RGBA{0, 0, v, 255}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+}
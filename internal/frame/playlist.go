@@ -0,0 +1,58 @@
+package frame
+
+import (
+	"bufio"
+	"errors"
+	"image"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Stdin is the slide source that reads a playlist from standard input.
+const Stdin = "-"
+
+var (
+	playlistMu sync.Mutex
+	playlistIn *bufio.Reader // wraps os.Stdin once, so Reload continues where the last playlist ended
+)
+
+// readPlaylist reads one playlist from r: slide paths, one per line, ending
+// at a blank line or EOF. Lines starting with # are comments.
+func readPlaylist(r *bufio.Reader) ([]string, error) {
+	var paths []string
+	for {
+		line, err := r.ReadString('\n')
+		line = strings.TrimSpace(line)
+		if line == "" && err == nil && len(paths) > 0 {
+			return paths, nil
+		}
+		if line != "" && !strings.HasPrefix(line, "#") {
+			paths = append(paths, line)
+		}
+		if errors.Is(err, io.EOF) {
+			if len(paths) == 0 {
+				return nil, errors.New("empty playlist on stdin")
+			}
+			return paths, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// loadPlaylist loads the next playlist from stdin, keeping its order.
+func loadPlaylist() ([]image.Image, error) {
+	playlistMu.Lock()
+	if playlistIn == nil {
+		playlistIn = bufio.NewReader(os.Stdin)
+	}
+	paths, err := readPlaylist(playlistIn)
+	playlistMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return decodePaths("stdin", paths)
+}
@@ -0,0 +1,53 @@
+package frame
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"sync"
+)
+
+// Canvases and encode buffers are recycled between frames: at 1080p a canvas
+// is 8 MiB, which at higher frame rates otherwise dominates GC work. Canvases
+// are pooled per size since simulcast layers render at several geometries.
+var (
+	canvasMu    sync.Mutex
+	canvasPools = map[image.Point]*sync.Pool{}
+	bufPool     = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+)
+
+func canvasPool(w, h int) *sync.Pool {
+	canvasMu.Lock()
+	defer canvasMu.Unlock()
+	k := image.Pt(w, h)
+	p, ok := canvasPools[k]
+	if !ok {
+		p = &sync.Pool{New: func() any { return image.NewRGBA(image.Rect(0, 0, w, h)) }}
+		canvasPools[k] = p
+	}
+	return p
+}
+
+// getCanvas returns a w x h canvas with undefined contents; callers must
+// overwrite every pixel.
+func getCanvas(w, h int) *image.RGBA { return canvasPool(w, h).Get().(*image.RGBA) }
+
+func putCanvas(c *image.RGBA) { canvasPool(c.Rect.Dx(), c.Rect.Dy()).Put(c) }
+
+// encode JPEG-encodes img into a pooled buffer and returns a copy, so the
+// caller owns the result (the sender may still be writing it out while the
+// next frame is being encoded).
+func encode(img image.Image, q int) ([]byte, error) {
+	buf := bufPool.Get().(*bytes.Buffer)
+	defer bufPool.Put(buf)
+	buf.Reset()
+	mu.RLock()
+	prog := progressive
+	mu.RUnlock()
+	if prog {
+		encodeProgressive(buf, img, q)
+	} else if err := jpeg.Encode(buf, img, &jpeg.Options{Quality: q}); err != nil {
+		return nil, err
+	}
+	return bytes.Clone(buf.Bytes()), nil
+}
@@ -0,0 +1,430 @@
+package frame
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math"
+	"sync"
+)
+
+// The standard library only writes baseline JPEGs, so progressive frames come
+// from the small encoder below. It uses the same 4:2:0 layout, quantisation
+// tables and Huffman tables (ITU T.81 Annex K) as image/jpeg, and splits the
+// coefficients into spectral-selection scans: every block's DC first, then
+// the low luma frequencies, then chroma, then the rest of luma. A browser
+// shows a blurry frame after the first scan and sharpens it as the rest
+// arrives. Successive approximation is not used.
+
+var progressive = false
+
+// SetProgressive makes frames progressive JPEGs instead of baseline ones.
+// They are usually a little larger and slower to encode, but on slow links
+// browsers show a coarse frame early instead of painting it top to bottom.
+func SetProgressive(enabled bool) {
+	mu.Lock()
+	progressive = enabled
+	mu.Unlock()
+}
+
+// unzig maps a zigzag index to the natural (row-major) index in a block.
+var unzig = [64]int{
+	0, 1, 8, 16, 9, 2, 3, 10,
+	17, 24, 32, 25, 18, 11, 4, 5,
+	12, 19, 26, 33, 40, 48, 41, 34,
+	27, 20, 13, 6, 7, 14, 21, 28,
+	35, 42, 49, 56, 57, 50, 43, 36,
+	29, 22, 15, 23, 30, 37, 44, 51,
+	58, 59, 52, 45, 38, 31, 39, 46,
+	53, 60, 61, 54, 47, 55, 62, 63,
+}
+
+// baseQuant are the Annex K luminance and chrominance tables, in natural
+// order, for quality 50.
+var baseQuant = [2][64]int{{
+	16, 11, 10, 16, 24, 40, 51, 61,
+	12, 12, 14, 19, 26, 58, 60, 55,
+	14, 13, 16, 24, 40, 57, 69, 56,
+	14, 17, 22, 29, 51, 87, 80, 62,
+	18, 22, 37, 56, 68, 109, 103, 77,
+	24, 35, 55, 64, 81, 104, 113, 92,
+	49, 64, 78, 87, 103, 121, 120, 101,
+	72, 92, 95, 98, 112, 100, 103, 99,
+}, {
+	17, 18, 24, 47, 99, 99, 99, 99,
+	18, 21, 26, 66, 99, 99, 99, 99,
+	24, 26, 56, 99, 99, 99, 99, 99,
+	47, 66, 99, 99, 99, 99, 99, 99,
+	99, 99, 99, 99, 99, 99, 99, 99,
+	99, 99, 99, 99, 99, 99, 99, 99,
+	99, 99, 99, 99, 99, 99, 99, 99,
+	99, 99, 99, 99, 99, 99, 99, 99,
+}}
+
+// huffSpec is a Huffman table as written in a DHT segment: counts of codes
+// of each length 1-16, then the symbols in code order.
+type huffSpec struct {
+	counts [16]byte
+	values []byte
+}
+
+// huffSpecs are the Annex K tables: luma DC, luma AC, chroma DC, chroma AC.
+var huffSpecs = [4]huffSpec{{
+	[16]byte{0, 1, 5, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0},
+	[]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+}, {
+	[16]byte{0, 2, 1, 3, 3, 2, 4, 3, 5, 5, 4, 4, 0, 0, 1, 125},
+	[]byte{
+		0x01, 0x02, 0x03, 0x00, 0x04, 0x11, 0x05, 0x12,
+		0x21, 0x31, 0x41, 0x06, 0x13, 0x51, 0x61, 0x07,
+		0x22, 0x71, 0x14, 0x32, 0x81, 0x91, 0xa1, 0x08,
+		0x23, 0x42, 0xb1, 0xc1, 0x15, 0x52, 0xd1, 0xf0,
+		0x24, 0x33, 0x62, 0x72, 0x82, 0x09, 0x0a, 0x16,
+		0x17, 0x18, 0x19, 0x1a, 0x25, 0x26, 0x27, 0x28,
+		0x29, 0x2a, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39,
+		0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48, 0x49,
+		0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59,
+		0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69,
+		0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79,
+		0x7a, 0x83, 0x84, 0x85, 0x86, 0x87, 0x88, 0x89,
+		0x8a, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98,
+		0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7,
+		0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6,
+		0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3, 0xc4, 0xc5,
+		0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2, 0xd3, 0xd4,
+		0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda, 0xe1, 0xe2,
+		0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0xea,
+		0xf1, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+		0xf9, 0xfa,
+	},
+}, {
+	[16]byte{0, 3, 1, 1, 1, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0},
+	[]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+}, {
+	[16]byte{0, 2, 1, 2, 4, 4, 3, 4, 7, 5, 4, 4, 0, 1, 2, 119},
+	[]byte{
+		0x00, 0x01, 0x02, 0x03, 0x11, 0x04, 0x05, 0x21,
+		0x31, 0x06, 0x12, 0x41, 0x51, 0x07, 0x61, 0x71,
+		0x13, 0x22, 0x32, 0x81, 0x08, 0x14, 0x42, 0x91,
+		0xa1, 0xb1, 0xc1, 0x09, 0x23, 0x33, 0x52, 0xf0,
+		0x15, 0x62, 0x72, 0xd1, 0x0a, 0x16, 0x24, 0x34,
+		0xe1, 0x25, 0xf1, 0x17, 0x18, 0x19, 0x1a, 0x26,
+		0x27, 0x28, 0x29, 0x2a, 0x35, 0x36, 0x37, 0x38,
+		0x39, 0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48,
+		0x49, 0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58,
+		0x59, 0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68,
+		0x69, 0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78,
+		0x79, 0x7a, 0x82, 0x83, 0x84, 0x85, 0x86, 0x87,
+		0x88, 0x89, 0x8a, 0x92, 0x93, 0x94, 0x95, 0x96,
+		0x97, 0x98, 0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5,
+		0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4,
+		0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3,
+		0xc4, 0xc5, 0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2,
+		0xd3, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda,
+		0xe2, 0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9,
+		0xea, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+		0xf9, 0xfa,
+	},
+}}
+
+// huffCode is the code for one symbol.
+type huffCode struct {
+	bits uint32
+	n    uint
+}
+
+var (
+	huffOnce   sync.Once
+	huffTables [4][256]huffCode
+	// dctCos[u][x] is C(u)/2 * cos((2x+1)uπ/16), so that a row pass and a
+	// column pass with it make the 2D forward DCT
+	dctCos [8][8]float32
+)
+
+func initProgressive() {
+	for i, s := range huffSpecs {
+		code, k := uint32(0), 0
+		for l, n := range s.counts {
+			for range n {
+				huffTables[i][s.values[k]] = huffCode{code, uint(l + 1)}
+				code++
+				k++
+			}
+			code <<= 1
+		}
+	}
+	for u := range 8 {
+		c := 0.5
+		if u == 0 {
+			c = 0.5 / math.Sqrt2
+		}
+		for x := range 8 {
+			dctCos[u][x] = float32(c * math.Cos(float64(2*x+1)*float64(u)*math.Pi/16))
+		}
+	}
+}
+
+// quantTables scales the base tables to quality q as libjpeg does.
+func quantTables(q int) (t [2][64]int) {
+	scale := 200 - 2*q
+	if q < 50 {
+		scale = 5000 / q
+	}
+	for i := range t {
+		for k, v := range baseQuant[i] {
+			t[i][k] = min(max((v*scale+50)/100, 1), 255)
+		}
+	}
+	return t
+}
+
+// plane is one colour component at its own resolution.
+type plane struct {
+	w, h int
+	pix  []uint8
+}
+
+func (p *plane) at(x, y int) uint8 {
+	// edge pixels are repeated into the partial blocks at the borders
+	return p.pix[min(y, p.h-1)*p.w+min(x, p.w-1)]
+}
+
+// component is one component's quantised coefficients, a block per 8x8,
+// stored in zigzag order and covering whole MCUs.
+type component struct {
+	bw, bh int // blocks per row and column, padded to MCUs
+	sw, sh int // blocks a non-interleaved scan covers
+	blocks [][64]int16
+}
+
+// ycbcrPlanes converts img to a full-resolution Y plane and 2x2-averaged
+// Cb and Cr planes.
+func ycbcrPlanes(img image.Image) (y, cb, cr *plane) {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	y = &plane{w, h, make([]uint8, w*h)}
+	cbFull := make([]uint8, w*h)
+	crFull := make([]uint8, w*h)
+	rgba, _ := img.(*image.RGBA)
+	for j := range h {
+		for i := range w {
+			var r, g, bl uint8
+			if rgba != nil {
+				o := rgba.PixOffset(b.Min.X+i, b.Min.Y+j)
+				r, g, bl = rgba.Pix[o], rgba.Pix[o+1], rgba.Pix[o+2]
+			} else {
+				c := color.RGBAModel.Convert(img.At(b.Min.X+i, b.Min.Y+j)).(color.RGBA)
+				r, g, bl = c.R, c.G, c.B
+			}
+			y.pix[j*w+i], cbFull[j*w+i], crFull[j*w+i] = color.RGBToYCbCr(r, g, bl)
+		}
+	}
+	cw, ch := (w+1)/2, (h+1)/2
+	cb = &plane{cw, ch, make([]uint8, cw*ch)}
+	cr = &plane{cw, ch, make([]uint8, cw*ch)}
+	for j := range ch {
+		for i := range cw {
+			var sb, sr, n int
+			for dy := range 2 {
+				for dx := range 2 {
+					x, yy := 2*i+dx, 2*j+dy
+					if x < w && yy < h {
+						sb += int(cbFull[yy*w+x])
+						sr += int(crFull[yy*w+x])
+						n++
+					}
+				}
+			}
+			cb.pix[j*cw+i] = uint8((sb + n/2) / n)
+			cr.pix[j*cw+i] = uint8((sr + n/2) / n)
+		}
+	}
+	return y, cb, cr
+}
+
+// transform fills c with the DCT of every block of p, quantised with qt.
+func (c *component) transform(p *plane, qt *[64]int) {
+	var px, tmp [8][8]float32
+	for by := range c.bh {
+		for bx := range c.bw {
+			for y := range 8 {
+				for x := range 8 {
+					px[y][x] = float32(p.at(bx*8+x, by*8+y)) - 128
+				}
+			}
+			// rows, then columns
+			for y := range 8 {
+				for u := range 8 {
+					var s float32
+					for x := range 8 {
+						s += dctCos[u][x] * px[y][x]
+					}
+					tmp[y][u] = s
+				}
+			}
+			blk := &c.blocks[by*c.bw+bx]
+			for k, n := range unzig {
+				v, u := n/8, n%8
+				var s float32
+				for y := range 8 {
+					s += dctCos[v][y] * tmp[y][u]
+				}
+				blk[k] = int16(math.Round(float64(s) / float64(qt[n])))
+			}
+		}
+	}
+}
+
+// bitWriter writes entropy-coded data, stuffing a zero after every 0xff.
+type bitWriter struct {
+	buf *bytes.Buffer
+	acc uint32
+	n   uint
+}
+
+func (w *bitWriter) emit(bits uint32, n uint) {
+	w.acc = w.acc<<n | bits&(1<<n-1)
+	w.n += n
+	for w.n >= 8 {
+		b := byte(w.acc >> (w.n - 8))
+		w.buf.WriteByte(b)
+		if b == 0xff {
+			w.buf.WriteByte(0)
+		}
+		w.n -= 8
+	}
+}
+
+func (w *bitWriter) huff(t *[256]huffCode, sym byte) { w.emit(t[sym].bits, t[sym].n) }
+
+// value writes v as its size category's extra bits.
+func (w *bitWriter) value(v int32, size uint) {
+	if v < 0 {
+		v--
+	}
+	w.emit(uint32(v), size)
+}
+
+// flush pads the last byte with ones, as a scan must end.
+func (w *bitWriter) flush() {
+	if w.n > 0 {
+		w.emit(1<<(8-w.n)-1, 8-w.n)
+	}
+	w.acc, w.n = 0, 0
+}
+
+// category returns the number of bits needed for |v|.
+func category(v int32) uint {
+	if v < 0 {
+		v = -v
+	}
+	n := uint(0)
+	for v != 0 {
+		n++
+		v >>= 1
+	}
+	return n
+}
+
+func writeMarker(buf *bytes.Buffer, m byte, payload ...byte) {
+	buf.Write([]byte{0xff, m, byte((len(payload) + 2) >> 8), byte(len(payload) + 2)})
+	buf.Write(payload)
+}
+
+// encodeProgressive writes img as a progressive JPEG of quality q to buf.
+func encodeProgressive(buf *bytes.Buffer, img image.Image, q int) {
+	huffOnce.Do(initProgressive)
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	mx, my := (w+15)/16, (h+15)/16
+	qt := quantTables(q)
+	py, pcb, pcr := ycbcrPlanes(img)
+	comps := [3]*component{
+		{bw: 2 * mx, bh: 2 * my, sw: (w + 7) / 8, sh: (h + 7) / 8},
+		{bw: mx, bh: my, sw: (pcb.w + 7) / 8, sh: (pcb.h + 7) / 8},
+		{bw: mx, bh: my, sw: (pcr.w + 7) / 8, sh: (pcr.h + 7) / 8},
+	}
+	for i, p := range []*plane{py, pcb, pcr} {
+		comps[i].blocks = make([][64]int16, comps[i].bw*comps[i].bh)
+		comps[i].transform(p, &qt[min(i, 1)])
+	}
+
+	buf.Write([]byte{0xff, 0xd8})
+	dqt := make([]byte, 0, 2*65)
+	for i := range qt {
+		dqt = append(dqt, byte(i))
+		for _, n := range unzig {
+			dqt = append(dqt, byte(qt[i][n]))
+		}
+	}
+	writeMarker(buf, 0xdb, dqt...)
+	// SOF2: progressive, Huffman; 2x2 luma sampling, 1x1 chroma
+	writeMarker(buf, 0xc2, 8, byte(h>>8), byte(h), byte(w>>8), byte(w), 3,
+		1, 0x22, 0, 2, 0x11, 1, 3, 0x11, 1)
+	var dht []byte
+	for i, s := range huffSpecs {
+		// class (DC 0, AC 1) and table id (luma 0, chroma 1)
+		dht = append(dht, byte(i%2<<4|i/2))
+		dht = append(dht, s.counts[:]...)
+		dht = append(dht, s.values...)
+	}
+	writeMarker(buf, 0xc4, dht...)
+
+	bw := &bitWriter{buf: buf}
+
+	// DC of every block, interleaved by MCU
+	writeMarker(buf, 0xda, 3, 1, 0x00, 2, 0x11, 3, 0x11, 0, 0, 0)
+	var pred [3]int32
+	dc := func(ci, bx, by int) {
+		c := comps[ci]
+		v := int32(c.blocks[by*c.bw+bx][0])
+		d := v - pred[ci]
+		pred[ci] = v
+		n := category(d)
+		bw.huff(&huffTables[min(ci, 1)*2], byte(n))
+		bw.value(d, n)
+	}
+	for y := range my {
+		for x := range mx {
+			dc(0, 2*x, 2*y)
+			dc(0, 2*x+1, 2*y)
+			dc(0, 2*x, 2*y+1)
+			dc(0, 2*x+1, 2*y+1)
+			dc(1, x, y)
+			dc(2, x, y)
+		}
+	}
+	bw.flush()
+
+	// AC bands, one component per scan: coarse luma detail first
+	for _, s := range []struct{ ci, ss, se int }{{0, 1, 5}, {1, 1, 63}, {2, 1, 63}, {0, 6, 63}} {
+		c := comps[s.ci]
+		t := &huffTables[min(s.ci, 1)*2+1]
+		writeMarker(buf, 0xda, 1, byte(s.ci+1), byte(min(s.ci, 1)), byte(s.ss), byte(s.se), 0)
+		for by := range c.sh {
+			for bx := range c.sw {
+				blk := &c.blocks[by*c.bw+bx]
+				run := 0
+				for k := s.ss; k <= s.se; k++ {
+					v := int32(blk[k])
+					if v == 0 {
+						run++
+						continue
+					}
+					for ; run > 15; run -= 16 {
+						bw.huff(t, 0xf0)
+					}
+					n := category(v)
+					bw.huff(t, byte(run<<4)|byte(n))
+					bw.value(v, n)
+					run = 0
+				}
+				if run > 0 {
+					bw.huff(t, 0x00) // EOB
+				}
+			}
+		}
+		bw.flush()
+	}
+	buf.Write([]byte{0xff, 0xd9})
+}
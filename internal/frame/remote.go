@@ -0,0 +1,110 @@
+package frame
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+// hrefRE picks links out of an HTML directory index (Apache, nginx autoindex,
+// python -m http.server and the like).
+var hrefRE = regexp.MustCompile(`(?i)href\s*=\s*["']([^"'#?]+)["']`)
+
+func isRemote(src string) bool {
+	return strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://")
+}
+
+// loadRemote fetches src and decodes the images it lists. src is either a JSON
+// manifest, an array of image URLs or {"images": [...]} in display order, or
+// an HTML directory index whose image links are ordered like local files.
+// Relative URLs are resolved against src.
+func loadRemote(src string) ([]image.Image, error) {
+	base, err := url.Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	body, ctype, err := fetch(src)
+	if err != nil {
+		return nil, err
+	}
+
+	var refs []string
+	trimmed := bytes.TrimSpace(body)
+	mu.RLock()
+	mode := order
+	mu.RUnlock()
+	if strings.Contains(ctype, "json") || bytes.HasPrefix(trimmed, []byte("[")) || bytes.HasPrefix(trimmed, []byte("{")) {
+		if refs, err = parseManifest(trimmed); err != nil {
+			return nil, fmt.Errorf("manifest %s: %w", src, err)
+		}
+		if mode == OrderShuffle {
+			sortPaths(refs, mode)
+		}
+	} else {
+		for _, m := range hrefRE.FindAllSubmatch(body, -1) {
+			ref := string(m[1])
+			if isImageExt(path.Ext(ref)) {
+				refs = append(refs, ref)
+			}
+		}
+		sortPaths(refs, mode)
+	}
+
+	if len(refs) == 0 {
+		return nil, fmt.Errorf("%w: %s lists no supported images", errNoImages, src)
+	}
+	var urls []string
+	for _, ref := range refs {
+		u, err := base.Parse(ref)
+		if err != nil {
+			continue
+		}
+		urls = append(urls, u.String())
+	}
+	imgs, errs := decodeAll(urls, func(u string) (io.ReadCloser, error) {
+		b, _, err := fetch(u)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(bytes.NewReader(b)), nil
+	})
+	return imgs, loadSummary(src, len(urls), imgs, errs)
+}
+
+func parseManifest(b []byte) ([]string, error) {
+	var list []string
+	if err := json.Unmarshal(b, &list); err == nil {
+		return list, nil
+	}
+	var obj struct {
+		Images []string `json:"images"`
+	}
+	if err := json.Unmarshal(b, &obj); err != nil {
+		return nil, err
+	}
+	return obj.Images, nil
+}
+
+// fetch GETs u and returns the body and its Content-Type.
+func fetch(u string) ([]byte, string, error) {
+	resp, err := httpClient.Get(u)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+	b, err := io.ReadAll(resp.Body)
+	return b, resp.Header.Get("Content-Type"), err
+}
@@ -0,0 +1,55 @@
+package frame
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+// Slide scaling modes accepted by SetScaleMode.
+const (
+	ScaleFit     = "fit"
+	ScaleFill    = "fill"
+	ScaleStretch = "stretch"
+)
+
+var scaleMode = ScaleFit
+
+// SetScaleMode selects how slides are sized to the frame: "fit" (the whole
+// image, letterboxed on the background colour; the default), "fill" (cover
+// the frame edge to edge, cropping the overflow around the centre) or
+// "stretch" (fill the frame ignoring the aspect ratio). Like the background,
+// it applies when slides are loaded.
+func SetScaleMode(mode string) error {
+	switch mode {
+	case ScaleFit, ScaleFill, ScaleStretch:
+	default:
+		return fmt.Errorf("unknown scale mode %q", mode)
+	}
+	mu.Lock()
+	scaleMode = mode
+	mu.Unlock()
+	return nil
+}
+
+// placement returns where a w x h image goes on an fw x fh frame: x, y, nw,
+// nh in frame pixels. For "fill" the rectangle overhangs the frame and the
+// caller clips it.
+func placement(mode string, w, h, fw, fh float64) (x, y, nw, nh float64) {
+	if mode == ScaleStretch {
+		return 0, 0, fw, fh
+	}
+	scale := math.Min(fw/w, fh/h)
+	if mode == ScaleFill {
+		scale = math.Max(fw/w, fh/h)
+	}
+	nw, nh = w*scale, h*scale
+	return (fw - nw) / 2, (fh - nh) / 2, nw, nh
+}
+
+// placementRect is placement rounded to whole pixels for raster images.
+func placementRect(mode string, src image.Rectangle, fw, fh int) image.Rectangle {
+	x, y, nw, nh := placement(mode, float64(src.Dx()), float64(src.Dy()), float64(fw), float64(fh))
+	x0, y0 := int(math.Floor(x)), int(math.Floor(y))
+	return image.Rect(x0, y0, x0+int(nw), y0+int(nh))
+}
@@ -0,0 +1,104 @@
+package frame
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"time"
+
+	draw2 "golang.org/x/image/draw"
+	"golang.org/x/image/font/basicfont"
+)
+
+// StationIDStyle is how the station ID tag is drawn; see SetStationIDStyle.
+type StationIDStyle struct {
+	// Corner is top-left, top-right, bottom-left or bottom-right.
+	Corner string
+	// Opacity is from just above 0 (invisible) to 1 (solid).
+	Opacity float64
+	// Scale multiplies the 7x13 bitmap font, 1 to 8.
+	Scale int
+	// Timestamp appends the frame's time, to the second, to the tag.
+	Timestamp bool
+}
+
+var (
+	stationID    string
+	stationStyle = StationIDStyle{Corner: "bottom-right", Opacity: 0.1, Scale: 1}
+)
+
+// SetStationID burns text, such as a display or site name, into every frame
+// as a small, faint tag (see SetStationIDStyle), so a photo of a screen can
+// be traced to where it was taken. It is drawn over everything else,
+// including the SetOverlay callback. Empty text removes it.
+func SetStationID(text string) {
+	mu.Lock()
+	defer mu.Unlock()
+	stationID = text
+}
+
+// SetStationIDStyle sets where and how faintly the station ID is drawn. The
+// default is the bottom-right corner at 10% opacity and the font's own size:
+// hard to notice, yet readable from a photo once its contrast is raised.
+func SetStationIDStyle(s StationIDStyle) error {
+	switch s.Corner {
+	case "top-left", "top-right", "bottom-left", "bottom-right":
+	default:
+		return fmt.Errorf("unknown corner %q: want top-left, top-right, bottom-left or bottom-right", s.Corner)
+	}
+	if s.Opacity <= 0 || s.Opacity > 1 {
+		return fmt.Errorf("opacity %v out of range (0-1]", s.Opacity)
+	}
+	if s.Scale < 1 || s.Scale > 8 {
+		return fmt.Errorf("scale %d out of range (1-8)", s.Scale)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	stationStyle = s
+	return nil
+}
+
+// stationIDOverlay returns the overlay drawing the station ID, or nil if
+// there is none. mu must be held.
+func stationIDOverlay() func(*image.RGBA, time.Time) {
+	if stationID == "" {
+		return nil
+	}
+	text, style := stationID, stationStyle
+	return func(dst *image.RGBA, now time.Time) {
+		tag := text
+		if style.Timestamp {
+			tag += " " + now.Format("2006-01-02 15:04:05")
+		}
+		mask := stationIDMask(tag, style.Scale, style.Opacity)
+		// a shadow one step down and right keeps it legible on light and
+		// dark slides alike
+		step := style.Scale
+		mw, mh := mask.Rect.Dx()+step, mask.Rect.Dy()+step
+		b, margin := dst.Bounds(), 8*style.Scale
+		at := image.Pt(b.Min.X+margin, b.Min.Y+margin)
+		if style.Corner == "top-right" || style.Corner == "bottom-right" {
+			at.X = b.Max.X - margin - mw
+		}
+		if style.Corner == "bottom-left" || style.Corner == "bottom-right" {
+			at.Y = b.Max.Y - margin - mh
+		}
+		r := image.Rectangle{Min: at, Max: at.Add(mask.Rect.Size())}
+		draw.DrawMask(dst, r.Add(image.Pt(step, step)), image.Black, image.Point{}, mask, image.Point{}, draw.Over)
+		draw.DrawMask(dst, r, image.White, image.Point{}, mask, image.Point{}, draw.Over)
+	}
+}
+
+// stationIDMask renders text with the bitmap font at scale times its size,
+// as a mask with the given opacity.
+func stationIDMask(text string, scale int, opacity float64) *image.Alpha {
+	face := basicfont.Face7x13
+	small := image.NewRGBA(image.Rect(0, 0, len([]rune(text))*face.Advance, face.Height))
+	addLabel(small, 0, face.Ascent, text)
+	mask := image.NewAlpha(image.Rect(0, 0, small.Rect.Dx()*scale, small.Rect.Dy()*scale))
+	draw2.NearestNeighbor.Scale(mask, mask.Rect, small, small.Rect, draw2.Src, nil)
+	for i, v := range mask.Pix {
+		mask.Pix[i] = uint8(float64(v) * opacity)
+	}
+	return mask
+}
@@ -0,0 +1,34 @@
+package frame
+
+import (
+	"errors"
+	"image"
+	"image/draw"
+	"io"
+
+	"github.com/srwiley/oksvg"
+	"github.com/srwiley/rasterx"
+)
+
+// renderSVG rasterizes an SVG at the configured geometry, placed per the
+// scale mode like raster slides.
+func renderSVG(r io.Reader) (*image.RGBA, error) {
+	icon, err := oksvg.ReadIconStream(r, oksvg.IgnoreErrorMode)
+	if err != nil {
+		return nil, err
+	}
+	w, h := icon.ViewBox.W, icon.ViewBox.H
+	if w <= 0 || h <= 0 {
+		return nil, errors.New("svg has no usable viewBox or size")
+	}
+	mu.RLock()
+	fw, fh, bg, mode := frameW, frameH, background, scaleMode
+	mu.RUnlock()
+	dst := image.NewRGBA(image.Rect(0, 0, fw, fh))
+	draw.Draw(dst, dst.Bounds(), &image.Uniform{C: bg}, image.Point{}, draw.Src)
+
+	icon.SetTarget(placement(mode, w, h, float64(fw), float64(fh)))
+	scanner := rasterx.NewScannerGV(fw, fh, dst, dst.Bounds())
+	icon.Draw(rasterx.NewDasher(fw, fh, scanner), 1)
+	return dst, nil
+}
@@ -0,0 +1,156 @@
+package frame
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	draw2 "golang.org/x/image/draw"
+	"golang.org/x/image/font/basicfont"
+)
+
+// The "sysmon" pattern shows live host metrics, re-read on every frame.
+// Values come from /proc and /sys, so on anything but Linux most lines read
+// "n/a".
+
+var (
+	sysmonMu sync.Mutex
+	prevCPU  cpuTimes // last /proc/stat sample, for CPU% over the frame interval
+)
+
+type cpuTimes struct{ idle, total uint64 }
+
+// parseCPU reads the aggregate "cpu" line of /proc/stat.
+func parseCPU(stat string) (cpuTimes, error) {
+	line, _, _ := strings.Cut(stat, "\n")
+	f := strings.Fields(line)
+	if len(f) < 5 || f[0] != "cpu" {
+		return cpuTimes{}, fmt.Errorf("unexpected /proc/stat line %q", line)
+	}
+	var t cpuTimes
+	for i, s := range f[1:] {
+		v, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return cpuTimes{}, err
+		}
+		t.total += v
+		if i == 3 || i == 4 { // idle, iowait
+			t.idle += v
+		}
+	}
+	return t, nil
+}
+
+// parseMeminfo returns total and available memory in bytes.
+func parseMeminfo(s string) (total, avail uint64, err error) {
+	for _, line := range strings.Split(s, "\n") {
+		f := strings.Fields(line)
+		if len(f) < 2 {
+			continue
+		}
+		v, err := strconv.ParseUint(f[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch f[0] {
+		case "MemTotal:":
+			total = v * 1024
+		case "MemAvailable:":
+			avail = v * 1024
+		}
+	}
+	if total == 0 {
+		return 0, 0, fmt.Errorf("no MemTotal in meminfo")
+	}
+	return total, avail, nil
+}
+
+// maxThermal returns the hottest thermal zone in degrees Celsius.
+func maxThermal() (float64, bool) {
+	zones, _ := filepath.Glob("/sys/class/thermal/thermal_zone*/temp")
+	best, ok := 0.0, false
+	for _, z := range zones {
+		b, err := os.ReadFile(z)
+		if err != nil {
+			continue
+		}
+		v, err := strconv.Atoi(strings.TrimSpace(string(b)))
+		if err != nil {
+			continue
+		}
+		if c := float64(v) / 1000; !ok || c > best {
+			best, ok = c, true
+		}
+	}
+	return best, ok
+}
+
+// sysmonLines samples the host and formats one line per metric.
+func sysmonLines() []string {
+	na := "n/a"
+	host, _ := os.Hostname()
+	lines := []string{host}
+
+	cpu := na
+	if b, err := os.ReadFile("/proc/stat"); err == nil {
+		if t, err := parseCPU(string(b)); err == nil {
+			sysmonMu.Lock()
+			prev := prevCPU
+			prevCPU = t
+			sysmonMu.Unlock()
+			if dt := t.total - prev.total; prev.total != 0 && dt > 0 {
+				cpu = fmt.Sprintf("%5.1f%%", 100*(1-float64(t.idle-prev.idle)/float64(dt)))
+			}
+		}
+	}
+	lines = append(lines, "CPU   "+cpu)
+
+	mem := na
+	if b, err := os.ReadFile("/proc/meminfo"); err == nil {
+		if total, avail, err := parseMeminfo(string(b)); err == nil {
+			used := total - avail
+			const gib = 1 << 30
+			mem = fmt.Sprintf("%.1f / %.1f GiB (%.0f%%)", float64(used)/gib, float64(total)/gib, 100*float64(used)/float64(total))
+		}
+	}
+	lines = append(lines, "MEM   "+mem)
+
+	load := na
+	if b, err := os.ReadFile("/proc/loadavg"); err == nil {
+		if f := strings.Fields(string(b)); len(f) >= 3 {
+			load = strings.Join(f[:3], " ")
+		}
+	}
+	lines = append(lines, "LOAD  "+load)
+
+	temp := na
+	if c, ok := maxThermal(); ok {
+		temp = fmt.Sprintf("%.1f C", c)
+	}
+	lines = append(lines, "TEMP  "+temp)
+
+	return append(lines, "", clock().Format("2006-01-02 15:04:05"))
+}
+
+// drawSysmon renders the current host metrics over the whole of dst. The
+// text is drawn with the built-in bitmap font on a small canvas and scaled
+// up, so it stays legible at any geometry.
+func drawSysmon(dst *image.RGBA) {
+	lines := sysmonLines()
+	face := basicfont.Face7x13
+	lineH := face.Height + 4
+	w, h := dst.Bounds().Dx(), dst.Bounds().Dy()
+	// fit the longest expected line (about 32 glyphs) and all lines
+	scale := max(1, min(w/(34*face.Width), h/((len(lines)+2)*lineH)))
+	small := image.NewRGBA(image.Rect(0, 0, w/scale, h/scale))
+	fill(small, small.Bounds(), color.RGBA{0, 0, 0, 255})
+	for i, l := range lines {
+		addLabel(small, face.Width, (i+2)*lineH, l)
+	}
+	draw2.NearestNeighbor.Scale(dst, dst.Bounds(), small, small.Bounds(), draw2.Src, nil)
+}
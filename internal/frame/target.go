@@ -0,0 +1,138 @@
+package frame
+
+import (
+	"errors"
+	"image"
+)
+
+var (
+	targetBytes  int                     // 0 encodes slides at the fixed quality
+	slideQuality = map[image.Image]int{} // quality chosen per slide for targetBytes
+	maxBytes     int                     // 0 for no cap on frame size
+	capQuality   int                     // quality that last brought a frame within maxBytes
+)
+
+// ErrFrameTooLarge is returned for a frame that exceeds the SetMaxBytes cap
+// even at quality 1.
+var ErrFrameTooLarge = errors.New("frame: encoded frame exceeds the size cap even at quality 1")
+
+// SetTargetBytes makes slides encode at the highest quality that keeps them
+// within about n bytes instead of at the SetQuality quality, so photos and
+// plain text slides cost similar bandwidth. The quality is found once per
+// slide, the first time it is shown, with a binary search over a few trial
+// encodings. Fades use the lower quality of the two slides; test patterns
+// keep the fixed quality. 0 turns it off.
+func SetTargetBytes(n int) {
+	mu.Lock()
+	defer mu.Unlock()
+	targetBytes = max(n, 0)
+	clear(slideQuality)
+}
+
+// targetQuality returns the quality to encode the slide img at, probing it
+// the first time; slide identifies it in the cache (it may be a lazy slide
+// while img is decoded). Without a target it returns q. mu must not be held.
+func targetQuality(slide, img image.Image, q int) int {
+	mu.RLock()
+	target := targetBytes
+	chosen, ok := slideQuality[slide]
+	mu.RUnlock()
+	if target == 0 {
+		return q
+	}
+	if ok {
+		return chosen
+	}
+	lo, hi := 1, 100
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		b, err := encode(img, mid)
+		if err != nil {
+			return q
+		}
+		if len(b) <= target {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	mu.Lock()
+	if targetBytes == target {
+		slideQuality[slide] = lo
+	}
+	mu.Unlock()
+	return lo
+}
+
+// fadeQuality returns the quality for a fade from slide a to b: the lower
+// of their target qualities, or q if either is unknown. mu must be held.
+func fadeQuality(a, b image.Image, q int) int {
+	qa, okA := slideQuality[a]
+	qb, okB := slideQuality[b]
+	if targetBytes == 0 || !okA || !okB {
+		return q
+	}
+	return min(qa, qb)
+}
+
+// SetMaxBytes caps the size of encoded frames, as a safety valve against
+// content that encodes far larger than expected. A frame over n bytes is
+// re-encoded at the highest lower quality that fits (see FrameInfo.Capped),
+// and one that doesn't fit even at quality 1 fails with ErrFrameTooLarge.
+// Unlike SetTargetBytes it leaves frames within the cap alone. 0 turns it
+// off.
+func SetMaxBytes(n int) {
+	mu.Lock()
+	defer mu.Unlock()
+	maxBytes = max(n, 0)
+	capQuality = 0
+}
+
+// capSize returns img re-encoded to fit maxBytes and the quality used, or b
+// and q unchanged if b, img encoded at q, already fits. The quality that
+// fitted the previous oversized frame is tried first to narrow the search,
+// as a burst of them is usually the same content; higher ones are still
+// tried, so quality recovers once the content shrinks. mu must not be held.
+func capSize(img image.Image, b []byte, q int) ([]byte, int, error) {
+	mu.RLock()
+	limit, hint := maxBytes, capQuality
+	mu.RUnlock()
+	if limit == 0 || len(b) <= limit {
+		return b, q, nil
+	}
+	var best []byte
+	bestQ := 0
+	lo, hi := 1, q-1
+	if hint > 0 && hint < q {
+		c, err := encode(img, hint)
+		if err != nil {
+			return nil, q, err
+		}
+		if len(c) <= limit {
+			best, bestQ, lo = c, hint, hint+1
+		} else {
+			hi = hint - 1
+		}
+	}
+	for lo <= hi {
+		mid := (lo + hi + 1) / 2
+		c, err := encode(img, mid)
+		if err != nil {
+			return nil, q, err
+		}
+		if len(c) <= limit {
+			best, bestQ, lo = c, mid, mid+1
+		} else {
+			hi = mid - 1
+		}
+	}
+	if best == nil {
+		return nil, q, ErrFrameTooLarge
+	}
+	mu.Lock()
+	if maxBytes == limit {
+		capQuality = bestQ
+	}
+	mu.Unlock()
+	return best, bestQ, nil
+}
@@ -0,0 +1,87 @@
+package frame
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"time"
+
+	draw2 "golang.org/x/image/draw"
+	"golang.org/x/image/font/basicfont"
+)
+
+var (
+	tickerText  string
+	tickerSpeed float64   // pixels per second, leftwards
+	tickerStart time.Time // when the text was set; the scroll offset counts from here
+	// the text rendered at tickerScale, with the gap before its next copy
+	tickerStrip *image.RGBA
+	tickerScale int
+)
+
+// tickerBand is the colour of the strip behind the ticker text.
+var tickerBand = color.RGBA{0, 0, 0, 192}
+
+// SetTicker shows text as a news ticker: a band along the bottom of every
+// frame with the text scrolling right to left at speed pixels per second,
+// repeating seamlessly. It is drawn over slides and patterns and under the
+// timestamp. Setting new text restarts the scroll; empty text removes the
+// ticker.
+func SetTicker(text string, speed float64) {
+	mu.Lock()
+	defer mu.Unlock()
+	if text != tickerText {
+		tickerStrip = nil
+		tickerStart = clock()
+	}
+	tickerText, tickerSpeed = text, speed
+}
+
+// Ticker returns the ticker text and speed set with SetTicker.
+func Ticker() (text string, speed float64) {
+	mu.RLock()
+	defer mu.RUnlock()
+	return tickerText, tickerSpeed
+}
+
+// tickerOverlay returns the overlay drawing the ticker on frames h pixels
+// high, or nil if there is none. mu must be held for writing.
+func tickerOverlay(h int) func(*image.RGBA, time.Time) {
+	if tickerText == "" {
+		return nil
+	}
+	// the bitmap font is scaled up by whole steps to stay legible
+	scale := max(1, h/360)
+	if tickerStrip == nil || tickerScale != scale {
+		tickerStrip, tickerScale = renderTickerStrip(tickerText, scale), scale
+	}
+	strip, start, speed := tickerStrip, tickerStart, tickerSpeed
+	return func(dst *image.RGBA, now time.Time) {
+		b := dst.Bounds()
+		sw, sh := strip.Bounds().Dx(), strip.Bounds().Dy()
+		band := image.Rect(b.Min.X, b.Max.Y-sh, b.Max.X, b.Max.Y)
+		draw.Draw(dst, band, image.NewUniform(tickerBand), image.Point{}, draw.Over)
+		off := int(math.Mod(now.Sub(start).Seconds()*speed, float64(sw)))
+		if off < 0 {
+			off += sw
+		}
+		for x := band.Min.X - off; x < band.Max.X; x += sw {
+			draw.Draw(dst, image.Rect(x, band.Min.Y, x+sw, band.Max.Y), strip, image.Point{}, draw.Over)
+		}
+	}
+}
+
+// renderTickerStrip draws text once on a transparent strip, scale times the
+// bitmap font's size, followed by a gap of a few glyphs before it repeats.
+func renderTickerStrip(text string, scale int) *image.RGBA {
+	face := basicfont.Face7x13
+	const gap = 4 // glyphs between repeats
+	w := (len([]rune(text)) + gap) * face.Advance
+	h := face.Height + 6
+	small := image.NewRGBA(image.Rect(0, 0, w, h))
+	addLabel(small, face.Advance*gap/2, face.Ascent+3, text)
+	strip := image.NewRGBA(image.Rect(0, 0, w*scale, h*scale))
+	draw2.NearestNeighbor.Scale(strip, strip.Bounds(), small, small.Bounds(), draw2.Src, nil)
+	return strip
+}
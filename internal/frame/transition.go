@@ -0,0 +1,266 @@
+package frame
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sync"
+	"time"
+)
+
+// Slide transitions accepted by SetTransition. They all run over the last
+// fadeDuration of each slide (see SetFade).
+const (
+	TransitionFade      = "fade"
+	TransitionWipeLeft  = "wipe-left"
+	TransitionWipeRight = "wipe-right"
+	TransitionSlide     = "slide"
+	TransitionDissolve  = "dissolve"
+)
+
+var transition = TransitionFade
+
+// SetTransition selects how one slide turns into the next during the fade
+// window: "fade" (crossfade, the default), "wipe-left" / "wipe-right" (a hard
+// edge sweeping across), "slide" (the next slide pushes the current one out
+// to the left) or "dissolve" (pixels switch over in random order).
+func SetTransition(kind string) error {
+	switch kind {
+	case TransitionFade, TransitionWipeLeft, TransitionWipeRight, TransitionSlide, TransitionDissolve:
+	default:
+		return fmt.Errorf("unknown transition %q", kind)
+	}
+	mu.Lock()
+	transition = kind
+	mu.Unlock()
+	return nil
+}
+
+// minFrameDelay caps how fast NextFrameIn asks for frames during a fade.
+const minFrameDelay = time.Second / 60
+
+// fadeSteps is the number of distinct intermediate frames per fade; 0 lets
+// progress follow the clock continuously.
+var fadeSteps = 0
+
+// SetFadeSteps makes every fade show exactly n distinct intermediate frames,
+// evenly spaced across the fade window. Progress is quantized to those steps,
+// so frames generated within one step are byte-identical and a caller that
+// skips unchanged frames sends each step once. Combine it with NextFrameIn so
+// frames are generated often enough to hit every step. 0 disables stepping.
+func SetFadeSteps(n int) {
+	if n < 0 {
+		n = 0
+	}
+	mu.Lock()
+	fadeSteps = n
+	mu.Unlock()
+}
+
+// fadeProgress returns the transition progress in [0,1] for a slide shown
+// for elapsed, which must be inside the fade window; mu must be held.
+func fadeProgress(elapsed time.Duration) float64 {
+	into := elapsed - (interval - fadeDuration)
+	if fadeSteps > 0 {
+		// step 0 is the outgoing slide itself and the slide change shows the
+		// incoming one, leaving fadeSteps distinct frames in between
+		k := int(into * time.Duration(fadeSteps+1) / fadeDuration)
+		return min(max(float64(k)/float64(fadeSteps+1), 0), 1)
+	}
+	return min(max(float64(into)/float64(fadeDuration), 0), 1)
+}
+
+// NextFrameIn returns how long a caller generating a frame every base should
+// wait before the next GenerateFrame instead, so that fades are not limited
+// by the caller's cadence: it wakes up at the start of a fade, at every fade
+// step (see SetFadeSteps) and at the slide change. Outside of fades, with
+// no slideshow running, a single slide or while paused, it returns base.
+func NextFrameIn(base time.Duration) time.Duration {
+	mu.RLock()
+	defer mu.RUnlock()
+	if len(slides) == 0 || fadeDuration <= 0 || holding() {
+		return base
+	}
+	elapsed := clock().Sub(lastAdvance)
+	start := interval - fadeDuration
+	var d time.Duration
+	switch {
+	case elapsed >= interval:
+		return minFrameDelay
+	case elapsed < start:
+		d = start - elapsed
+	case fadeSteps > 0:
+		step := fadeDuration / time.Duration(fadeSteps+1)
+		d = step - (elapsed-start)%step
+	default:
+		d = base
+	}
+	d = min(d, interval-elapsed, base)
+	return max(d, minFrameDelay)
+}
+
+// transitionRow returns a function that composites row y of dst from the
+// outgoing slide a and the incoming slide b at progress t in [0,1]. With gamma
+// set the crossfade blends in linear light (see SetGammaCorrectBlend). All three
+// images must share the same bounds. Rows are independent so the caller can
+// spread them across workers.
+func transitionRow(kind string, gamma bool, t float64, a, b, dst *image.RGBA) func(y int) {
+	w := dst.Bounds().Dx()
+	stride := dst.Stride
+	rows := func(y int) (ar, br, dr []byte) {
+		s := y * stride
+		return a.Pix[s : s+w*4], b.Pix[s : s+w*4], dst.Pix[s : s+w*4]
+	}
+	switch kind {
+	case TransitionWipeLeft:
+		// the incoming slide is revealed from the right edge
+		edge := int((1 - t) * float64(w))
+		return func(y int) {
+			ar, br, dr := rows(y)
+			copy(dr[:edge*4], ar[:edge*4])
+			copy(dr[edge*4:], br[edge*4:])
+		}
+	case TransitionWipeRight:
+		edge := int(t * float64(w))
+		return func(y int) {
+			ar, br, dr := rows(y)
+			copy(dr[:edge*4], br[:edge*4])
+			copy(dr[edge*4:], ar[edge*4:])
+		}
+	case TransitionSlide:
+		off := int(t * float64(w))
+		return func(y int) {
+			ar, br, dr := rows(y)
+			copy(dr[:(w-off)*4], ar[off*4:])
+			copy(dr[(w-off)*4:], br[:off*4])
+		}
+	case TransitionDissolve:
+		threshold := uint32(t * float64(^uint32(0)))
+		return func(y int) {
+			ar, br, dr := rows(y)
+			for x := 0; x < w; x++ {
+				src := ar
+				if t >= 1 || pixelHash(x, y) < threshold {
+					src = br
+				}
+				copy(dr[x*4:x*4+4], src[x*4:x*4+4])
+			}
+		}
+	}
+	// crossfade
+	if gamma {
+		lin := newLinearBlend(t)
+		return func(y int) {
+			ar, br, dr := rows(y)
+			lin.row(dr, ar, br)
+		}
+	}
+	lut := newBlendLUT(t)
+	return func(y int) {
+		ar, br, dr := rows(y)
+		lut.row(dr, ar, br)
+	}
+}
+
+// blendLUT holds the fixed-point weights of a crossfade step: blending
+// bytes x and y is (wa[x]+wb[y])>>8, so the inner loop is two table lookups
+// and an add instead of float conversions per channel.
+type blendLUT struct{ wa, wb [256]uint16 }
+
+// newBlendLUT builds the tables for t, scaled to 0..256 so that t=1 yields
+// the incoming image exactly.
+func newBlendLUT(t float64) *blendLUT {
+	wb := uint16(t*256 + 0.5)
+	wa := 256 - wb
+	l := &blendLUT{}
+	for i := range 256 {
+		l.wa[i] = uint16(i) * wa
+		l.wb[i] = uint16(i) * wb
+	}
+	return l
+}
+
+func (l *blendLUT) row(dst, a, b []byte) {
+	a, b = a[:len(dst)], b[:len(dst)]
+	for i := range dst {
+		dst[i] = uint8((l.wa[a[i]] + l.wb[b[i]]) >> 8)
+	}
+}
+
+// pixelHash gives each pixel a fixed pseudo-random value, so a dissolve
+// switches every pixel exactly once and never flickers back.
+func pixelHash(x, y int) uint32 {
+	h := uint32(x)*0x9e3779b1 ^ uint32(y)*0x85ebca77
+	h ^= h >> 15
+	h *= 0x2c1b3c6d
+	h ^= h >> 12
+	h *= 0x297a2d39
+	h ^= h >> 15
+	return h
+}
+
+var gammaCorrect = false
+
+// SetGammaCorrectBlend makes crossfades blend in linear light instead of on
+// the sRGB-encoded bytes. Blending encoded values darkens the midtones, so
+// a fade between two bright images dips visibly; the linear blend avoids
+// that at some extra cost. Off by default.
+func SetGammaCorrectBlend(enabled bool) {
+	mu.Lock()
+	gammaCorrect = enabled
+	mu.Unlock()
+}
+
+// sRGB <-> linear light tables, linear values scaled to 0..65535, built on
+// first use.
+var (
+	srgbOnce  sync.Once
+	srgbToLin [256]uint16
+	linToSRGB [1 << 16]uint8
+)
+
+func buildSRGBTables() {
+	for i := range srgbToLin {
+		c := float64(i) / 255
+		if c <= 0.04045 {
+			c /= 12.92
+		} else {
+			c = math.Pow((c+0.055)/1.055, 2.4)
+		}
+		srgbToLin[i] = uint16(math.Round(c * 65535))
+	}
+	for i := range linToSRGB {
+		l := float64(i) / 65535
+		if l <= 0.0031308 {
+			l *= 12.92
+		} else {
+			l = 1.055*math.Pow(l, 1/2.4) - 0.055
+		}
+		linToSRGB[i] = uint8(math.Round(l * 255))
+	}
+}
+
+// linearBlend is the gamma-correct counterpart of blendLUT: colour channels
+// are decoded to linear light, mixed with 8-bit weights and re-encoded;
+// alpha is mixed as is.
+type linearBlend struct {
+	wa, wb uint32
+	alpha  *blendLUT
+}
+
+func newLinearBlend(t float64) *linearBlend {
+	srgbOnce.Do(buildSRGBTables)
+	wb := uint32(t*256 + 0.5)
+	return &linearBlend{wa: 256 - wb, wb: wb, alpha: newBlendLUT(t)}
+}
+
+func (l *linearBlend) row(dst, a, b []byte) {
+	a, b = a[:len(dst)], b[:len(dst)]
+	for i := 0; i+3 < len(dst); i += 4 {
+		for c := i; c < i+3; c++ {
+			v := (uint32(srgbToLin[a[c]])*l.wa + uint32(srgbToLin[b[c]])*l.wb) >> 8
+			dst[c] = linToSRGB[v]
+		}
+		dst[i+3] = uint8((l.alpha.wa[a[i+3]] + l.alpha.wb[b[i+3]]) >> 8)
+	}
+}
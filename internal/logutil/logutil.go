@@ -0,0 +1,21 @@
+package logutil
+
+import (
+	"log/slog"
+	"os"
+
+	"mjpeg-multicast/internal/mcast"
+)
+
+// Setup installs a text logger at level (debug, info, warn or error) on
+// stderr as the default for this process and for the mcast package.
+func Setup(level string) error {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return err
+	}
+	l := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: lvl}))
+	slog.SetDefault(l)
+	mcast.SetLogger(l)
+	return nil
+}
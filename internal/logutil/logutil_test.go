@@ -0,0 +1,20 @@
+package logutil
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+)
+
+func TestSetup(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+	if err := Setup("warn"); err != nil {
+		t.Fatal(err)
+	}
+	if h := slog.Default().Handler(); h.Enabled(context.Background(), slog.LevelInfo) || !h.Enabled(context.Background(), slog.LevelWarn) {
+		t.Error("warn logger enabled info or not warn")
+	}
+	if err := Setup("loud"); err == nil {
+		t.Error("unknown level accepted")
+	}
+}
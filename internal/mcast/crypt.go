@@ -0,0 +1,79 @@
+package mcast
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// FlagEncrypted marks a frame encrypted with AES-GCM under a pre-shared key
+// (SenderOptions.Key). The payload, after any deflating, is sealed as a
+// whole before fragmenting:
+// 12 bytes random nonce
+// followed by the ciphertext with its 16-byte tag. The frame's flags, this
+// one included, are the additional data, so they can't be altered either.
+const FlagEncrypted = 0x08
+
+var errDecrypt = errors.New("mcast: frame failed to decrypt")
+
+// ParseKey parses a pre-shared key for SenderOptions.Key and
+// ReceiverOptions.Key: 32, 48 or 64 hex digits for AES-128, -192 or -256,
+// or @path to read them from a file, which keeps the key out of process
+// listings.
+func ParseKey(s string) ([]byte, error) {
+	if path, ok := strings.CutPrefix(s, "@"); ok {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		s = string(b)
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("key: %w", err)
+	}
+	if n := len(key); n != 16 && n != 24 && n != 32 {
+		return nil, fmt.Errorf("key is %d bytes, want 16, 24 or 32 (32, 48 or 64 hex digits)", n)
+	}
+	return key, nil
+}
+
+// newAEAD returns AES-GCM with key, or nil for an empty key.
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) == 0 {
+		return nil, nil
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts b for sending with flags. A random nonce is used rather
+// than one derived from the frame ID: IDs start at a random value on every
+// start and would eventually repeat under the same key.
+func seal(aead cipher.AEAD, b []byte, flags uint8) ([]byte, uint8) {
+	flags |= FlagEncrypted
+	out := make([]byte, aead.NonceSize(), aead.NonceSize()+len(b)+aead.Overhead())
+	_, _ = rand.Read(out)
+	return aead.Seal(out, out, b, []byte{flags}), flags
+}
+
+// unseal decrypts a frame sealed with flags.
+func unseal(aead cipher.AEAD, b []byte, flags uint8) ([]byte, error) {
+	if len(b) < aead.NonceSize() {
+		return nil, errDecrypt
+	}
+	nonce, ct := b[:aead.NonceSize()], b[aead.NonceSize():]
+	out, err := aead.Open(nil, nonce, ct, []byte{flags})
+	if err != nil {
+		return nil, errDecrypt
+	}
+	return out, nil
+}
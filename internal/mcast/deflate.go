@@ -0,0 +1,58 @@
+package mcast
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"io"
+	"sync"
+)
+
+// FlagDeflate marks a frame whose payload is deflate-compressed (RFC 1951).
+// It is set in FragmentHeader.Flags of every fragment of the frame.
+const FlagDeflate = 0x01
+
+// maxInflated bounds the size of an inflated frame, so a corrupt or hostile
+// frame can't make the receiver allocate without limit.
+const maxInflated = 64 << 20
+
+var errInflatedTooLarge = errors.New("mcast: inflated frame too large")
+
+var deflaters = sync.Pool{New: func() any {
+	w, _ := flate.NewWriter(nil, flate.BestSpeed)
+	return w
+}}
+
+// deflate compresses b, or returns nil when that doesn't make it at least
+// 1/16 smaller, as for JPEGs of photos, so they go out as they are.
+func deflate(b []byte) []byte {
+	var buf bytes.Buffer
+	buf.Grow(len(b) / 2)
+	w := deflaters.Get().(*flate.Writer)
+	defer deflaters.Put(w)
+	w.Reset(&buf)
+	if _, err := w.Write(b); err != nil {
+		return nil
+	}
+	if err := w.Close(); err != nil {
+		return nil
+	}
+	if buf.Len() > len(b)-len(b)/16 {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// inflate decompresses a frame sent with FlagDeflate.
+func inflate(b []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(b))
+	defer r.Close()
+	out, err := io.ReadAll(io.LimitReader(r, maxInflated+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > maxInflated {
+		return nil, errInflatedTooLarge
+	}
+	return out, nil
+}
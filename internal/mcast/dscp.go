@@ -0,0 +1,32 @@
+package mcast
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// dscpNames maps the common per-hop behaviour names to DSCP values.
+var dscpNames = map[string]int{
+	"be": 0, "df": 0,
+	"cs1": 8, "cs2": 16, "cs3": 24, "cs4": 32, "cs5": 40, "cs6": 48, "cs7": 56,
+	"af11": 10, "af12": 12, "af13": 14,
+	"af21": 18, "af22": 20, "af23": 22,
+	"af31": 26, "af32": 28, "af33": 30,
+	"af41": 34, "af42": 36, "af43": 38,
+	"ef": 46,
+}
+
+// ParseDSCP parses a DSCP value, 0-63, or a per-hop behaviour name such as
+// AF41, EF or CS5 (case-insensitive).
+func ParseDSCP(s string) (int, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if v, ok := dscpNames[s]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < 0 || v > 63 {
+		return 0, fmt.Errorf("bad DSCP %q: want 0-63 or a name like af41, ef or cs5", s)
+	}
+	return v, nil
+}
@@ -0,0 +1,83 @@
+package mcast
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Fragment header layout (big-endian):
+// 1 byte version (2)
+// 4 bytes frameID
+// 2 bytes totalFragments
+// 2 bytes fragmentIndex
+// 1 byte flags (FlagDeflate, FlagRegion, FlagDims, FlagEncrypted)
+// 4 bytes frame length as sent (compressed, with FlagDeflate), 0 if unknown
+//
+// Version 1 headers end after fragmentIndex. Receivers accept both.
+const (
+	// FragmentHeaderSize is the number of bytes the header adds to every
+	// fragment, on top of the IP and UDP headers.
+	FragmentHeaderSize = 1 + 4 + 2 + 2 + 1 + 4
+	// FragmentVersion is the version byte written by this package.
+	FragmentVersion = 2
+
+	fragmentHeaderSizeV1 = 1 + 4 + 2 + 2
+)
+
+// ErrShortHeader is returned by FragmentHeader.Unmarshal for datagrams
+// shorter than the header their version byte announces.
+var ErrShortHeader = errors.New("mcast: datagram shorter than fragment header")
+
+// FragmentHeader is the header that precedes each fragment of a frame.
+type FragmentHeader struct {
+	Version uint8
+	FrameID uint32
+	Total   uint16 // fragments in the frame
+	Index   uint16 // position of this fragment, 0 to Total-1
+	Flags   uint8  // FlagDeflate, FlagRegion, FlagDims, FlagEncrypted; version 2 only
+	Length  uint32 // bytes in the whole frame as sent, version 2 only; 0 if unknown
+}
+
+// Size returns the encoded size of the header: FragmentHeaderSize, or less
+// for version 1.
+func (h FragmentHeader) Size() int {
+	if h.Version == 1 {
+		return fragmentHeaderSizeV1
+	}
+	return FragmentHeaderSize
+}
+
+// Marshal writes the header into the first h.Size() bytes of b, which must
+// be long enough.
+func (h FragmentHeader) Marshal(b []byte) {
+	_ = b[h.Size()-1]
+	b[0] = h.Version
+	binary.BigEndian.PutUint32(b[1:5], h.FrameID)
+	binary.BigEndian.PutUint16(b[5:7], h.Total)
+	binary.BigEndian.PutUint16(b[7:9], h.Index)
+	if h.Version != 1 {
+		b[9] = h.Flags
+		binary.BigEndian.PutUint32(b[10:14], h.Length)
+	}
+}
+
+// Unmarshal parses the header at the start of b. Any version other than 1
+// is read with the version 2 layout; it does not check the version or that
+// Index is below Total.
+func (h *FragmentHeader) Unmarshal(b []byte) error {
+	if len(b) < 1 {
+		return ErrShortHeader
+	}
+	*h = FragmentHeader{Version: b[0]}
+	if len(b) < h.Size() {
+		return ErrShortHeader
+	}
+	h.FrameID = binary.BigEndian.Uint32(b[1:5])
+	h.Total = binary.BigEndian.Uint16(b[5:7])
+	h.Index = binary.BigEndian.Uint16(b[7:9])
+	if h.Version != 1 {
+		h.Flags = b[9]
+		h.Length = binary.BigEndian.Uint32(b[10:14])
+	}
+	return nil
+}
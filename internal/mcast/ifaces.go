@@ -0,0 +1,85 @@
+package mcast
+
+import (
+	"fmt"
+	"net"
+
+	"golang.org/x/net/ipv4"
+)
+
+// InterfaceInfo describes a network interface as NewReceiver sees it.
+type InterfaceInfo struct {
+	Name      string
+	MTU       int
+	Flags     net.Flags
+	Addrs     []string // in CIDR notation
+	Up        bool
+	Multicast bool
+	Loopback  bool
+	// Default is set on the interface NewReceiver joins on when no
+	// Interface is given: the first one that is up, multicast-capable and
+	// not loopback.
+	Default bool
+}
+
+// autoJoinable reports whether NewReceiver may join the group on ifi when
+// no interface was requested.
+func autoJoinable(ifi *net.Interface) bool {
+	return ifi.Flags&net.FlagUp != 0 && ifi.Flags&net.FlagMulticast != 0 && ifi.Flags&net.FlagLoopback == 0
+}
+
+// Interfaces lists the host's interfaces with the details that matter for
+// picking an -if value.
+func Interfaces() ([]InterfaceInfo, error) {
+	ifs, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]InterfaceInfo, 0, len(ifs))
+	picked := false
+	for i := range ifs {
+		ifi := &ifs[i]
+		info := InterfaceInfo{
+			Name:      ifi.Name,
+			MTU:       ifi.MTU,
+			Flags:     ifi.Flags,
+			Up:        ifi.Flags&net.FlagUp != 0,
+			Multicast: ifi.Flags&net.FlagMulticast != 0,
+			Loopback:  ifi.Flags&net.FlagLoopback != 0,
+		}
+		if addrs, err := ifi.Addrs(); err == nil {
+			for _, a := range addrs {
+				info.Addrs = append(info.Addrs, a.String())
+			}
+		}
+		if !picked && autoJoinable(ifi) {
+			info.Default, picked = true, true
+		}
+		out = append(out, info)
+	}
+	return out, nil
+}
+
+// TryJoin joins group on the named interface with a throwaway socket and
+// leaves it again, returning the error NewReceiver would hit.
+func TryJoin(group, ifname string) error {
+	ip := net.ParseIP(group)
+	if ip == nil || ip.To4() == nil || !ip.IsMulticast() {
+		return fmt.Errorf("bad group: %s", group)
+	}
+	ifi, err := lookupInterface(ifname)
+	if err != nil {
+		return err
+	}
+	c, err := net.ListenPacket("udp4", "0.0.0.0:0")
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	p := ipv4.NewPacketConn(c)
+	g := &net.UDPAddr{IP: ip}
+	if err := p.JoinGroup(ifi, g); err != nil {
+		return err
+	}
+	return p.LeaveGroup(ifi, g)
+}
@@ -0,0 +1,86 @@
+package mcast
+
+import (
+	"errors"
+	"net"
+	"slices"
+	"strings"
+	"time"
+)
+
+// interfaceCheckInterval is how often a Receiver checks that the interfaces
+// it joined the group on are still up.
+const interfaceCheckInterval = 2 * time.Second
+
+// interfaceState returns why the named interface can't carry the stream, or
+// nil if it is up with a link.
+func interfaceState(name string) error {
+	ifi, err := net.InterfaceByName(name)
+	if err != nil {
+		return err
+	}
+	if ifi.Flags&net.FlagUp == 0 {
+		return errors.New("interface is down")
+	}
+	if ifi.Flags&net.FlagRunning == 0 {
+		return errors.New("no link (cable unplugged or radio disconnected?)")
+	}
+	return nil
+}
+
+// watchInterfaces checks the joined interfaces every r.ifCheck until the receiver stops. Nothing arrives through an interface that is
+// down, and reads simply block, so it logs each one going down and, as its
+// group membership may not have survived, joins the group again once it is
+// back.
+func (r *Receiver) watchInterfaces() {
+	names := strings.Split(r.iface, ",")
+	since := make(map[string]time.Time) // when each down interface went down
+	t := time.NewTicker(r.ifCheck)
+	defer t.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-t.C:
+		}
+		for _, name := range names {
+			err := r.ifState(name)
+			downAt, wasDown := since[name]
+			switch {
+			case err != nil && !wasDown:
+				since[name] = time.Now()
+				r.logger.Warn("multicast interface went down; no frames will arrive through it until it is back", "iface", name, "reason", err, "last_frame_age", r.LastFrameAge().Round(time.Millisecond))
+			case err == nil && wasDown:
+				delete(since, name)
+				if err := r.rejoin(name); err != nil {
+					select {
+					case <-r.stop:
+						return // closed mid-rejoin; the failure is expected
+					default:
+					}
+					r.logger.Warn("multicast interface is back up but rejoining the group failed", "iface", name, "err", err)
+					continue
+				}
+				r.logger.Info("multicast interface is back up; rejoined the group", "iface", name, "down_for", time.Since(downAt).Round(time.Second))
+			}
+		}
+		down := make([]string, 0, len(since))
+		for _, name := range names {
+			if _, ok := since[name]; ok {
+				down = append(down, name)
+			}
+		}
+		r.mu.Lock()
+		r.down = down
+		r.mu.Unlock()
+	}
+}
+
+// DownInterfaces returns the interfaces the group was joined on that are
+// currently down or without a link, as last checked. While all of them are,
+// no frames can arrive; the receiver rejoins each one when it is back.
+func (r *Receiver) DownInterfaces() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.down)
+}
@@ -0,0 +1,51 @@
+package mcast
+
+import "time"
+
+// heldFrame is a completed frame waiting in the jitter buffer.
+type heldFrame struct {
+	f  received
+	at time.Time // when it completed
+}
+
+// jitterLoop releases frames from the jitter buffer to Next on a steady
+// cadence: each one a frame period after the previous, but never before it
+// completed nor more than the buffer delay after. The period is a running
+// average of the gaps between completed frames, ignoring bursts and pauses.
+// It owns closing r.out, once readLoop has closed r.held.
+func (r *Receiver) jitterLoop() {
+	defer close(r.out)
+	var period time.Duration
+	var lastAt, lastRelease time.Time
+	timer := time.NewTimer(0)
+	<-timer.C
+	for f := range r.held {
+		if !lastAt.IsZero() {
+			if gap := f.at.Sub(lastAt); gap < time.Second && gap >= period/4 {
+				if period == 0 {
+					period = gap
+				} else {
+					period += (gap - period) / 16
+				}
+			}
+		}
+		lastAt = f.at
+
+		release := lastRelease.Add(period)
+		if release.Before(f.at) {
+			release = f.at
+		} else if latest := f.at.Add(r.jitter); release.After(latest) {
+			release = latest
+		}
+		lastRelease = release
+		if wait := time.Until(release); wait > 0 {
+			timer.Reset(wait)
+			select {
+			case <-timer.C:
+			case <-r.stop:
+				return
+			}
+		}
+		r.queue(f.f)
+	}
+}
@@ -0,0 +1,27 @@
+package mcast
+
+import (
+	"math/rand/v2"
+	"sync"
+)
+
+// lossInjector drops a fraction of incoming datagrams using a seeded RNG, so
+// tests can simulate packet loss deterministically and check how reassembly,
+// timeouts and NACKs recover. It is only reachable through the unexported
+// ReceiverOptions fields, i.e. from this package's tests.
+type lossInjector struct {
+	mu   sync.Mutex
+	rng  *rand.Rand
+	rate float64 // fraction of datagrams to drop, 0-1
+}
+
+func newLossInjector(rate float64, seed uint64) *lossInjector {
+	return &lossInjector{rng: rand.New(rand.NewPCG(seed, seed)), rate: rate}
+}
+
+// drop reports whether the next datagram should be discarded.
+func (l *lossInjector) drop() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rng.Float64() < l.rate
+}
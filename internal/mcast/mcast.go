@@ -1,46 +1,203 @@
 package mcast
 
 import (
+	"cmp"
 	"context"
-	"encoding/binary"
+	"crypto/cipher"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"math"
+	"math/rand/v2"
 	"net"
-	"runtime"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"golang.org/x/net/ipv4"
 )
 
-// Fragment header layout (big-endian):
-// 1 byte version (1)
-// 4 bytes frameID
-// 2 bytes totalFragments
-// 2 bytes fragmentIndex
+// Frames are split into fragments that each carry a FragmentHeader.
+//
+// frameID is a per-sender counter that wraps around after 2^32 frames. It
+// starts at a random value so a restarted sender is unlikely to reuse IDs a
+// receiver still has partial frames for; receivers treat IDs with serial
+// number arithmetic and reset their reassembly state when the ID jumps by
+// more than frameIDWindow in either direction (i.e. a new sender).
 const (
-	fragHeaderSize = 1 + 4 + 2 + 2
-	fragVersion    = 1
+	maxFragments  = 1<<16 - 1 // totalFragments is a uint16
+	frameIDWindow = 1 << 12
+
+	// DefaultMTU is the fragment size used when the interface MTU is unknown;
+	// it fits comfortably in any Ethernet or VPN path.
+	DefaultMTU = 1200
+	// minMTU is the smallest size MTU discovery backs off to: 576, the
+	// minimum IPv4 datagram every host must accept, less IP and UDP headers.
+	minMTU        = 576 - ipUDPOverhead
+	maxUDPPayload = 65507
+	ipUDPOverhead = 20 + 8
 )
 
+var pkgLogger atomic.Pointer[slog.Logger]
+
+// SetLogger sets the logger used by Senders and Receivers created afterwards.
+// By default they log through slog.Default().
+func SetLogger(l *slog.Logger) { pkgLogger.Store(l) }
+
+func defaultLogger() *slog.Logger {
+	if l := pkgLogger.Load(); l != nil {
+		return l
+	}
+	return slog.Default()
+}
+
 type Sender struct {
 	conn    *net.UDPConn
 	pc      *ipv4.PacketConn
+	logger  *slog.Logger
 	mu      sync.Mutex
 	frameID uint32
+	keyID   uint32 // frameID of the last frame fully sent without FlagRegion
+	haveKey bool
+	width   int // announced with FlagDims when non-zero, see SetDimensions
+	height  int
+
+	ucast   *net.UDPConn // unconnected socket for unicast targets
+	laddr   *net.UDPAddr // source address for ucast; nil for the default
+	targets []*net.UDPAddr
+
+	interleave bool
+	tos        int // IP ToS byte: the DSCP shifted left 2, 0 for the default
+	noPacing   bool
+	aead       cipher.AEAD  // nil without Key
+	budget     *tokenBucket // nil without MaxBytesPerSec
+	dropOver   bool
+	compress   bool
+	mtu        int  // fragment size for SendFrame(b, 0, ...), guarded by mu
+	discover   bool // DF is set and EMSGSIZE lowers mtu
+
+	// NACK retransmission, when SenderOptions.NACKListen is set
+	nackConn    *net.UDPConn
+	sent        []*sentFrame // ring of recent frames, guarded by mu
+	sentNext    int
+	retransmits atomic.Uint64
+}
+
+// SenderOptions configures a Sender created with NewSenderWithOptions. The
+// zero value sends on the system default interface with TTL 1.
+type SenderOptions struct {
+	// Interface is the network interface to send multicast on; empty uses the
+	// system default. A named interface must be up and multicast-capable, and
+	// its IPv4 address is used as the source of everything the Sender sends.
+	Interface string
+	// TTL is the multicast TTL, up to 255; 0 means 1 (local LAN).
+	TTL int
+	// DSCP marks every datagram, multicast and unicast, with this
+	// Differentiated Services code point (0-63, see ParseDSCP) so QoS-enabled
+	// switches and routers can prioritize the stream, e.g. 34 (AF41) for
+	// video. 0 leaves the default best-effort marking.
+	DSCP int
+	// InterleaveFragments sends fragments in a strided order instead of
+	// 0..n-1, so a burst of consecutive lost packets hits fragments spread
+	// across the image rather than one contiguous chunk. Receivers reassemble
+	// by index and need no changes.
+	InterleaveFragments bool
+	// DiscoverMTU sets the don't-fragment bit (on Linux) so fragments that
+	// don't fit the path fail instead of being IP-fragmented, and makes
+	// SendFrame with mtu 0 back off from the interface MTU until they fit.
+	DiscoverMTU bool
+	// NoPacing sends a frame's fragments back to back instead of 1ms apart.
+	// A large frame then goes out in far less time, at the cost of bursts
+	// that small socket buffers and cheap switches may drop.
+	NoPacing bool
+	// MaxBytesPerSec caps the Sender's egress, IP and UDP headers, repeats
+	// and unicast copies included, with a token bucket that allows bursts of
+	// up to a second's worth. Fragments wait for the budget, slowing the send
+	// down, unless DropOverBudget is set. NACK retransmissions only use
+	// budget left over. 0 means no cap.
+	MaxBytesPerSec int
+	// DropOverBudget makes SendFrame return ErrOverBudget without sending
+	// anything when the whole frame doesn't fit in the MaxBytesPerSec budget
+	// right away, instead of waiting. Frames costing more than a second's
+	// budget are then never sent.
+	DropOverBudget bool
+	// Key, if set, encrypts every frame with AES-GCM under this pre-shared
+	// 16, 24 or 32 byte key (see ParseKey and FlagEncrypted). Receivers need
+	// ReceiverOptions.Key set to the same key; others can't read the frames.
+	Key []byte
+	// Compress deflates each frame before fragmenting it, and sends it as it
+	// is when that saves less than 1/16. Synthetic frames with large flat
+	// areas shrink; JPEGs of photos mostly don't. Receivers inflate frames
+	// flagged FlagDeflate automatically.
+	Compress bool
+	// NoLoopback stops the Sender's datagrams from being looped back to
+	// receivers on the same host. Leave it off when a proxy or viewer runs
+	// on the sending host, or it gets nothing.
+	NoLoopback bool
+	// NACKListen is a UDP address (e.g. ":5001") on which to accept
+	// retransmission requests from receivers with ReceiverOptions.NACKPort
+	// set. The last few frames are kept and requested fragments are resent
+	// to the group. Empty disables NACKs.
+	NACKListen string
+	// Logger overrides the package logger (see SetLogger).
+	Logger *slog.Logger
 }
 
 // NewSender creates a UDP sender to the multicast address. If ifname is empty
 // it uses the system default interface. ttl controls multicast TTL (1 is local LAN).
 func NewSender(addr string, ifname string, ttl int) (*Sender, error) {
+	return NewSenderWithOptions(addr, SenderOptions{Interface: ifname, TTL: ttl})
+}
+
+// NewSenderWithOptions is like NewSender but takes a SenderOptions.
+func NewSenderWithOptions(addr string, opts SenderOptions) (*Sender, error) {
+	ifname, ttl := opts.Interface, opts.TTL
+	if ttl == 0 {
+		ttl = 1
+	}
+	if ttl < 0 || ttl > 255 {
+		return nil, fmt.Errorf("ttl %d out of range (1-255)", ttl)
+	}
+	if opts.DSCP < 0 || opts.DSCP > 63 {
+		return nil, fmt.Errorf("dscp %d out of range (0-63)", opts.DSCP)
+	}
+	if opts.MaxBytesPerSec < 0 {
+		return nil, fmt.Errorf("max bytes per second %d is negative", opts.MaxBytesPerSec)
+	}
+	aead, err := newAEAD(opts.Key)
+	if err != nil {
+		return nil, fmt.Errorf("key: %w", err)
+	}
+	logger := opts.Logger
+	if logger == nil {
+		logger = defaultLogger()
+	}
+
 	udpAddr, err := net.ResolveUDPAddr("udp4", addr)
 	if err != nil {
 		return nil, err
 	}
 
-	conn, err := net.DialUDP("udp", nil, udpAddr)
+	// an explicit interface also pins the source address, so the stream
+	// (and unicast copies) leave from that NIC's address on multi-homed hosts
+	var ifi *net.Interface
+	var laddr *net.UDPAddr
+	if ifname != "" {
+		ifi, err = lookupInterface(ifname)
+		if err != nil {
+			return nil, err
+		}
+		ip := interfaceIPv4(ifi)
+		if ip == nil {
+			return nil, fmt.Errorf("iface %s has no IPv4 address", ifname)
+		}
+		laddr = &net.UDPAddr{IP: ip}
+	}
+
+	conn, err := net.DialUDP("udp4", laddr, udpAddr)
 	if err != nil {
 		return nil, err
 	}
@@ -48,69 +205,280 @@ func NewSender(addr string, ifname string, ttl int) (*Sender, error) {
 	pc := ipv4.NewPacketConn(conn)
 	if err := pc.SetMulticastTTL(ttl); err != nil {
 		// best-effort; continue
+		logger.Warn("failed to set multicast TTL", "ttl", ttl, "err", err)
 	}
-	// allow local loopback so sender on same host can be received by receiver
-	_ = pc.SetMulticastLoopback(true)
-	if ifname != "" {
-		ifi, err := net.InterfaceByName(ifname)
+	// by default allow local loopback so a receiver on the same host gets
+	// the stream too
+	if err := pc.SetMulticastLoopback(!opts.NoLoopback); err != nil {
+		logger.Warn("failed to set multicast loopback", "loopback", !opts.NoLoopback, "err", err)
+	}
+	tos := opts.DSCP << 2
+	if tos != 0 {
+		if err := pc.SetTOS(tos); err != nil {
+			// best-effort like the TTL: the stream still flows, unmarked
+			logger.Warn("failed to set DSCP", "dscp", opts.DSCP, "err", err)
+		}
+	}
+	if ifi != nil {
+		if err := pc.SetMulticastInterface(ifi); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("multicast iface %s: %w", ifname, err)
+		}
+	} else {
+		ifi = interfaceForAddr(conn.LocalAddr())
+	}
+	mtu := DefaultMTU
+	if ifi != nil && ifi.MTU > 0 {
+		mtu = min(max(ifi.MTU-ipUDPOverhead, minMTU), maxUDPPayload)
+	}
+	if opts.DiscoverMTU {
+		if err := setDontFragment(conn); err != nil {
+			logger.Debug("cannot set don't-fragment; relying on the interface MTU", "err", err)
+		}
+	}
+
+	s := &Sender{conn: conn, pc: pc, laddr: laddr, logger: logger, frameID: rand.Uint32(), interleave: opts.InterleaveFragments, noPacing: opts.NoPacing, compress: opts.Compress, mtu: mtu, discover: opts.DiscoverMTU, tos: tos, dropOver: opts.DropOverBudget, aead: aead}
+	if opts.MaxBytesPerSec > 0 {
+		s.budget = newTokenBucket(opts.MaxBytesPerSec)
+	}
+	if opts.NACKListen != "" {
+		la, err := net.ResolveUDPAddr("udp4", opts.NACKListen)
 		if err == nil {
-			_ = pc.SetMulticastInterface(ifi)
+			s.nackConn, err = net.ListenUDP("udp4", la)
 		}
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("nack listen %s: %w", opts.NACKListen, err)
+		}
+		s.sent = make([]*sentFrame, nackCacheFrames)
+		go s.nackLoop()
 	}
+	return s, nil
+}
 
-	return &Sender{conn: conn, pc: pc}, nil
+// lookupInterface returns the named interface, or an error if it doesn't
+// exist or can't carry multicast.
+func lookupInterface(name string) (*net.Interface, error) {
+	ifi, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	if ifi.Flags&net.FlagUp == 0 {
+		return nil, fmt.Errorf("iface %s is down", name)
+	}
+	if ifi.Flags&net.FlagMulticast == 0 {
+		return nil, fmt.Errorf("iface %s does not support multicast", name)
+	}
+	return ifi, nil
+}
+
+// interfaceIPv4 returns the first IPv4 address of ifi, or nil.
+func interfaceIPv4(ifi *net.Interface) net.IP {
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil
+	}
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok && n.IP.To4() != nil {
+			return n.IP.To4()
+		}
+	}
+	return nil
+}
+
+// interfaceForAddr finds the interface that owns the local address a, i.e.
+// the one the system routes the group through, or nil.
+func interfaceForAddr(a net.Addr) *net.Interface {
+	ua, ok := a.(*net.UDPAddr)
+	if !ok {
+		return nil
+	}
+	ifs, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+	for i := range ifs {
+		addrs, err := ifs[i].Addrs()
+		if err != nil {
+			continue
+		}
+		for _, ad := range addrs {
+			if n, ok := ad.(*net.IPNet); ok && n.IP.Equal(ua.IP) {
+				return &ifs[i]
+			}
+		}
+	}
+	return nil
+}
+
+// MTU returns the fragment size (UDP payload, header included) SendFrame
+// uses when called with mtu 0: the outgoing interface MTU less IP and UDP
+// headers, lowered further if DiscoverMTU hit oversized fragments, or
+// DefaultMTU if the interface is unknown.
+func (s *Sender) MTU() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.mtu
 }
 
 // SendFrame fragments the frame into MTU-sized packets (accounting for header)
 // and sends each fragment. repeats controls how many times each fragment is sent
-// (simple redundancy). mtu should be <= 65507.
+// (simple redundancy). mtu should be <= 65507; 0 picks it automatically (see
+// MTU and SenderOptions.DiscoverMTU).
 func (s *Sender) SendFrame(b []byte, mtu int, repeats int) error {
-	if mtu <= fragHeaderSize+16 {
-		mtu = 1200
+	return s.send(context.Background(), b, 0, mtu, repeats)
+}
+
+// SendFrameContext is like SendFrame but gives up between fragments once ctx
+// is done, returning ctx.Err(). Receivers discard the partial frame.
+func (s *Sender) SendFrameContext(ctx context.Context, b []byte, mtu int, repeats int) error {
+	return s.send(ctx, b, 0, mtu, repeats)
+}
+
+// send is SendFrameContext for a payload with the given flags, compressing
+// it first if enabled.
+func (s *Sender) send(ctx context.Context, b []byte, flags uint8, mtu int, repeats int) error {
+	b, flags = s.withDims(b, flags)
+	if s.compress {
+		if c := deflate(b); c != nil {
+			b, flags = c, flags|FlagDeflate
+		}
+	}
+	if s.aead != nil {
+		// last: ciphertext doesn't compress
+		b, flags = seal(s.aead, b, flags)
+	}
+	if mtu != 0 {
+		return s.sendFrame(ctx, b, flags, mtu, repeats)
 	}
-	if mtu > 65507 {
-		mtu = 65507
+	for {
+		mtu := s.MTU()
+		err := s.sendFrame(ctx, b, flags, mtu, repeats)
+		if !s.discover || !errors.Is(err, syscall.EMSGSIZE) || mtu <= minMTU {
+			return err
+		}
+		// only the first fragment can be too big, so nothing of this frame
+		// went out: retry it smaller
+		next := max(mtu*7/8, minMTU)
+		s.logger.Warn("fragments too large for the path; lowering mtu", "from", mtu, "to", next)
+		s.mu.Lock()
+		s.mtu = next
+		s.mu.Unlock()
 	}
+}
 
-	payloadPer := mtu - fragHeaderSize
+func (s *Sender) sendFrame(ctx context.Context, b []byte, flags uint8, mtu int, repeats int) error {
+	if mtu <= FragmentHeaderSize+16 {
+		mtu = DefaultMTU
+	}
+	if mtu > maxUDPPayload {
+		mtu = maxUDPPayload
+	}
+
+	payloadPer := mtu - FragmentHeaderSize
 	if payloadPer <= 0 {
 		payloadPer = 1200
 	}
 
+	total := (len(b) + payloadPer - 1) / payloadPer
+	if total > maxFragments {
+		// the 16-bit total/index fields would wrap and corrupt the stream
+		suggest := (len(b)+maxFragments-1)/maxFragments + FragmentHeaderSize
+		s.logger.Warn("frame needs too many fragments", "bytes", len(b), "mtu", mtu, "fragments", total, "max", maxFragments, "min_mtu", suggest)
+		return fmt.Errorf("frame of %d bytes needs %d fragments at mtu %d (max %d); use mtu >= %d", len(b), total, mtu, maxFragments, suggest)
+	}
+
 	s.mu.Lock()
+	targets := s.targets
+	// with DropOverBudget the frame goes out whole or not at all, and a
+	// dropped one doesn't use up a frame ID
+	if s.budget != nil && s.dropOver {
+		wire := (total*FragmentHeaderSize + len(b) + total*ipUDPOverhead) * repeats * (1 + len(targets))
+		if !s.budget.tryTake(wire) {
+			s.mu.Unlock()
+			return ErrOverBudget
+		}
+	}
 	s.frameID++
 	frameID := s.frameID
 	s.mu.Unlock()
+	failed := make(map[*net.UDPAddr]bool)
 
-	total := (len(b) + payloadPer - 1) / payloadPer
-
-	for i := 0; i < total; i++ {
+	var order []int
+	if s.interleave {
+		order = interleaveOrder(total)
+	}
+	var frags [][]byte // kept for NACKs
+	if s.nackConn != nil {
+		frags = make([][]byte, total)
+	}
+	pace := s.budget != nil && !s.dropOver
+	for n := 0; n < total; n++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		i := n
+		if order != nil {
+			i = order[n]
+		}
 		start := i * payloadPer
 		end := start + payloadPer
 		if end > len(b) {
 			end = len(b)
 		}
-		frag := make([]byte, fragHeaderSize+(end-start))
-		frag[0] = fragVersion
-		binary.BigEndian.PutUint32(frag[1:5], frameID)
-		binary.BigEndian.PutUint16(frag[5:7], uint16(total))
-		binary.BigEndian.PutUint16(frag[7:9], uint16(i))
-		copy(frag[fragHeaderSize:], b[start:end])
+		frag := make([]byte, FragmentHeaderSize+(end-start))
+		FragmentHeader{Version: FragmentVersion, FrameID: frameID, Total: uint16(total), Index: uint16(i), Flags: flags, Length: uint32(len(b))}.Marshal(frag)
+		copy(frag[FragmentHeaderSize:], b[start:end])
+		if frags != nil {
+			frags[i] = frag
+		}
 
 		for r := 0; r < repeats; r++ {
+			if pace {
+				if err := s.budget.take(ctx, wireSize(len(frag))); err != nil {
+					return err
+				}
+			}
 			if _, err := s.conn.Write(frag); err != nil {
 				return err
 			}
-			// tiny spacing to avoid bursts
-			time.Sleep(1 * time.Millisecond)
+			for _, t := range targets {
+				if failed[t] {
+					continue
+				}
+				if pace {
+					if err := s.budget.take(ctx, wireSize(len(frag))); err != nil {
+						return err
+					}
+				}
+				// a broken relay must not stall the multicast stream
+				if _, err := s.ucast.WriteToUDP(frag, t); err != nil {
+					failed[t] = true
+					s.logger.Warn("unicast send failed", "target", t, "err", err)
+				}
+			}
+			// tiny spacing to avoid bursts; a single fragment is no burst
+			if !s.noPacing && total > 1 {
+				time.Sleep(1 * time.Millisecond)
+			}
 		}
 	}
+	if frags != nil {
+		s.cacheFrame(frameID, frags)
+	}
+	// only a keyframe that went out can be the base for region updates
+	if flags&FlagRegion == 0 {
+		s.mu.Lock()
+		s.keyID, s.haveKey = frameID, true
+		s.mu.Unlock()
+	}
 	return nil
 }
 
 // Backwards-compatible Send: if frame fits in one UDP packet, send with 4-byte length prefix.
+// With a Key it always uses SendFrame, as the legacy format can't be encrypted.
 func (s *Sender) Send(b []byte) error {
-	if len(b)+4 <= 65507 {
+	if s.aead == nil && len(b)+4 <= 65507 {
 		p := make([]byte, 4+len(b))
 		p[0] = byte(len(b) >> 24)
 		p[1] = byte(len(b) >> 16)
@@ -121,10 +489,61 @@ func (s *Sender) Send(b []byte) error {
 		return err
 	}
 	// fallback: use SendFrame with defaults
-	return s.SendFrame(b, 1200, 1)
+	return s.SendFrame(b, DefaultMTU, 1)
+}
+
+// interleaveOrder returns a permutation of 0..total-1 that walks the indices
+// with a stride of about sqrt(total): 0, k, 2k, ..., 1, k+1, ... so adjacent
+// sends are far apart in the frame.
+func interleaveOrder(total int) []int {
+	stride := int(math.Ceil(math.Sqrt(float64(total))))
+	if stride < 2 {
+		stride = 2
+	}
+	order := make([]int, 0, total)
+	for off := 0; off < stride; off++ {
+		for i := off; i < total; i += stride {
+			order = append(order, i)
+		}
+	}
+	return order
+}
+
+// AddUnicastTarget makes the sender also send every fragment to addr over
+// unicast UDP, for receivers outside the multicast domain (e.g. a remote proxy
+// listening on the same port). Each target costs as much egress bandwidth as
+// the multicast stream itself, repeats included.
+func (s *Sender) AddUnicastTarget(addr string) error {
+	ua, err := net.ResolveUDPAddr("udp4", addr)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ucast == nil {
+		c, err := net.ListenUDP("udp4", s.laddr)
+		if err != nil {
+			return err
+		}
+		if s.tos != 0 {
+			if err := ipv4.NewConn(c).SetTOS(s.tos); err != nil {
+				s.logger.Warn("failed to set DSCP on unicast socket", "dscp", s.tos>>2, "err", err)
+			}
+		}
+		s.ucast = c
+	}
+	// copy on write so SendFrame can iterate a snapshot without the lock
+	s.targets = append(s.targets[:len(s.targets):len(s.targets)], ua)
+	return nil
 }
 
 func (s *Sender) Close() error {
+	if s.nackConn != nil {
+		_ = s.nackConn.Close()
+	}
+	if s.ucast != nil {
+		_ = s.ucast.Close()
+	}
 	if s.pc != nil {
 		_ = s.pc.Close()
 	}
@@ -135,25 +554,163 @@ func (s *Sender) Close() error {
 }
 
 type Receiver struct {
-	conn *net.UDPConn
-	buf  []byte
+	conn       *net.UDPConn
+	iface      string // interface the group was joined on, if any
+	buf        []byte
+	logger     *slog.Logger
+	verbose    bool
+	latestOnly bool
+	inOrder    bool
+	aead       cipher.AEAD // nil without ReceiverOptions.Key
+
+	packets      atomic.Uint64 // datagrams read
+	assembled    atomic.Uint64 // frames delivered or dropped on a full queue
+	dropped      atomic.Uint64 // assembled frames dropped on a full queue
+	incomplete   atomic.Uint64 // partial frames purged before completing
+	invalid      atomic.Uint64 // malformed fragments discarded
+	nacks        atomic.Uint64 // retransmission requests sent
+	outOfOrder   atomic.Uint64 // completed frames older than one already delivered, with InOrder
+	badLength    atomic.Uint64 // complete frames whose size didn't match the header
+	undecodable  atomic.Uint64 // complete compressed frames that failed to inflate
+	regions      atomic.Uint64 // region updates drawn over the keyframe
+	staleRegions atomic.Uint64 // region updates dropped: keyframe missed or bad region
+	rejected     atomic.Uint64 // frames dropped for failing or lacking encryption
+
+	nackPort   int // sender's NACK port, 0 if disabled
+	nackDelay  time.Duration
+	reassembly time.Duration // how long a partial frame may wait for fragments
+	maxPending int           // most partial frames kept, 0 for no limit
+	loss       *lossInjector // tests only
+	key        keyframe      // for FlagRegion updates; readLoop only
 
-	mu     sync.Mutex
-	frames map[uint32]*assemblingFrame
-	out    chan []byte
-	stop   chan struct{}
+	mu         sync.Mutex
+	frames     map[uint32]*assemblingFrame
+	lastID     uint32 // newest frameID seen, in serial number order
+	haveLastID bool
+	// recently completed frames, so late duplicates (repeats, retransmits)
+	// don't start a new partial frame that can never complete; entries are
+	// frameID+1 so the zero value is empty
+	completed     [16]uint64
+	completedNext int
+	lastDelivered uint32 // newest frameID delivered, valid if haveDelivered
+	haveDelivered bool
+	out           chan received
+	latest        []byte                  // most recently completed frame
+	latestAt      time.Time               // when latest completed, zero before the first
+	started       time.Time               // when the receiver was created
+	down          []string                // joined interfaces found down, see watchInterfaces
+	rejoin        func(name string) error // joins the group again on an interface that was down
+	ifCheck       time.Duration           // how often watchInterfaces runs
+	ifState       func(name string) error // interfaceState, replaced in tests
+	stop          chan struct{}
+	wg            sync.WaitGroup // background goroutines, waited for by Close
+
+	// jitter buffer, when ReceiverOptions.JitterBuffer is set
+	jitter    time.Duration
+	held      chan heldFrame
+	closeOnce sync.Once
 }
 
 type assemblingFrame struct {
 	total    uint16
+	length   uint32 // expected frame size from the header, 0 if unknown
+	flags    uint8
 	parts    map[uint16][]byte
 	received int
 	created  time.Time
+	updated  time.Time // last fragment arrival
+	src      net.IP    // sender, for NACKs
+	nacked   bool
+}
+
+// ReceiverOptions configures a Receiver created with NewReceiverWithOptions.
+type ReceiverOptions struct {
+	// Interface is the network interface to join the group on; empty picks
+	// the first multicast-capable interface. A named interface must be up and
+	// multicast-capable.
+	Interface string
+	// Verbose logs every received datagram at debug level. It is very chatty
+	// (one line per fragment) and meant for troubleshooting only.
+	Verbose bool
+	// ReadBuffer is the socket receive buffer (SO_RCVBUF) in bytes; 0 means
+	// DefaultReadBuffer. The kernel may clamp it: on Linux the ceiling is the
+	// net.core.rmem_max sysctl, so raise that for large values to take effect.
+	ReadBuffer int
+	// LatestOnly keeps at most one completed frame queued for Next, replacing
+	// it when a newer one completes, so a slow consumer gets the freshest
+	// image instead of working through a stale backlog.
+	LatestOnly bool
+	// JitterBuffer holds completed frames for up to this long and releases
+	// them to Next at the observed frame rate, so bursty arrivals come out
+	// evenly spaced for timing-sensitive players. 0 (the default) delivers
+	// each frame as soon as it completes.
+	JitterBuffer time.Duration
+	// InOrder drops a completed frame whose ID is older than one already
+	// delivered, which happens when loss or repeats let a newer frame
+	// complete first, so consumers never step back to an older picture.
+	InOrder bool
+	// NACKPort enables retransmission requests: when a frame is missing at
+	// most two fragments and none arrived for NACKDelay, the receiver asks
+	// the sender (at its source IP and this port, see
+	// SenderOptions.NACKListen) to resend them. 0 disables NACKs.
+	NACKPort int
+	// NACKDelay is the quiet period before a NACK; 0 means DefaultNACKDelay.
+	NACKDelay time.Duration
+	// ReassemblyTimeout is how long a partial frame waits for its missing
+	// fragments before it is counted as lost; 0 means
+	// DefaultReassemblyTimeout.
+	ReassemblyTimeout time.Duration
+	// MaxPendingFrames caps how many partial frames are kept at once: a new
+	// frame beyond it evicts the oldest, counted as Incomplete. It bounds
+	// memory under heavy loss or junk traffic on the port; 0 means
+	// DefaultMaxPendingFrames, and a negative value means no limit, leaving
+	// only ReassemblyTimeout to purge them.
+	MaxPendingFrames int
+	// Source, if set, is the sender's IPv4 address: the receiver joins the
+	// group source-specifically (SSM, IGMPv3), as networks that only route
+	// SSM require, and only gets that sender's datagrams.
+	Source string
+	// JoinAllInterfaces joins the group on every interface that is up,
+	// multicast-capable and not loopback, instead of only the first, so
+	// traffic arriving on any of them is received. It can't be combined with
+	// Interface.
+	JoinAllInterfaces bool
+	// NoLoopback asks not to receive the group's datagrams sent from this
+	// host. Most systems (Linux, macOS, BSD) decide loopback on the sending
+	// side, so there SenderOptions.NoLoopback is what takes effect; this
+	// option matters on Windows.
+	NoLoopback bool
+	// Key decrypts frames sent with SenderOptions.Key. With a key set,
+	// frames that aren't encrypted with it are dropped and counted as
+	// Rejected; without one, encrypted frames are.
+	Key []byte
+	// Logger overrides the package logger (see SetLogger).
+	Logger *slog.Logger
+
+	// simulated loss for tests: drop this fraction of datagrams, seeded
+	lossRate float64
+	lossSeed uint64
 }
 
+// DefaultReassemblyTimeout is used when ReceiverOptions.ReassemblyTimeout is 0.
+const DefaultReassemblyTimeout = 5 * time.Second
+
+// DefaultMaxPendingFrames is used when ReceiverOptions.MaxPendingFrames is 0.
+const DefaultMaxPendingFrames = 64
+
+// DefaultReadBuffer is the receive buffer requested when ReceiverOptions.ReadBuffer is 0.
+const DefaultReadBuffer = 4 * 1024 * 1024
+
 // NewReceiver joins the multicast group at addr (e.g. 224.0.0.250:5000). If ifname
 // is non-empty it uses that interface, otherwise it picks the first multicast-capable interface.
 func NewReceiver(addr string, ifname string) (*Receiver, error) {
+	return NewReceiverWithOptions(addr, ReceiverOptions{Interface: ifname})
+}
+
+// NewReceiverWithOptions is like NewReceiver but takes a ReceiverOptions. The
+// zero value joins on the first multicast-capable interface.
+func NewReceiverWithOptions(addr string, opts ReceiverOptions) (*Receiver, error) {
+	ifname := opts.Interface
 	parts := strings.Split(addr, ":")
 	if len(parts) != 2 {
 		return nil, fmt.Errorf("bad addr: %s", addr)
@@ -162,22 +719,39 @@ func NewReceiver(addr string, ifname string) (*Receiver, error) {
 	port := parts[1]
 
 	// resolve group/port (not used directly; we bind to :port)
+	logger := opts.Logger
+	if logger == nil {
+		logger = defaultLogger()
+	}
+	if opts.JoinAllInterfaces && ifname != "" {
+		return nil, errors.New("cannot combine Interface with JoinAllInterfaces")
+	}
+	aead, err := newAEAD(opts.Key)
+	if err != nil {
+		return nil, fmt.Errorf("key: %w", err)
+	}
+	var source net.IP
+	if opts.Source != "" {
+		if source = net.ParseIP(opts.Source).To4(); source == nil {
+			return nil, fmt.Errorf("bad source: %s", opts.Source)
+		}
+	}
 
 	var ifi *net.Interface
 	if ifname != "" {
-		ifi, err := net.InterfaceByName(ifname)
+		var err error
+		ifi, err = lookupInterface(ifname)
 		if err != nil {
 			return nil, err
 		}
-		_ = ifi
 	} else {
 		ifaces, err := net.Interfaces()
 		if err != nil {
 			return nil, err
 		}
-		for _, i := range ifaces {
-			if (i.Flags&net.FlagUp) != 0 && (i.Flags&net.FlagMulticast) != 0 && (i.Flags&net.FlagLoopback) == 0 {
-				ifi = &i
+		for i := range ifaces {
+			if autoJoinable(&ifaces[i]) {
+				ifi = &ifaces[i]
 				break
 			}
 		}
@@ -188,18 +762,7 @@ func NewReceiver(addr string, ifname string) (*Receiver, error) {
 		Control: func(network, address string, c syscall.RawConn) error {
 			var ctrlErr error
 			if err := c.Control(func(fd uintptr) {
-				// set SO_REUSEADDR
-				if e := syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1); e != nil {
-					ctrlErr = e
-					return
-				}
-				// try SO_REUSEPORT on non-Windows platforms
-				if runtime.GOOS != "windows" {
-					if e := syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEPORT, 1); e != nil {
-						// non-fatal; record but continue
-						ctrlErr = e
-					}
-				}
+				ctrlErr = setReuse(fd)
 			}); err != nil {
 				return err
 			}
@@ -216,48 +779,151 @@ func NewReceiver(addr string, ifname string) (*Receiver, error) {
 		pcConn.Close()
 		return nil, fmt.Errorf("unexpected PacketConn type")
 	}
-	_ = c.SetReadBuffer(4 * 1024 * 1024)
+	rcvbuf := opts.ReadBuffer
+	if rcvbuf <= 0 {
+		rcvbuf = DefaultReadBuffer
+	}
+	if err := c.SetReadBuffer(rcvbuf); err != nil {
+		logger.Warn("failed to set read buffer", "requested", rcvbuf, "err", err)
+	}
+	if got, err := readBufferSize(c); err == nil {
+		// Linux reports double the usable size to account for bookkeeping
+		logger.Info("socket read buffer", "requested", rcvbuf, "granted", got)
+		if got < rcvbuf {
+			logger.Warn("read buffer clamped by the kernel; raise net.core.rmem_max (Linux) or kern.ipc.maxsockbuf (macOS/BSD)", "requested", rcvbuf, "granted", got)
+		}
+	}
 
 	// Try to join multicast group on the socket so we receive group datagrams.
 	pconn := ipv4.NewPacketConn(c)
-	// enable loopback to allow receiving multicast sent from this host
-	_ = pconn.SetMulticastLoopback(true)
-	joined := false
+	// enable loopback (unless disabled) to allow receiving multicast sent
+	// from this host
+	_ = pconn.SetMulticastLoopback(!opts.NoLoopback)
+	joined := ""
 	mip := net.ParseIP(group)
-	if ifi != nil {
-		if err := pconn.JoinGroup(ifi, &net.UDPAddr{IP: mip}); err == nil {
-			joined = true
-			log.Printf("joined multicast group %s on iface %s", group, ifi.Name)
+	join := func(ifi *net.Interface) error {
+		if source != nil {
+			return pconn.JoinSourceSpecificGroup(ifi, &net.UDPAddr{IP: mip}, &net.UDPAddr{IP: source})
+		}
+		return pconn.JoinGroup(ifi, &net.UDPAddr{IP: mip})
+	}
+	if opts.JoinAllInterfaces {
+		ifaces, _ := net.Interfaces()
+		var names []string
+		for i := range ifaces {
+			if !autoJoinable(&ifaces[i]) {
+				continue
+			}
+			if err := join(&ifaces[i]); err != nil {
+				logger.Warn("failed to join multicast group", "group", group, "iface", ifaces[i].Name, "err", err)
+				continue
+			}
+			names = append(names, ifaces[i].Name)
+		}
+		joined = strings.Join(names, ",")
+		if joined != "" {
+			logger.Info("joined multicast group", "group", group, "ifaces", joined)
+		}
+	} else if ifi != nil {
+		if err := join(ifi); err == nil {
+			joined = ifi.Name
+			logger.Info("joined multicast group", "group", group, "iface", ifi.Name)
+		} else if ifname != "" {
+			// an explicitly requested interface must work; don't silently fall back
+			c.Close()
+			return nil, fmt.Errorf("join multicast group %s on iface %s: %w", group, ifname, err)
 		} else {
-			log.Printf("warning: failed to join multicast group %s on iface %s: %v", group, ifi.Name, err)
+			logger.Warn("failed to join multicast group", "group", group, "iface", ifi.Name, "err", err)
 		}
 	} else {
 		ifaces, _ := net.Interfaces()
 		for _, ii := range ifaces {
-			if (ii.Flags&net.FlagUp) != 0 && (ii.Flags&net.FlagMulticast) != 0 && (ii.Flags&net.FlagLoopback) == 0 {
-				if err := pconn.JoinGroup(&ii, &net.UDPAddr{IP: mip}); err == nil {
-					joined = true
-					log.Printf("joined multicast group %s on iface %s", group, ii.Name)
+			if autoJoinable(&ii) {
+				if err := join(&ii); err == nil {
+					joined = ii.Name
+					logger.Info("joined multicast group", "group", group, "iface", ii.Name)
 					break
 				} else {
-					log.Printf("warning: failed to join multicast group %s on iface %s: %v", group, ii.Name, err)
+					logger.Warn("failed to join multicast group", "group", group, "iface", ii.Name, "err", err)
 				}
 			}
 		}
 	}
-	if !joined {
-		log.Printf("warning: could not join multicast group %s on any interface; continuing to listen on :%s", group, port)
+	if joined == "" {
+		logger.Warn("could not join multicast group on any interface; continuing to listen", "group", group, "port", port)
+	}
+	rejoin := func(name string) error {
+		ifi, err := net.InterfaceByName(name)
+		if err != nil {
+			return err
+		}
+		// the membership may or may not have outlived the outage
+		if source != nil {
+			_ = pconn.LeaveSourceSpecificGroup(ifi, &net.UDPAddr{IP: mip}, &net.UDPAddr{IP: source})
+		} else {
+			_ = pconn.LeaveGroup(ifi, &net.UDPAddr{IP: mip})
+		}
+		return join(ifi)
 	}
 
-	r := &Receiver{conn: c, buf: make([]byte, 65536), frames: make(map[uint32]*assemblingFrame), out: make(chan []byte, 8), stop: make(chan struct{})}
+	queue := 8
+	if opts.LatestOnly {
+		queue = 1
+	}
+	r := &Receiver{conn: c, iface: joined, logger: logger, verbose: opts.Verbose, latestOnly: opts.LatestOnly, inOrder: opts.InOrder, buf: make([]byte, 65536), frames: make(map[uint32]*assemblingFrame), out: make(chan received, queue), stop: make(chan struct{}), started: time.Now(), aead: aead, rejoin: rejoin, ifCheck: interfaceCheckInterval, ifState: interfaceState}
 
-	go r.readLoop()
-	go r.purgeLoop()
+	r.reassembly = opts.ReassemblyTimeout
+	if r.reassembly <= 0 {
+		r.reassembly = DefaultReassemblyTimeout
+	}
+	switch {
+	case opts.MaxPendingFrames == 0:
+		r.maxPending = DefaultMaxPendingFrames
+	case opts.MaxPendingFrames > 0:
+		r.maxPending = opts.MaxPendingFrames
+	}
+	if opts.lossRate > 0 {
+		r.loss = newLossInjector(opts.lossRate, opts.lossSeed)
+	}
+	if opts.JitterBuffer > 0 {
+		r.jitter = opts.JitterBuffer
+		r.held = make(chan heldFrame, 64)
+		r.background(r.jitterLoop)
+	}
+	r.background(r.readLoop)
+	if joined != "" {
+		r.background(r.watchInterfaces)
+	}
+	r.background(r.purgeLoop)
+	if opts.NACKPort > 0 {
+		r.nackPort, r.nackDelay = opts.NACKPort, opts.NACKDelay
+		if r.nackDelay <= 0 {
+			r.nackDelay = DefaultNACKDelay
+		}
+		r.background(r.nackLoop)
+	}
 
 	return r, nil
 }
 
+// background runs f in a goroutine that Close waits for.
+func (r *Receiver) background(f func()) {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		f()
+	}()
+}
+
+// readLoop is the only sender on r.out (or, with a jitter buffer, on r.held,
+// and jitterLoop on r.out), so it owns closing it once it exits; this
+// guarantees Close never races a send on a closed channel.
 func (r *Receiver) readLoop() {
+	if r.held != nil {
+		defer close(r.held)
+	} else {
+		defer close(r.out)
+	}
 	for {
 		select {
 		case <-r.stop:
@@ -269,78 +935,285 @@ func (r *Receiver) readLoop() {
 			time.Sleep(100 * time.Millisecond)
 			continue
 		}
-		// debug log each received UDP packet
-		log.Printf("recv UDP %d bytes from %v", n, addr)
-		// debug
-		_ = addr
-		if n < fragHeaderSize {
-			// legacy or small packet: treat as whole payload
-			b := make([]byte, n)
-			copy(b, r.buf[:n])
-			select {
-			case r.out <- b:
-			default:
-			}
+		if r.loss != nil && r.loss.drop() {
 			continue
 		}
-		if r.buf[0] != fragVersion {
-			// not our frag format; ignore or treat as legacy
-			b := make([]byte, n)
-			copy(b, r.buf[:n])
-			select {
-			case r.out <- b:
-			default:
-			}
-			continue
+		r.packets.Add(1)
+		if r.verbose {
+			r.logger.Debug("recv UDP", "bytes", n, "from", addr)
 		}
-		frameID := binary.BigEndian.Uint32(r.buf[1:5])
-		total := binary.BigEndian.Uint16(r.buf[5:7])
-		idx := binary.BigEndian.Uint16(r.buf[7:9])
-		payload := make([]byte, n-fragHeaderSize)
-		copy(payload, r.buf[fragHeaderSize:n])
-
-		r.mu.Lock()
-		af, ok := r.frames[frameID]
-		if !ok {
-			af = &assemblingFrame{total: total, parts: make(map[uint16][]byte), created: time.Now()}
-			r.frames[frameID] = af
-		}
-		if _, exists := af.parts[idx]; !exists {
-			af.parts[idx] = payload
-			af.received++
-		}
-		if af.received == int(af.total) {
-			// assemble
-			var full []byte
-			for i := uint16(0); i < af.total; i++ {
-				part := af.parts[i]
-				full = append(full, part...)
-			}
-			delete(r.frames, frameID)
-			r.mu.Unlock()
-			select {
-			case r.out <- full:
-			default:
-			}
-			continue
+		r.handlePacket(r.buf[:n], addr)
+	}
+}
+
+// handlePacket processes one datagram from the given source (which may be
+// nil): fragments are stored for reassembly and complete frames delivered.
+// pkt is not retained.
+func (r *Receiver) handlePacket(pkt []byte, from *net.UDPAddr) {
+	n := len(pkt)
+	var hdr FragmentHeader
+	if err := hdr.Unmarshal(pkt); err != nil || (hdr.Version != 1 && hdr.Version != FragmentVersion) {
+		// legacy or small packet, or not our frag format: treat as whole payload
+		if r.aead != nil {
+			r.reject("unencrypted datagram")
+			return
+		}
+		b := make([]byte, n)
+		copy(b, pkt)
+		r.deliver(b, FrameMeta{})
+		return
+	}
+	frameID, total, idx := hdr.FrameID, hdr.Total, hdr.Index
+	if total == 0 || idx >= total {
+		// a frame can't have zero fragments, and an out-of-range index
+		// would be stored but never read back during assembly
+		r.invalid.Add(1)
+		return
+	}
+
+	r.mu.Lock()
+	if r.haveLastID {
+		if d := int32(frameID - r.lastID); d > frameIDWindow || d < -frameIDWindow {
+			// the sender restarted (or is a different one): partial frames
+			// from before can only collide with the new IDs
+			r.logger.Info("frame id jumped; resetting reassembly", "from", r.lastID, "to", frameID, "pending", len(r.frames))
+			r.incomplete.Add(uint64(len(r.frames)))
+			clear(r.frames)
+			clear(r.completed[:])
+			r.haveDelivered = false
+			r.lastID = frameID
+		} else if d > 0 {
+			r.lastID = frameID
+		}
+	} else {
+		r.lastID, r.haveLastID = frameID, true
+	}
+	af, ok := r.frames[frameID]
+	if !ok && slices.Contains(r.completed[:], uint64(frameID)+1) {
+		// a repeat or retransmit of a frame we already delivered
+		r.mu.Unlock()
+		return
+	}
+	if !ok && total == 1 {
+		// fast path: a single-fragment frame is complete on arrival and
+		// needs no reassembly state
+		full := make([]byte, n-hdr.Size())
+		copy(full, pkt[hdr.Size():])
+		r.complete(frameID, full, hdr.Length, hdr.Flags)
+		return
+	}
+	now := time.Now()
+	if !ok {
+		if r.maxPending > 0 && len(r.frames) >= r.maxPending {
+			r.evictOldest()
+		}
+		af = &assemblingFrame{total: total, length: hdr.Length, flags: hdr.Flags, parts: make(map[uint16][]byte), created: now}
+		if from != nil {
+			af.src = from.IP
 		}
+		r.frames[frameID] = af
+	} else if af.total != total || af.length != hdr.Length || af.flags != hdr.Flags {
+		// fragments of one frame must agree on its size
 		r.mu.Unlock()
+		r.invalid.Add(1)
+		return
 	}
+	if _, exists := af.parts[idx]; !exists {
+		payload := make([]byte, n-hdr.Size())
+		copy(payload, pkt[hdr.Size():])
+		af.parts[idx] = payload
+		af.received++
+		af.updated = now
+	}
+	if af.received == int(af.total) {
+		// assemble
+		var full []byte
+		for i := uint16(0); i < af.total; i++ {
+			part := af.parts[i]
+			full = append(full, part...)
+		}
+		delete(r.frames, frameID)
+		r.complete(frameID, full, af.length, af.flags)
+		return
+	}
+	r.mu.Unlock()
+}
+
+// complete checks and delivers a fully reassembled frame, remembering its ID
+// so repeats of it are ignored. r.mu must be held; it is released.
+func (r *Receiver) complete(frameID uint32, full []byte, length uint32, flags uint8) {
+	r.completed[r.completedNext] = uint64(frameID) + 1
+	r.completedNext = (r.completedNext + 1) % len(r.completed)
+	if length != 0 && len(full) != int(length) {
+		// every index arrived but the bytes don't add up: a fragment
+		// is truncated or belongs to another frame
+		r.mu.Unlock()
+		r.badLength.Add(1)
+		return
+	}
+	if r.inOrder && r.haveDelivered && int32(frameID-r.lastDelivered) < 0 {
+		// a straggler that completed after a newer frame: showing
+		// it now would step the picture backwards
+		r.mu.Unlock()
+		r.outOfOrder.Add(1)
+		return
+	}
+	r.lastDelivered, r.haveDelivered = frameID, true
+	r.mu.Unlock()
+	switch {
+	case flags&FlagEncrypted == 0 && r.aead != nil:
+		r.reject("unencrypted frame")
+		return
+	case flags&FlagEncrypted != 0 && r.aead == nil:
+		r.reject("encrypted frame and no key")
+		return
+	case flags&FlagEncrypted != 0:
+		var err error
+		if full, err = unseal(r.aead, full, flags); err != nil {
+			r.reject("frame failed to decrypt with the key")
+			return
+		}
+	}
+	if flags&FlagDeflate != 0 {
+		var err error
+		if full, err = inflate(full); err != nil {
+			r.undecodable.Add(1)
+			r.logger.Debug("dropping frame that failed to inflate", "frame", frameID, "err", err)
+			return
+		}
+	}
+	var meta FrameMeta
+	if flags&FlagDims != 0 {
+		var ok bool
+		if full, meta, ok = cutDims(full); !ok {
+			r.badLength.Add(1)
+			return
+		}
+	}
+	if flags&FlagRegion != 0 {
+		if full = r.compose(full); full == nil {
+			return
+		}
+	} else {
+		r.key = keyframe{id: frameID, b: full}
+	}
+	r.assembled.Add(1)
+	r.deliver(full, meta)
+}
+
+// reject counts a frame dropped for its encryption, warning about the first
+// as a wrong or missing key otherwise shows up only as silence.
+func (r *Receiver) reject(why string) {
+	if r.rejected.Add(1) == 1 {
+		r.logger.Warn("dropping frames that don't match the receiver's key setting; check -key on both ends", "reason", why)
+	}
+}
+
+// deliver hands a complete frame to Next, through the jitter buffer if there
+// is one. By default a frame arriving while
+// the queue is full is dropped; in LatestOnly mode the queued (older) frame is
+// discarded instead so the consumer always gets the freshest one.
+func (r *Receiver) deliver(b []byte, meta FrameMeta) {
+	r.mu.Lock()
+	r.latest, r.latestAt = b, time.Now()
+	r.mu.Unlock()
+	if r.held != nil {
+		select {
+		case r.held <- heldFrame{f: received{b, meta}, at: time.Now()}:
+		default:
+			r.dropped.Add(1)
+		}
+		return
+	}
+	r.queue(received{b, meta})
+}
+
+// queue puts f on the Next queue, dropping per the LatestOnly policy when it
+// is full.
+func (r *Receiver) queue(f received) {
+	select {
+	case r.out <- f:
+		return
+	default:
+	}
+	if r.latestOnly {
+		select {
+		case <-r.out:
+		default:
+		}
+		select {
+		case r.out <- f:
+		default:
+		}
+	}
+	r.dropped.Add(1)
+}
+
+// Latest returns the most recently completed frame without blocking or
+// consuming it, or nil if none has arrived yet.
+func (r *Receiver) Latest() []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.latest
+}
+
+// LastFrameTime returns when the most recent frame was assembled, or the zero
+// Time if none has been yet.
+func (r *Receiver) LastFrameTime() time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.latestAt
+}
+
+// LastFrameAge returns how long ago the most recent frame was assembled, or
+// how long the receiver has been waiting for its first one. Unlike a
+// blocked Next, it tells a stalled stream from a quiet moment: poll it and
+// compare against the expected frame interval.
+func (r *Receiver) LastFrameAge() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.latestAt.IsZero() {
+		return time.Since(r.started)
+	}
+	return time.Since(r.latestAt)
+}
+
+// evictOldest drops the partial frame that was started first, to make room
+// for a new one. r.mu must be held.
+func (r *Receiver) evictOldest() {
+	var oldest uint32
+	var at time.Time
+	for id, af := range r.frames {
+		if at.IsZero() || af.created.Before(at) {
+			oldest, at = id, af.created
+		}
+	}
+	delete(r.frames, oldest)
+	r.incomplete.Add(1)
 }
 
 func (r *Receiver) purgeLoop() {
-	ticker := time.NewTicker(1 * time.Second)
+	// check a few times per timeout, and at least once a second but no
+	// more than once a millisecond
+	ticker := time.NewTicker(min(max(r.reassembly/4, time.Millisecond), time.Second))
 	defer ticker.Stop()
+	lastSummary := time.Now()
 	for {
 		select {
 		case <-r.stop:
 			return
-		case <-ticker.C:
-			cutoff := time.Now().Add(-5 * time.Second)
+		case now := <-ticker.C:
+			// summarize traffic at a sane rate instead of per packet
+			if now.Sub(lastSummary) >= 10*time.Second {
+				lastSummary = now
+				r.logger.Debug("recv summary", "packets", r.packets.Load(), "frames", r.assembled.Load())
+			}
+			cutoff := now.Add(-r.reassembly)
 			r.mu.Lock()
 			for id, af := range r.frames {
 				if af.created.Before(cutoff) {
 					delete(r.frames, id)
+					r.incomplete.Add(1)
 				}
 			}
 			r.mu.Unlock()
@@ -351,15 +1224,88 @@ func (r *Receiver) purgeLoop() {
 // Next returns the next fully reassembled frame (blocks). It will return
 // legacy small packets as-is and assembled fragments when available.
 func (r *Receiver) Next() ([]byte, error) {
-	b, ok := <-r.out
-	if !ok {
-		return nil, fmt.Errorf("receiver closed")
+	return r.NextContext(context.Background())
+}
+
+// NextContext is like Next but returns ctx.Err() if ctx is cancelled before a
+// frame is available.
+func (r *Receiver) NextContext(ctx context.Context) ([]byte, error) {
+	b, _, err := r.next(ctx)
+	return b, err
+}
+
+// Stats is a snapshot of Receiver counters since it was created.
+type Stats struct {
+	Packets      uint64 // datagrams read from the socket
+	Frames       uint64 // frames fully reassembled
+	Dropped      uint64 // reassembled frames dropped (or superseded, in LatestOnly mode) because Next wasn't keeping up
+	Incomplete   uint64 // frames purged with fragments still missing (lost)
+	Invalid      uint64 // malformed fragments discarded (bad total or index)
+	NACKs        uint64 // retransmission requests sent (see ReceiverOptions.NACKPort)
+	OutOfOrder   uint64 // frames dropped for arriving after a newer one (see ReceiverOptions.InOrder)
+	BadLength    uint64 // frames dropped because their assembled size didn't match the header's
+	Undecodable  uint64 // compressed frames dropped because they failed to inflate
+	Regions      uint64 // region updates rebuilt into whole frames (see Sender.SendRegion)
+	StaleRegions uint64 // region updates dropped because their keyframe was missed
+	Rejected     uint64 // frames dropped for not decrypting with ReceiverOptions.Key, or arriving encrypted without one
+}
+
+// Stats returns the current receive counters.
+func (r *Receiver) Stats() Stats {
+	return Stats{
+		Packets:      r.packets.Load(),
+		Frames:       r.assembled.Load(),
+		Dropped:      r.dropped.Load(),
+		Incomplete:   r.incomplete.Load(),
+		Invalid:      r.invalid.Load(),
+		NACKs:        r.nacks.Load(),
+		OutOfOrder:   r.outOfOrder.Load(),
+		BadLength:    r.badLength.Load(),
+		Undecodable:  r.undecodable.Load(),
+		Regions:      r.regions.Load(),
+		StaleRegions: r.staleRegions.Load(),
+		Rejected:     r.rejected.Load(),
+	}
+}
+
+// PendingFrame describes a frame still being reassembled.
+type PendingFrame struct {
+	FrameID  uint32
+	Received int           // distinct fragments in so far
+	Total    int           // fragments the frame was sent as
+	Age      time.Duration // since its first fragment arrived
+}
+
+// PendingFrames returns the frames currently mid-assembly, oldest first, for
+// diagnosing loss: many frames each missing a little points to scattered
+// drops, a few frames missing a lot to bursts, and partial frames older than
+// the reassembly timeout are about to be counted as Incomplete.
+func (r *Receiver) PendingFrames() []PendingFrame {
+	now := time.Now()
+	r.mu.Lock()
+	pending := make([]PendingFrame, 0, len(r.frames))
+	for id, f := range r.frames {
+		pending = append(pending, PendingFrame{FrameID: id, Received: f.received, Total: int(f.total), Age: now.Sub(f.created)})
 	}
-	return b, nil
+	r.mu.Unlock()
+	slices.SortFunc(pending, func(a, b PendingFrame) int { return cmp.Compare(b.Age, a.Age) })
+	return pending
 }
 
+// Interface returns the name of the interface the multicast group was joined
+// on, or "" if the join failed everywhere and the receiver only listens on the port.
+// With JoinAllInterfaces it is a comma-separated list of every one joined.
+func (r *Receiver) Interface() string { return r.iface }
+
+// Close stops the receiver. It closes the socket to unblock the reader, waits
+// for it and the receiver's other goroutines to exit, and after that Next
+// reports the receiver as closed. It is safe to call more than once.
 func (r *Receiver) Close() error {
-	close(r.stop)
-	close(r.out)
-	return r.conn.Close()
+	var err error
+	r.closeOnce.Do(func() {
+		close(r.stop)
+		err = r.conn.Close()
+		r.wg.Wait()
+	})
+	return err
 }
@@ -1,9 +1,29 @@
 package mcast
 
 import (
+	"bytes"
+	"context"
+	"crypto/cipher"
 	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"os"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"golang.org/x/net/ipv4"
 )
 
 func TestFragmentHeaderAndAssemble(t *testing.T) {
@@ -14,14 +34,14 @@ func TestFragmentHeaderAndAssemble(t *testing.T) {
 	}
 
 	mtu := 1200
-	payloadPer := mtu - fragHeaderSize
+	payloadPer := mtu - FragmentHeaderSize
 	if payloadPer <= 0 {
 		t.Fatalf("bad payloadPer")
 	}
 	total := (len(payload) + payloadPer - 1) / payloadPer
 
 	// emulate receiver
-	r := &Receiver{frames: make(map[uint32]*assemblingFrame), out: make(chan []byte, 4)}
+	r := &Receiver{frames: make(map[uint32]*assemblingFrame), out: make(chan received, 4)}
 	frameID := uint32(42)
 
 	for i := 0; i < total; i++ {
@@ -30,19 +50,19 @@ func TestFragmentHeaderAndAssemble(t *testing.T) {
 		if end > len(payload) {
 			end = len(payload)
 		}
-		frag := make([]byte, fragHeaderSize+(end-start))
-		frag[0] = fragVersion
+		frag := make([]byte, FragmentHeaderSize+(end-start))
+		frag[0] = FragmentVersion
 		binary.BigEndian.PutUint32(frag[1:5], frameID)
 		binary.BigEndian.PutUint16(frag[5:7], uint16(total))
 		binary.BigEndian.PutUint16(frag[7:9], uint16(i))
-		copy(frag[fragHeaderSize:], payload[start:end])
+		copy(frag[FragmentHeaderSize:], payload[start:end])
 
 		// feed fragment processing logic (simulating readLoop body)
 		frameID2 := binary.BigEndian.Uint32(frag[1:5])
 		total2 := binary.BigEndian.Uint16(frag[5:7])
 		idx := binary.BigEndian.Uint16(frag[7:9])
-		payloadPart := make([]byte, len(frag)-fragHeaderSize)
-		copy(payloadPart, frag[fragHeaderSize:])
+		payloadPart := make([]byte, len(frag)-FragmentHeaderSize)
+		copy(payloadPart, frag[FragmentHeaderSize:])
 
 		af, ok := r.frames[frameID2]
 		if !ok {
@@ -73,3 +93,1448 @@ func TestFragmentHeaderAndAssemble(t *testing.T) {
 
 	t.Fatalf("did not assemble frame")
 }
+
+// freePort returns a UDP port that was free a moment ago.
+func freePort(t *testing.T) string {
+	t.Helper()
+	c, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer c.Close()
+	return strconv.Itoa(c.LocalAddr().(*net.UDPAddr).Port)
+}
+
+func TestReceiverCloseUnderLoad(t *testing.T) {
+	port := freePort(t)
+
+	// flood the port with complete single-fragment frames so readLoop is
+	// constantly delivering to out while receivers are being closed
+	stopLoad := make(chan struct{})
+	loadDone := make(chan struct{})
+	go func() {
+		defer close(loadDone)
+		conn, err := net.Dial("udp4", "127.0.0.1:"+port)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		frag := make([]byte, FragmentHeaderSize+512)
+		frag[0] = FragmentVersion
+		binary.BigEndian.PutUint16(frag[5:7], 1)
+		for id := uint32(0); ; id++ {
+			select {
+			case <-stopLoad:
+				return
+			default:
+			}
+			binary.BigEndian.PutUint32(frag[1:5], id)
+			_, _ = conn.Write(frag)
+		}
+	}()
+	defer func() {
+		close(stopLoad)
+		<-loadDone
+	}()
+
+	for i := 0; i < 20; i++ {
+		r, err := NewReceiver("224.0.0.250:"+port, "")
+		if err != nil {
+			t.Fatalf("NewReceiver: %v", err)
+		}
+		consumerDone := make(chan struct{})
+		go func() {
+			defer close(consumerDone)
+			for {
+				if _, err := r.Next(); err != nil {
+					return
+				}
+			}
+		}()
+		time.Sleep(5 * time.Millisecond)
+		if err := r.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+		// a second Close must not panic
+		_ = r.Close()
+		select {
+		case <-consumerDone:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Next did not return after Close")
+		}
+	}
+}
+
+func TestCloseWaitsForGoroutines(t *testing.T) {
+	before := runtime.NumGoroutine()
+	r, err := NewReceiverWithOptions("239.255.77.2:"+freePort(t), ReceiverOptions{NACKPort: 1, JitterBuffer: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Errorf("%d goroutines after Close, %d before the receiver", n, before)
+	}
+}
+
+func TestNewReceiverExplicitInterface(t *testing.T) {
+	port := freePort(t)
+	if _, err := NewReceiver("224.0.0.250:"+port, "no-such-iface0"); err == nil {
+		t.Fatalf("expected error for unknown interface")
+	}
+
+	// an explicitly named interface must be the one joined, never a fallback
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Skipf("interfaces: %v", err)
+	}
+	for _, ifi := range ifaces {
+		if ifi.Flags&net.FlagUp == 0 || ifi.Flags&net.FlagMulticast == 0 {
+			continue
+		}
+		r, err := NewReceiver("224.0.0.250:"+port, ifi.Name)
+		if err != nil {
+			continue
+		}
+		got := r.Interface()
+		r.Close()
+		if got != ifi.Name {
+			t.Fatalf("joined on %q, want %q", got, ifi.Name)
+		}
+		return
+	}
+	t.Skip("no interface could join the multicast group")
+}
+
+func TestDeliverLatestOnly(t *testing.T) {
+	r := &Receiver{latestOnly: true, out: make(chan received, 1)}
+	for _, b := range [][]byte{{1}, {2}, {3}} {
+		r.deliver(b, FrameMeta{})
+	}
+	if got := r.Latest(); got[0] != 3 {
+		t.Fatalf("Latest = %v, want [3]", got)
+	}
+	got, err := r.Next()
+	if err != nil || got[0] != 3 {
+		t.Fatalf("Next = %v, %v, want [3]", got, err)
+	}
+	if d := r.Stats().Dropped; d != 2 {
+		t.Fatalf("dropped = %d, want 2", d)
+	}
+}
+
+func TestSendFrameTooManyFragments(t *testing.T) {
+	s, err := NewSender("127.0.0.1:"+freePort(t), "", 1)
+	if err != nil {
+		t.Fatalf("NewSender: %v", err)
+	}
+	defer s.Close()
+	// 64 bytes of payload per fragment: 65536 fragments is one too many
+	b := make([]byte, 64*(maxFragments+1))
+	if err := s.SendFrame(b, 64+FragmentHeaderSize, 1); err == nil {
+		t.Fatalf("expected error for %d fragments", maxFragments+1)
+	}
+}
+
+func makeFrag(frameID uint32, total, idx uint16, payload []byte) []byte {
+	frag := make([]byte, FragmentHeaderSize+len(payload))
+	FragmentHeader{Version: FragmentVersion, FrameID: frameID, Total: total, Index: idx}.Marshal(frag)
+	copy(frag[FragmentHeaderSize:], payload)
+	return frag
+}
+
+func TestFragmentHeaderRoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		h    FragmentHeader
+		wire []byte
+	}{
+		// the layout is the wire format, so pin it down byte by byte
+		{FragmentHeader{Version: 1, FrameID: 0xdeadbeef, Total: 300, Index: 299},
+			[]byte{1, 0xde, 0xad, 0xbe, 0xef, 0x01, 0x2c, 0x01, 0x2b}},
+		{FragmentHeader{Version: 2, FrameID: 0xdeadbeef, Total: 300, Index: 299, Length: 358400},
+			[]byte{2, 0xde, 0xad, 0xbe, 0xef, 0x01, 0x2c, 0x01, 0x2b, 0, 0x00, 0x05, 0x78, 0x00}},
+	} {
+		b := make([]byte, tc.h.Size())
+		tc.h.Marshal(b)
+		if !bytes.Equal(b, tc.wire) {
+			t.Fatalf("v%d Marshal = % x", tc.h.Version, b)
+		}
+		var got FragmentHeader
+		if err := got.Unmarshal(b); err != nil || got != tc.h {
+			t.Fatalf("v%d Unmarshal = %+v, %v", tc.h.Version, got, err)
+		}
+		if err := got.Unmarshal(b[:len(b)-1]); err != ErrShortHeader {
+			t.Fatalf("v%d short Unmarshal err = %v", tc.h.Version, err)
+		}
+	}
+}
+
+func TestFrameLengthMismatch(t *testing.T) {
+	r := &Receiver{logger: slog.Default(), frames: make(map[uint32]*assemblingFrame), out: make(chan received, 4)}
+	frag := func(id uint32, idx uint16, length uint32, payload string) []byte {
+		b := make([]byte, FragmentHeaderSize+len(payload))
+		FragmentHeader{Version: FragmentVersion, FrameID: id, Total: 2, Index: idx, Length: length}.Marshal(b)
+		copy(b[FragmentHeaderSize:], payload)
+		return b
+	}
+	// a truncated last fragment completes the count but not the bytes
+	r.handlePacket(frag(1, 0, 8, "abcd"), nil)
+	r.handlePacket(frag(1, 1, 8, "ef"), nil)
+	if len(r.out) != 0 || r.Stats().BadLength != 1 {
+		t.Fatalf("truncated frame: delivered %d, bad length %d", len(r.out), r.Stats().BadLength)
+	}
+	r.handlePacket(frag(2, 0, 6, "abcd"), nil)
+	r.handlePacket(frag(2, 1, 6, "ef"), nil)
+	// version 1 fragments carry no length and are not checked
+	v1 := make([]byte, fragmentHeaderSizeV1+3)
+	FragmentHeader{Version: 1, FrameID: 3, Total: 1}.Marshal(v1)
+	copy(v1[fragmentHeaderSizeV1:], "xyz")
+	r.handlePacket(v1, nil)
+	for _, want := range []string{"abcdef", "xyz"} {
+		if got, _ := r.Next(); string(got) != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestHandlePacketRejectsMalformed(t *testing.T) {
+	r := &Receiver{frames: make(map[uint32]*assemblingFrame), out: make(chan received, 4)}
+
+	r.handlePacket(makeFrag(1, 0, 0, []byte("zero total")), nil)
+	r.handlePacket(makeFrag(2, 2, 2, []byte("index == total")), nil)
+	r.handlePacket(makeFrag(3, 2, 0xffff, []byte("index > total")), nil)
+	// first fragment claims 2 parts, a later one 1: must not complete frame 4
+	r.handlePacket(makeFrag(4, 2, 0, []byte("a")), nil)
+	r.handlePacket(makeFrag(4, 1, 0, []byte("b")), nil)
+
+	if got := r.Stats().Invalid; got != 4 {
+		t.Fatalf("invalid = %d, want 4", got)
+	}
+	if len(r.out) != 0 {
+		t.Fatalf("malformed fragments delivered %d frames", len(r.out))
+	}
+	if _, ok := r.frames[1]; ok {
+		t.Fatalf("zero-total frame was tracked")
+	}
+
+	// a well-formed frame still assembles
+	r.handlePacket(makeFrag(4, 2, 1, []byte("c")), nil)
+	got, _ := r.Next()
+	if string(got) != "ac" {
+		t.Fatalf("assembled %q, want %q", got, "ac")
+	}
+}
+
+func TestFrameIDWraparound(t *testing.T) {
+	r := &Receiver{logger: slog.Default(), frames: make(map[uint32]*assemblingFrame), out: make(chan received, 8)}
+
+	// IDs wrapping past 2^32 are consecutive, not a restart
+	for _, id := range []uint32{0xfffffffe, 0xffffffff, 0, 1} {
+		r.handlePacket(makeFrag(id, 1, 0, []byte{byte(id)}), nil)
+	}
+	if len(r.out) != 4 || r.Stats().Incomplete != 0 {
+		t.Fatalf("wraparound: delivered %d, incomplete %d", len(r.out), r.Stats().Incomplete)
+	}
+	for len(r.out) > 0 {
+		<-r.out
+	}
+
+	// a stale partial frame 7, then the stream moves far ahead...
+	r.handlePacket(makeFrag(7, 2, 0, []byte("old")), nil)
+	r.handlePacket(makeFrag(70000, 2, 0, []byte("x")), nil)
+	// ...and a restarted sender reuses ID 7: its fragments must not be
+	// mixed with the stale one
+	r.handlePacket(makeFrag(7, 2, 1, []byte("new1")), nil)
+	if len(r.out) != 0 {
+		t.Fatalf("stale fragment was combined with a new one: %q", (<-r.out).b)
+	}
+	r.handlePacket(makeFrag(7, 2, 0, []byte("new0")), nil)
+	got, _ := r.Next()
+	if string(got) != "new0new1" {
+		t.Fatalf("assembled %q, want %q", got, "new0new1")
+	}
+}
+
+func TestSenderUnicastTarget(t *testing.T) {
+	l, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer l.Close()
+
+	s, err := NewSender("127.0.0.1:"+freePort(t), "", 1)
+	if err != nil {
+		t.Fatalf("NewSender: %v", err)
+	}
+	defer s.Close()
+	if err := s.AddUnicastTarget(l.LocalAddr().String()); err != nil {
+		t.Fatalf("AddUnicastTarget: %v", err)
+	}
+	if err := s.SendFrame([]byte("hello"), 1200, 1); err != nil {
+		t.Fatalf("SendFrame: %v", err)
+	}
+
+	buf := make([]byte, 2048)
+	_ = l.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := l.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if buf[0] != FragmentVersion || string(buf[FragmentHeaderSize:n]) != "hello" {
+		t.Fatalf("unexpected datagram % x", buf[:n])
+	}
+}
+
+func TestInterleaveOrder(t *testing.T) {
+	for _, total := range []int{1, 2, 3, 10, 87, 100} {
+		order := interleaveOrder(total)
+		seen := make([]bool, total)
+		for _, i := range order {
+			if i < 0 || i >= total || seen[i] {
+				t.Fatalf("total %d: bad or repeated index %d in %v", total, i, order)
+			}
+			seen[i] = true
+		}
+		if len(order) != total {
+			t.Fatalf("total %d: got %d indices", total, len(order))
+		}
+		if total >= 10 && order[1]-order[0] < 2 {
+			t.Fatalf("total %d: not interleaved: %v", total, order[:4])
+		}
+	}
+}
+
+func TestNextContextCancel(t *testing.T) {
+	r := &Receiver{out: make(chan received, 1)}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := r.NextContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("NextContext = %v, want DeadlineExceeded", err)
+	}
+	r.out <- received{b: []byte("x")}
+	if b, err := r.NextContext(context.Background()); err != nil || string(b) != "x" {
+		t.Fatalf("NextContext = %q, %v", b, err)
+	}
+}
+
+func TestSenderMTU(t *testing.T) {
+	ifi := multicastInterface(t)
+	s, err := NewSenderWithOptions("239.255.0.1:"+freePort(t), SenderOptions{Interface: ifi.Name, DiscoverMTU: true})
+	if err != nil {
+		t.Fatalf("NewSender: %v", err)
+	}
+	defer s.Close()
+	want := min(max(ifi.MTU-ipUDPOverhead, minMTU), maxUDPPayload)
+	if got := s.MTU(); got != want {
+		t.Fatalf("MTU() = %d, want %d for interface MTU %d", got, want, ifi.MTU)
+	}
+	if err := s.SendFrame(make([]byte, 3*want), 0, 1); err != nil {
+		t.Fatalf("SendFrame with mtu 0: %v", err)
+	}
+}
+
+func TestNACKRetransmit(t *testing.T) {
+	l, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer l.Close()
+	s, err := NewSenderWithOptions(l.LocalAddr().String(), SenderOptions{NACKListen: "127.0.0.1:0"})
+	if err != nil {
+		t.Fatalf("NewSender: %v", err)
+	}
+	defer s.Close()
+
+	buf := make([]byte, 2048)
+	read := func() (FragmentHeader, error) {
+		_ = l.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+		n, err := l.Read(buf)
+		var h FragmentHeader
+		if err == nil {
+			err = h.Unmarshal(buf[:n])
+		}
+		return h, err
+	}
+	if err := s.SendFrame(make([]byte, 300), 100+FragmentHeaderSize, 1); err != nil {
+		t.Fatalf("SendFrame: %v", err)
+	}
+	var id uint32
+	for i := 0; i < 3; i++ {
+		h, err := read()
+		if err != nil {
+			t.Fatalf("fragment %d: %v", i, err)
+		}
+		id = h.FrameID
+	}
+
+	c, err := net.DialUDP("udp4", nil, s.nackConn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer c.Close()
+	_, _ = c.Write(marshalNACK(id, []uint16{1}))
+	h, err := read()
+	if err != nil || h.FrameID != id || h.Index != 1 {
+		t.Fatalf("retransmit = %+v, %v", h, err)
+	}
+	// each fragment is resent only once, however many receivers ask
+	_, _ = c.Write(marshalNACK(id, []uint16{1}))
+	if _, err := read(); err == nil {
+		t.Fatalf("fragment resent twice")
+	}
+	if n := s.Retransmits(); n != 1 {
+		t.Fatalf("Retransmits() = %d, want 1", n)
+	}
+}
+
+func TestReceiverSendsNACK(t *testing.T) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer conn.Close()
+	sender, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer sender.Close()
+
+	r := &Receiver{conn: conn, logger: slog.Default(), frames: make(map[uint32]*assemblingFrame), out: make(chan received, 4), stop: make(chan struct{}),
+		nackPort: sender.LocalAddr().(*net.UDPAddr).Port, nackDelay: 10 * time.Millisecond}
+	from := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+	for _, i := range []uint16{0, 1, 3} {
+		r.handlePacket(makeFrag(9, 4, i, []byte{byte(i)}), from)
+	}
+	// too much missing to be worth asking for
+	r.handlePacket(makeFrag(10, 4, 0, []byte{0}), from)
+	go r.nackLoop()
+	defer close(r.stop)
+
+	buf := make([]byte, 64)
+	_ = sender.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := sender.Read(buf)
+	if err != nil {
+		t.Fatalf("no NACK: %v", err)
+	}
+	id, idx, err := parseNACK(buf[:n])
+	if err != nil || id != 9 || len(idx) != 1 || idx[0] != 2 {
+		t.Fatalf("NACK = %d %v %v, want frame 9 fragment 2", id, idx, err)
+	}
+	_ = sender.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, err := sender.Read(buf); err == nil {
+		t.Fatalf("unexpected second NACK")
+	}
+	if got := r.Stats().NACKs; got != 1 {
+		t.Fatalf("Stats().NACKs = %d, want 1", got)
+	}
+}
+
+func TestLossInjectorDeterministic(t *testing.T) {
+	a, b := newLossInjector(0.25, 7), newLossInjector(0.25, 7)
+	dropped := 0
+	for i := 0; i < 10000; i++ {
+		da := a.drop()
+		if da != b.drop() {
+			t.Fatalf("same seed diverged at draw %d", i)
+		}
+		if da {
+			dropped++
+		}
+	}
+	if dropped < 2300 || dropped > 2700 {
+		t.Fatalf("dropped %d of 10000 at rate 0.25", dropped)
+	}
+}
+
+// TestNACKRecoversLoss sends the same stream through a receiver with 10%
+// simulated loss, with and without NACKs, and expects NACKs to recover most
+// of the frames lost without them.
+func TestNACKRecoversLoss(t *testing.T) {
+	const frames = 40
+	run := func(nack bool) uint64 {
+		port, nackPort := freePort(t), freePort(t)
+		ropts := ReceiverOptions{lossRate: 0.1, lossSeed: 1}
+		sopts := SenderOptions{}
+		if nack {
+			ropts.NACKPort, _ = strconv.Atoi(nackPort)
+			sopts.NACKListen = "127.0.0.1:" + nackPort
+		}
+		r, err := NewReceiverWithOptions("239.255.0.1:"+port, ropts)
+		if err != nil {
+			t.Fatalf("NewReceiver: %v", err)
+		}
+		defer r.Close()
+		s, err := NewSenderWithOptions("127.0.0.1:"+port, sopts)
+		if err != nil {
+			t.Fatalf("NewSender: %v", err)
+		}
+		defer s.Close()
+		go func() {
+			for {
+				if _, err := r.Next(); err != nil {
+					return
+				}
+			}
+		}()
+		for i := 0; i < frames; i++ {
+			if err := s.SendFrame(make([]byte, 400), 100+FragmentHeaderSize, 1); err != nil {
+				t.Fatalf("SendFrame: %v", err)
+			}
+			time.Sleep(4 * DefaultNACKDelay / 3)
+		}
+		time.Sleep(2 * DefaultNACKDelay)
+		return r.Stats().Frames
+	}
+	without, with := run(false), run(true)
+	t.Logf("frames received out of %d: %d without NACK, %d with", frames, without, with)
+	if without >= frames {
+		t.Fatalf("no frames lost at 10%% loss; injector not applied")
+	}
+	if with <= without || with < frames*9/10 {
+		t.Fatalf("NACK recovered too little: %d without, %d with", without, with)
+	}
+}
+
+// multicastInterface returns an up, multicast-capable interface with an IPv4
+// address, or skips the test when the host has none (e.g. a bare CI container).
+func multicastInterface(t *testing.T) *net.Interface {
+	t.Helper()
+	ifs, err := net.Interfaces()
+	if err != nil {
+		t.Skipf("interfaces: %v", err)
+	}
+	for i := range ifs {
+		ifi := &ifs[i]
+		if ifi.Flags&net.FlagUp == 0 || ifi.Flags&net.FlagMulticast == 0 {
+			continue
+		}
+		addrs, _ := ifi.Addrs()
+		for _, a := range addrs {
+			if n, ok := a.(*net.IPNet); ok && n.IP.To4() != nil {
+				return ifi
+			}
+		}
+	}
+	t.Skip("no multicast-capable IPv4 interface")
+	return nil
+}
+
+// TestLoopbackMulticast sends a frame through real sockets: a Sender to a
+// group and a Receiver that joined it on the same host, relying on multicast
+// loopback to deliver it.
+func TestLoopbackMulticast(t *testing.T) {
+	ifi := multicastInterface(t)
+	addr := "239.255.77.1:" + freePort(t)
+	r, err := NewReceiverWithOptions(addr, ReceiverOptions{Interface: ifi.Name})
+	if err != nil {
+		t.Skipf("cannot join %s on %s: %v", addr, ifi.Name, err)
+	}
+	defer r.Close()
+	s, err := NewSenderWithOptions(addr, SenderOptions{Interface: ifi.Name})
+	if err != nil {
+		t.Fatalf("NewSender: %v", err)
+	}
+	defer s.Close()
+
+	want := make([]byte, 50_000)
+	for i := range want {
+		want[i] = byte(i * 7)
+	}
+	if err := s.SendFrame(want, 1200, 1); err != nil {
+		t.Skipf("cannot send to %s: %v", addr, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	got, err := r.NextContext(ctx)
+	if err != nil {
+		t.Fatalf("no frame over %s: %v (stats %+v)", ifi.Name, err, r.Stats())
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("frame differs: got %d bytes, want %d", len(got), len(want))
+	}
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if _, err := r.Next(); err == nil {
+		t.Fatalf("Next after Close returned a frame")
+	}
+}
+
+func TestExplicitInterface(t *testing.T) {
+	if _, err := NewSender("239.1.2.3:5000", "no-such-if0", 1); err == nil {
+		t.Error("NewSender accepted an unknown interface")
+	}
+	if _, err := NewReceiver("239.1.2.3:"+freePort(t), "no-such-if0"); err == nil {
+		t.Error("NewReceiver accepted an unknown interface")
+	}
+
+	ifi := multicastInterface(t)
+	s, err := NewSender("239.1.2.3:"+freePort(t), ifi.Name, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	// the stream leaves from the interface's own address
+	if got, want := s.conn.LocalAddr().(*net.UDPAddr).IP, interfaceIPv4(ifi); !got.Equal(want) {
+		t.Errorf("source address = %v, want %v of %s", got, want, ifi.Name)
+	}
+}
+
+func TestReassemblyTimeout(t *testing.T) {
+	r, err := NewReceiverWithOptions("239.255.77.2:"+freePort(t), ReceiverOptions{ReassemblyTimeout: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	r.handlePacket(makeFrag(1, 2, 0, []byte("a")), nil)
+	deadline := time.Now().Add(2 * time.Second)
+	for r.Stats().Incomplete == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("partial frame was not purged after the reassembly timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTinyTimeouts(t *testing.T) {
+	// timeouts too short to divide into ticks must not panic
+	r, err := NewReceiverWithOptions("239.255.77.2:"+freePort(t), ReceiverOptions{ReassemblyTimeout: time.Nanosecond, NACKPort: 1, NACKDelay: time.Nanosecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	r.Close()
+}
+
+func TestInOrder(t *testing.T) {
+	for _, inOrder := range []bool{false, true} {
+		r := &Receiver{logger: slog.Default(), inOrder: inOrder, frames: make(map[uint32]*assemblingFrame), out: make(chan received, 4)}
+		// frame 5 loses a fragment to reordering and completes after 6
+		r.handlePacket(makeFrag(5, 2, 0, []byte("5a")), nil)
+		r.handlePacket(makeFrag(6, 1, 0, []byte("6")), nil)
+		r.handlePacket(makeFrag(5, 2, 1, []byte("5b")), nil)
+		r.handlePacket(makeFrag(7, 1, 0, []byte("7")), nil)
+		var got []string
+		for len(r.out) > 0 {
+			got = append(got, string((<-r.out).b))
+		}
+		want, dropped := []string{"6", "5a5b", "7"}, uint64(0)
+		if inOrder {
+			want, dropped = []string{"6", "7"}, 1
+		}
+		if !slices.Equal(got, want) || r.Stats().OutOfOrder != dropped {
+			t.Errorf("InOrder=%v: delivered %q (%d out of order), want %q (%d)", inOrder, got, r.Stats().OutOfOrder, want, dropped)
+		}
+	}
+}
+
+func TestJitterBuffer(t *testing.T) {
+	r := &Receiver{jitter: 200 * time.Millisecond, held: make(chan heldFrame, 64), out: make(chan received, 64),
+		stop: make(chan struct{})}
+	r.background(r.jitterLoop)
+	defer func() { close(r.held); r.wg.Wait() }()
+
+	// learn a 20ms frame period, then complete five frames in one burst
+	const period = 20 * time.Millisecond
+	for range 10 {
+		r.deliver([]byte("steady"), FrameMeta{})
+		time.Sleep(period)
+	}
+	for range 10 {
+		<-r.out
+	}
+	for range 5 {
+		r.deliver([]byte("burst"), FrameMeta{})
+	}
+	start := time.Now()
+	var released []time.Duration
+	for range 5 {
+		<-r.out
+		released = append(released, time.Since(start))
+	}
+	// the burst comes out spread at roughly the frame period, within the
+	// buffer delay
+	for i := 1; i < len(released); i++ {
+		if gap := released[i] - released[i-1]; gap < period/2 {
+			t.Errorf("frames %d and %d released %v apart, want about %v (released at %v)", i-1, i, gap, period, released)
+		}
+	}
+	if last := released[len(released)-1]; last > r.jitter+50*time.Millisecond {
+		t.Errorf("last burst frame released after %v, want within %v", last, r.jitter)
+	}
+}
+
+func TestCompress(t *testing.T) {
+	l, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer l.Close()
+	s, err := NewSenderWithOptions(l.LocalAddr().String(), SenderOptions{Compress: true})
+	if err != nil {
+		t.Fatalf("NewSender: %v", err)
+	}
+	defer s.Close()
+	r := &Receiver{logger: slog.Default(), frames: make(map[uint32]*assemblingFrame), out: make(chan received, 4)}
+
+	flat := bytes.Repeat([]byte("flat "), 2000)
+	noise := make([]byte, 10000)
+	rng := rand.New(rand.NewPCG(1, 2))
+	for i := range noise {
+		noise[i] = byte(rng.Uint32())
+	}
+	buf := make([]byte, 2048)
+	for _, tc := range []struct {
+		name  string
+		frame []byte
+		flags uint8
+	}{{"flat", flat, FlagDeflate}, {"noise", noise, 0}} {
+		if err := s.SendFrame(tc.frame, 1200, 1); err != nil {
+			t.Fatalf("%s: SendFrame: %v", tc.name, err)
+		}
+		for len(r.out) == 0 {
+			_ = l.SetReadDeadline(time.Now().Add(2 * time.Second))
+			n, _, err := l.ReadFromUDP(buf)
+			if err != nil {
+				t.Fatalf("%s: read: %v", tc.name, err)
+			}
+			var h FragmentHeader
+			if err := h.Unmarshal(buf[:n]); err != nil || h.Flags != tc.flags {
+				t.Fatalf("%s: header %+v, %v; want flags %#x", tc.name, h, err, tc.flags)
+			}
+			r.handlePacket(buf[:n], nil)
+		}
+		if got := (<-r.out).b; !bytes.Equal(got, tc.frame) {
+			t.Fatalf("%s: received %d bytes, want the %d sent", tc.name, len(got), len(tc.frame))
+		}
+	}
+}
+
+func TestInterfaces(t *testing.T) {
+	ifs, err := Interfaces()
+	if err != nil {
+		t.Fatalf("Interfaces: %v", err)
+	}
+	defaults := 0
+	for _, ifi := range ifs {
+		if ifi.Default {
+			defaults++
+			if !ifi.Up || !ifi.Multicast || ifi.Loopback {
+				t.Errorf("%s is the default but not an up, non-loopback multicast interface", ifi.Name)
+			}
+		}
+	}
+	if defaults > 1 {
+		t.Errorf("%d default interfaces, want at most 1", defaults)
+	}
+
+	mi := multicastInterface(t)
+	if err := TryJoin("239.255.0.1", mi.Name); err != nil {
+		t.Errorf("TryJoin on %s: %v", mi.Name, err)
+	}
+	if err := TryJoin("10.0.0.1", mi.Name); err == nil {
+		t.Error("TryJoin with a unicast group succeeded")
+	}
+}
+
+func TestMaxPendingFrames(t *testing.T) {
+	r := &Receiver{logger: slog.Default(), frames: make(map[uint32]*assemblingFrame), out: make(chan received, 4), maxPending: 16}
+	const flood = 10000
+	for id := range uint32(flood) {
+		r.handlePacket(makeFrag(id, 2, 0, []byte("a")), nil)
+		if len(r.frames) > 16 {
+			t.Fatalf("%d partial frames after frame %d, want at most 16", len(r.frames), id)
+		}
+	}
+	if got := r.Stats().Incomplete; got != flood-16 {
+		t.Fatalf("incomplete = %d, want %d evicted", got, flood-16)
+	}
+	// the newest partial frames survive and still complete
+	r.handlePacket(makeFrag(flood-1, 2, 1, []byte("b")), nil)
+	if got, _ := r.Next(); string(got) != "ab" {
+		t.Fatalf("got %q, want the newest frame", got)
+	}
+
+	// 0 is the default, a negative value no limit
+	for opt, want := range map[int]int{0: DefaultMaxPendingFrames, 8: 8, -1: 0} {
+		r, err := NewReceiverWithOptions("239.255.77.2:"+freePort(t), ReceiverOptions{MaxPendingFrames: opt})
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Close()
+		if r.maxPending != want {
+			t.Errorf("MaxPendingFrames %d: limit %d, want %d", opt, r.maxPending, want)
+		}
+	}
+}
+
+func TestSourceSpecificJoin(t *testing.T) {
+	if _, err := NewReceiverWithOptions("232.1.2.3:"+freePort(t), ReceiverOptions{Source: "not-an-ip"}); err == nil {
+		t.Error("NewReceiverWithOptions accepted a bad source")
+	}
+
+	ifi := multicastInterface(t)
+	addr := "232.1.2.3:" + freePort(t)
+	own := interfaceIPv4(ifi)
+	for _, tc := range []struct {
+		source string
+		want   bool
+	}{{own.String(), true}, {"192.0.2.199", false}} {
+		r, err := NewReceiverWithOptions(addr, ReceiverOptions{Interface: ifi.Name, Source: tc.source})
+		if err != nil {
+			t.Fatalf("SSM join from %s: %v", tc.source, err)
+		}
+		s, err := NewSender(addr, ifi.Name, 1)
+		if err != nil {
+			r.Close()
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		for range 5 {
+			_ = s.SendFrame([]byte("hello"), 0, 1)
+		}
+		_, err = r.NextContext(ctx)
+		cancel()
+		s.Close()
+		r.Close()
+		if got := err == nil; got != tc.want {
+			t.Errorf("source %s: received = %v, want %v", tc.source, got, tc.want)
+		}
+	}
+}
+
+// solidJPEG encodes a w x h image of colour c.
+func solidJPEG(t *testing.T, w, h int, c color.RGBA) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(img, img.Rect, &image.Uniform{C: c}, image.Point{}, draw.Src)
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestRegionUpdates(t *testing.T) {
+	l, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer l.Close()
+	s, err := NewSenderWithOptions(l.LocalAddr().String(), SenderOptions{Compress: true})
+	if err != nil {
+		t.Fatalf("NewSender: %v", err)
+	}
+	defer s.Close()
+	r := &Receiver{logger: slog.Default(), frames: make(map[uint32]*assemblingFrame), out: make(chan received, 4)}
+	solid := func(w, h int, c color.RGBA) []byte { return solidJPEG(t, w, h, c) }
+	// relay reads one frame's fragments off the socket into r
+	buf := make([]byte, 2048)
+	relay := func() {
+		t.Helper()
+		for {
+			_ = l.SetReadDeadline(time.Now().Add(2 * time.Second))
+			n, _, err := l.ReadFromUDP(buf)
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			var h FragmentHeader
+			_ = h.Unmarshal(buf[:n])
+			r.handlePacket(buf[:n], nil)
+			if int(h.Index) == int(h.Total)-1 {
+				return
+			}
+		}
+	}
+	gray, red := color.RGBA{128, 128, 128, 255}, color.RGBA{255, 0, 0, 255}
+
+	if err := s.SendRegion(solid(16, 16, red), 16, 0, 90, 1200, 1); !errors.Is(err, ErrNoKeyframe) {
+		t.Fatalf("SendRegion before a keyframe: %v, want ErrNoKeyframe", err)
+	}
+	// a receiver that missed the keyframe can't use the region
+	if err := s.SendFrame(solid(48, 32, gray), 1200, 1); err != nil {
+		t.Fatal(err)
+	}
+	_, _, _ = l.ReadFromUDP(buf) // the keyframe fits one fragment
+	if err := s.SendRegion(solid(16, 16, red), 16, 0, 90, 1200, 1); err != nil {
+		t.Fatal(err)
+	}
+	relay()
+	if len(r.out) != 0 || r.Stats().StaleRegions != 1 {
+		t.Fatalf("region without its keyframe: delivered %d, stale %d", len(r.out), r.Stats().StaleRegions)
+	}
+
+	key := solid(48, 32, gray)
+	if err := s.SendFrame(key, 1200, 1); err != nil {
+		t.Fatal(err)
+	}
+	relay()
+	if got := (<-r.out).b; !bytes.Equal(got, key) {
+		t.Fatal("keyframe not delivered as sent")
+	}
+	if err := s.SendRegion(solid(16, 16, red), 16, 0, 90, 1200, 1); err != nil {
+		t.Fatal(err)
+	}
+	relay()
+	if len(r.out) != 1 {
+		t.Fatalf("region update not delivered (stats %+v)", r.Stats())
+	}
+	img, err := jpeg.Decode(bytes.NewReader((<-r.out).b))
+	if err != nil {
+		t.Fatalf("rebuilt frame: %v", err)
+	}
+	if img.Bounds() != image.Rect(0, 0, 48, 32) {
+		t.Fatalf("rebuilt frame is %v, want the keyframe's 48x32", img.Bounds())
+	}
+	for _, p := range []struct {
+		x, y int
+		red  bool
+	}{{4, 4, false}, {24, 8, true}, {40, 20, false}, {24, 24, false}} {
+		rr, g, _, _ := img.At(p.x, p.y).RGBA()
+		if isRed := rr>>8 > 200 && g>>8 < 60; isRed != p.red {
+			t.Errorf("pixel (%d,%d) = %v, red %v", p.x, p.y, img.At(p.x, p.y), p.red)
+		}
+	}
+	if got := r.Stats().Regions; got != 1 {
+		t.Errorf("regions = %d, want 1", got)
+	}
+}
+
+func TestJoinAllInterfaces(t *testing.T) {
+	if _, err := NewReceiverWithOptions("239.255.0.9:"+freePort(t), ReceiverOptions{Interface: "lo", JoinAllInterfaces: true}); err == nil {
+		t.Error("accepted both Interface and JoinAllInterfaces")
+	}
+	ifi := multicastInterface(t)
+	addr := "239.255.0.9:" + freePort(t)
+	r, err := NewReceiverWithOptions(addr, ReceiverOptions{JoinAllInterfaces: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if !slices.Contains(strings.Split(r.Interface(), ","), ifi.Name) {
+		t.Fatalf("joined on %q, want it to include %s", r.Interface(), ifi.Name)
+	}
+	s, err := NewSender(addr, ifi.Name, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	go func() {
+		for ctx.Err() == nil {
+			_ = s.SendFrame([]byte("hello"), 0, 1)
+			time.Sleep(50 * time.Millisecond)
+		}
+	}()
+	if _, err := r.NextContext(ctx); err != nil {
+		t.Fatalf("no frame on %s: %v", ifi.Name, err)
+	}
+}
+
+func TestSenderNoLoopback(t *testing.T) {
+	ifi := multicastInterface(t)
+	addr := "239.255.77.3:" + freePort(t)
+	r, err := NewReceiverWithOptions(addr, ReceiverOptions{Interface: ifi.Name})
+	if err != nil {
+		t.Skipf("cannot join %s on %s: %v", addr, ifi.Name, err)
+	}
+	defer r.Close()
+	s, err := NewSenderWithOptions(addr, SenderOptions{Interface: ifi.Name, NoLoopback: true})
+	if err != nil {
+		t.Fatalf("NewSender: %v", err)
+	}
+	defer s.Close()
+	for range 5 {
+		if err := s.SendFrame([]byte("echo"), 1200, 1); err != nil {
+			t.Skipf("cannot send to %s: %v", addr, err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	if _, err := r.NextContext(ctx); err == nil {
+		t.Fatal("received a frame from this host with loopback off")
+	}
+}
+
+func TestSendFrameContext(t *testing.T) {
+	l, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer l.Close()
+	s, err := NewSender(l.LocalAddr().String(), "", 1)
+	if err != nil {
+		t.Fatalf("NewSender: %v", err)
+	}
+	defer s.Close()
+
+	// 1000 fragments paced 1ms apart take at least a second to send
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = s.SendFrameContext(ctx, make([]byte, 100*1000), 100+FragmentHeaderSize, 1)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want DeadlineExceeded", err)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("send took %v after cancel", d)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if err := s.SendFrameContext(ctx, make([]byte, 300), 0, 1); !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled err = %v, want Canceled", err)
+	}
+}
+
+func TestLastFrameAge(t *testing.T) {
+	r := &Receiver{logger: slog.Default(), frames: make(map[uint32]*assemblingFrame), out: make(chan received, 4), started: time.Now().Add(-time.Minute)}
+	if !r.LastFrameTime().IsZero() {
+		t.Fatalf("LastFrameTime before any frame = %v", r.LastFrameTime())
+	}
+	if age := r.LastFrameAge(); age < time.Minute {
+		t.Fatalf("LastFrameAge before any frame = %v, want time since start", age)
+	}
+	// an incomplete frame doesn't count
+	r.handlePacket(makeFrag(1, 2, 0, []byte("ab")), nil)
+	if !r.LastFrameTime().IsZero() {
+		t.Fatal("incomplete frame set LastFrameTime")
+	}
+	r.handlePacket(makeFrag(1, 2, 1, []byte("cd")), nil)
+	if r.LastFrameTime().IsZero() {
+		t.Fatal("LastFrameTime not set by a complete frame")
+	}
+	if age := r.LastFrameAge(); age < 0 || age > time.Second {
+		t.Fatalf("LastFrameAge = %v", age)
+	}
+}
+
+func TestFrameDimensions(t *testing.T) {
+	l, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer l.Close()
+	s, err := NewSenderWithOptions(l.LocalAddr().String(), SenderOptions{Compress: true})
+	if err != nil {
+		t.Fatalf("NewSender: %v", err)
+	}
+	defer s.Close()
+	r := &Receiver{logger: slog.Default(), frames: make(map[uint32]*assemblingFrame), out: make(chan received, 4)}
+
+	frame := bytes.Repeat([]byte("frame "), 500)
+	buf := make([]byte, 2048)
+	for _, tc := range []struct {
+		w, h  int
+		flags uint8
+		want  FrameMeta
+	}{
+		{1920, 1080, FlagDims | FlagDeflate, FrameMeta{1920, 1080}},
+		{0, 0, FlagDeflate, FrameMeta{}},
+	} {
+		s.SetDimensions(tc.w, tc.h)
+		if err := s.SendFrame(frame, 1200, 1); err != nil {
+			t.Fatalf("SendFrame: %v", err)
+		}
+		for len(r.out) == 0 {
+			_ = l.SetReadDeadline(time.Now().Add(2 * time.Second))
+			n, _, err := l.ReadFromUDP(buf)
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			var h FragmentHeader
+			if err := h.Unmarshal(buf[:n]); err != nil || h.Flags != tc.flags {
+				t.Fatalf("%dx%d: header %+v, %v; want flags %#x", tc.w, tc.h, h, err, tc.flags)
+			}
+			r.handlePacket(buf[:n], nil)
+		}
+		got, meta, err := r.NextWithMeta()
+		if err != nil || !bytes.Equal(got, frame) || meta != tc.want {
+			t.Fatalf("%dx%d: NextWithMeta = %d bytes, %+v, %v", tc.w, tc.h, len(got), meta, err)
+		}
+	}
+}
+
+func TestSenderDSCP(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want int
+		ok   bool
+	}{{"af41", 34, true}, {"EF", 46, true}, {"cs1", 8, true}, {"10", 10, true}, {"64", 0, false}, {"af5", 0, false}} {
+		if got, err := ParseDSCP(tc.in); got != tc.want || (err == nil) != tc.ok {
+			t.Errorf("ParseDSCP(%q) = %d, %v", tc.in, got, err)
+		}
+	}
+
+	s, err := NewSenderWithOptions("127.0.0.1:"+freePort(t), SenderOptions{DSCP: 34})
+	if err != nil {
+		t.Fatalf("NewSender: %v", err)
+	}
+	defer s.Close()
+	if tos, err := s.pc.TOS(); err != nil || tos != 34<<2 {
+		t.Fatalf("TOS = %#x, %v; want %#x", tos, err, 34<<2)
+	}
+	if err := s.AddUnicastTarget("127.0.0.1:" + freePort(t)); err != nil {
+		t.Fatal(err)
+	}
+	if tos, err := ipv4.NewConn(s.ucast).TOS(); err != nil || tos != 34<<2 {
+		t.Fatalf("unicast TOS = %#x, %v; want %#x", tos, err, 34<<2)
+	}
+
+	for _, opts := range []SenderOptions{{TTL: 256}, {DSCP: 64}} {
+		if s, err := NewSenderWithOptions("127.0.0.1:"+freePort(t), opts); err == nil {
+			s.Close()
+			t.Errorf("NewSenderWithOptions(%+v) succeeded", opts)
+		}
+	}
+}
+
+func TestSelfTest(t *testing.T) {
+	ifi := multicastInterface(t)
+	addr := "239.255.77.9:" + freePort(t)
+	if err := SelfTest(addr, SenderOptions{Interface: ifi.Name, NoLoopback: true}, ReceiverOptions{Interface: ifi.Name}, 3*time.Second); err != nil {
+		t.Fatalf("SelfTest: %v", err)
+	}
+	if err := SelfTest("bad", SenderOptions{}, ReceiverOptions{}, time.Second); err == nil {
+		t.Fatal("SelfTest of a bad address passed")
+	}
+}
+
+func TestMaxBytesPerSec(t *testing.T) {
+	l, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer l.Close()
+	if _, err := NewSenderWithOptions(l.LocalAddr().String(), SenderOptions{MaxBytesPerSec: -1}); err == nil {
+		t.Error("negative MaxBytesPerSec accepted")
+	}
+
+	// the first second's worth goes out at once, the rest at the capped rate
+	const rate = 200_000
+	s, err := NewSenderWithOptions(l.LocalAddr().String(), SenderOptions{NoPacing: true, MaxBytesPerSec: rate})
+	if err != nil {
+		t.Fatalf("NewSender: %v", err)
+	}
+	defer s.Close()
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if err := s.SendFrame(make([]byte, 60_000), 1200, 1); err != nil {
+			t.Fatalf("SendFrame: %v", err)
+		}
+	}
+	if d := time.Since(start); d < 400*time.Millisecond || d > 3*time.Second {
+		t.Errorf("300 kB at %d B/s with a %d B burst took %v, want about 0.5s", rate, rate, d)
+	}
+
+	d, err := NewSenderWithOptions(l.LocalAddr().String(), SenderOptions{NoPacing: true, MaxBytesPerSec: rate, DropOverBudget: true})
+	if err != nil {
+		t.Fatalf("NewSender: %v", err)
+	}
+	defer d.Close()
+	if err := d.SendFrame(make([]byte, 150_000), 1200, 1); err != nil {
+		t.Fatalf("first frame: %v", err)
+	}
+	start = time.Now()
+	if err := d.SendFrame(make([]byte, 150_000), 1200, 1); !errors.Is(err, ErrOverBudget) {
+		t.Fatalf("second frame err = %v, want ErrOverBudget", err)
+	}
+	if time.Since(start) > 100*time.Millisecond {
+		t.Error("dropping a frame over budget blocked")
+	}
+	// a smaller frame fits what is left
+	if err := d.SendFrame(make([]byte, 20_000), 1200, 1); err != nil {
+		t.Errorf("small frame: %v", err)
+	}
+}
+
+func TestRegionAfterDroppedKeyframe(t *testing.T) {
+	l, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer l.Close()
+	s, err := NewSenderWithOptions(l.LocalAddr().String(), SenderOptions{NoPacing: true, MaxBytesPerSec: 200_000, DropOverBudget: true})
+	if err != nil {
+		t.Fatalf("NewSender: %v", err)
+	}
+	defer s.Close()
+	r := &Receiver{logger: slog.Default(), frames: make(map[uint32]*assemblingFrame), out: make(chan received, 4)}
+	buf := make([]byte, 2048)
+	relay := func() {
+		t.Helper()
+		_ = l.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, _, err := l.ReadFromUDP(buf)
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		r.handlePacket(buf[:n], nil)
+	}
+
+	if err := s.SendFrame(solidJPEG(t, 48, 32, color.RGBA{128, 128, 128, 255}), 1200, 1); err != nil {
+		t.Fatal(err)
+	}
+	relay() // one fragment
+	<-r.out
+	// a keyframe dropped for the budget never reaches receivers, so region
+	// updates must stay on the one before it
+	if err := s.SendFrame(make([]byte, 250_000), 1200, 1); !errors.Is(err, ErrOverBudget) {
+		t.Fatalf("big keyframe err = %v, want ErrOverBudget", err)
+	}
+	if err := s.SendRegion(solidJPEG(t, 16, 16, color.RGBA{255, 0, 0, 255}), 16, 0, 90, 1200, 1); err != nil {
+		t.Fatal(err)
+	}
+	relay()
+	if len(r.out) != 1 || r.Stats().StaleRegions != 0 {
+		t.Fatalf("region after a dropped keyframe: delivered %d, stale %d", len(r.out), r.Stats().StaleRegions)
+	}
+}
+
+func TestPendingFrames(t *testing.T) {
+	r := &Receiver{logger: slog.Default(), frames: make(map[uint32]*assemblingFrame), out: make(chan received, 4)}
+	if p := r.PendingFrames(); len(p) != 0 {
+		t.Fatalf("PendingFrames of a new receiver = %v", p)
+	}
+	r.handlePacket(makeFrag(1, 3, 0, []byte("ab")), nil)
+	r.handlePacket(makeFrag(1, 3, 2, []byte("ef")), nil)
+	time.Sleep(5 * time.Millisecond)
+	r.handlePacket(makeFrag(2, 4, 1, []byte("cd")), nil)
+	// frame 3 completes and is not pending
+	r.handlePacket(makeFrag(3, 1, 0, []byte("gh")), nil)
+
+	p := r.PendingFrames()
+	if len(p) != 2 {
+		t.Fatalf("PendingFrames = %+v, want frames 1 and 2", p)
+	}
+	if p[0].FrameID != 1 || p[0].Received != 2 || p[0].Total != 3 {
+		t.Errorf("oldest = %+v, want frame 1 with 2 of 3", p[0])
+	}
+	if p[1].FrameID != 2 || p[1].Received != 1 || p[1].Total != 4 {
+		t.Errorf("newest = %+v, want frame 2 with 1 of 4", p[1])
+	}
+	if p[0].Age < 5*time.Millisecond || p[0].Age < p[1].Age {
+		t.Errorf("ages %v, %v: want the oldest first, at least 5ms", p[0].Age, p[1].Age)
+	}
+}
+
+func TestSingleFragmentFrame(t *testing.T) {
+	r := &Receiver{logger: slog.Default(), frames: make(map[uint32]*assemblingFrame), out: make(chan received, 4)}
+	frag := makeFrag(7, 1, 0, []byte("small"))
+	// repeats of a single-fragment frame are delivered once
+	for i := 0; i < 3; i++ {
+		r.handlePacket(frag, nil)
+	}
+	if len(r.frames) != 0 {
+		t.Errorf("%d partial frames kept for a single-fragment frame", len(r.frames))
+	}
+	if len(r.out) != 1 {
+		t.Fatalf("%d frames delivered, want 1", len(r.out))
+	}
+	if got := (<-r.out).b; string(got) != "small" {
+		t.Errorf("delivered %q", got)
+	}
+	// a single-fragment frame whose ID is already being assembled is bogus
+	r.handlePacket(makeFrag(8, 2, 0, []byte("ab")), nil)
+	r.handlePacket(makeFrag(8, 1, 0, []byte("cd")), nil)
+	if len(r.out) != 0 || r.Stats().Invalid != 1 {
+		t.Errorf("conflicting fragment: %d delivered, %d invalid", len(r.out), r.Stats().Invalid)
+	}
+}
+
+// BenchmarkHandlePacket compares reassembly of a frame sent as one fragment,
+// which skips the partial-frame map, with one sent as two.
+func BenchmarkHandlePacket(b *testing.B) {
+	payload := make([]byte, 1000)
+	for _, total := range []uint16{1, 2} {
+		b.Run(fmt.Sprintf("fragments=%d", total), func(b *testing.B) {
+			r := &Receiver{logger: slog.Default(), frames: make(map[uint32]*assemblingFrame), out: make(chan received, 1)}
+			frags := make([][]byte, total)
+			b.SetBytes(int64(len(payload)) * int64(total))
+			b.ReportAllocs()
+			b.StopTimer()
+			for i := 0; i < b.N; i++ {
+				for j := range frags {
+					frags[j] = makeFrag(uint32(i), total, uint16(j), payload)
+				}
+				b.StartTimer()
+				for _, f := range frags {
+					r.handlePacket(f, nil)
+				}
+				<-r.out
+				b.StopTimer()
+			}
+		})
+	}
+}
+
+// BenchmarkSendSmallFrame sends frames that fit in one fragment, which go
+// out without the 1ms spacing between fragments.
+func BenchmarkSendSmallFrame(b *testing.B) {
+	l, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		b.Fatalf("listen: %v", err)
+	}
+	defer l.Close()
+	s, err := NewSender(l.LocalAddr().String(), "", 1)
+	if err != nil {
+		b.Fatalf("NewSender: %v", err)
+	}
+	defer s.Close()
+	frame := make([]byte, 1000)
+	for i := 0; i < b.N; i++ {
+		if err := s.SendFrame(frame, DefaultMTU, 1); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestEncryption(t *testing.T) {
+	for _, s := range []string{"", "00", "zz" + strings.Repeat("0", 30), strings.Repeat("0", 40)} {
+		if _, err := ParseKey(s); err == nil {
+			t.Errorf("ParseKey(%q) succeeded", s)
+		}
+	}
+	key, err := ParseKey(strings.Repeat("2b", 32))
+	if err != nil {
+		t.Fatalf("ParseKey: %v", err)
+	}
+	keyFile := t.TempDir() + "/key"
+	if err := os.WriteFile(keyFile, []byte(strings.Repeat("2b", 16)+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if k, err := ParseKey("@" + keyFile); err != nil || len(k) != 16 {
+		t.Errorf("ParseKey(@file) = %d bytes, %v", len(k), err)
+	}
+	other, _ := ParseKey(strings.Repeat("7e", 32))
+
+	l, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer l.Close()
+	if _, err := NewSenderWithOptions(l.LocalAddr().String(), SenderOptions{Key: []byte("short")}); err == nil {
+		t.Error("NewSender accepted a 5-byte key")
+	}
+	// sends frame with opts and returns the datagrams it went out as
+	capture := func(opts SenderOptions, frame []byte) [][]byte {
+		t.Helper()
+		s, err := NewSenderWithOptions(l.LocalAddr().String(), opts)
+		if err != nil {
+			t.Fatalf("NewSender: %v", err)
+		}
+		defer s.Close()
+		if err := s.SendFrame(frame, 1200, 1); err != nil {
+			t.Fatalf("SendFrame: %v", err)
+		}
+		var pkts [][]byte
+		buf := make([]byte, 2048)
+		for {
+			_ = l.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+			n, _, err := l.ReadFromUDP(buf)
+			if err != nil {
+				return pkts
+			}
+			pkts = append(pkts, bytes.Clone(buf[:n]))
+		}
+	}
+	frame := bytes.Repeat([]byte("confidential "), 300)
+	sealed := capture(SenderOptions{Key: key, Compress: true}, frame)
+	plain := capture(SenderOptions{}, frame)
+	for _, p := range sealed {
+		var h FragmentHeader
+		if err := h.Unmarshal(p); err != nil || h.Flags != FlagDeflate|FlagEncrypted {
+			t.Fatalf("header %+v, %v; want deflated and encrypted", h, err)
+		}
+		if bytes.Contains(p, []byte("confidential")) {
+			t.Fatal("plaintext on the wire")
+		}
+	}
+
+	for _, tc := range []struct {
+		name string
+		key  cipher.AEAD
+		pkts [][]byte
+		ok   bool
+	}{
+		{"right key", mustAEAD(t, key), sealed, true},
+		{"wrong key", mustAEAD(t, other), sealed, false},
+		{"no key", nil, sealed, false},
+		{"unencrypted with a key", mustAEAD(t, key), plain, false},
+		{"legacy datagram with a key", mustAEAD(t, key), [][]byte{{0, 0, 0, 2, 'h', 'i'}}, false},
+	} {
+		r := &Receiver{logger: slog.Default(), frames: make(map[uint32]*assemblingFrame), out: make(chan received, 4), aead: tc.key}
+		for _, p := range tc.pkts {
+			r.handlePacket(p, nil)
+		}
+		switch {
+		case tc.ok && (len(r.out) != 1 || !bytes.Equal((<-r.out).b, frame)):
+			t.Errorf("%s: frame not delivered intact", tc.name)
+		case !tc.ok && (len(r.out) != 0 || r.Stats().Rejected != 1):
+			t.Errorf("%s: %d delivered, %d rejected; want it rejected", tc.name, len(r.out), r.Stats().Rejected)
+		}
+	}
+}
+
+func mustAEAD(t *testing.T, key []byte) cipher.AEAD {
+	t.Helper()
+	a, err := newAEAD(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestWatchInterfaces(t *testing.T) {
+	var state atomic.Pointer[error] // nil while eth9 is up
+	up := func() { state.Store(nil) }
+	var rejoins atomic.Int32
+	r := &Receiver{logger: slog.Default(), iface: "eth9,eth8", stop: make(chan struct{}), started: time.Now(),
+		ifCheck: 5 * time.Millisecond,
+		ifState: func(name string) error {
+			if name != "eth9" {
+				return nil
+			}
+			if err := state.Load(); err != nil {
+				return *err
+			}
+			return nil
+		},
+		rejoin: func(name string) error {
+			if name != "eth9" {
+				t.Errorf("rejoined %s", name)
+			}
+			rejoins.Add(1)
+			return nil
+		}}
+	done := make(chan struct{})
+	go func() { r.watchInterfaces(); close(done) }()
+	defer func() { close(r.stop); <-done }()
+	waitFor := func(what string, ok func() bool) {
+		t.Helper()
+		for deadline := time.Now().Add(2 * time.Second); !ok(); time.Sleep(time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s", what)
+			}
+		}
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if d := r.DownInterfaces(); len(d) != 0 || rejoins.Load() != 0 {
+		t.Fatalf("all up: down %v, %d rejoins", d, rejoins.Load())
+	}
+	noLink := errors.New("no link")
+	state.Store(&noLink)
+	waitFor("eth9 down", func() bool { return slices.Equal(r.DownInterfaces(), []string{"eth9"}) })
+	if rejoins.Load() != 0 {
+		t.Error("rejoined while down")
+	}
+	up()
+	waitFor("eth9 back", func() bool { return len(r.DownInterfaces()) == 0 })
+	if n := rejoins.Load(); n != 1 {
+		t.Errorf("%d rejoins after coming back, want 1", n)
+	}
+}
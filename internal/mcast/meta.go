@@ -0,0 +1,89 @@
+package mcast
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+)
+
+// FlagDims marks a frame whose payload, once decrypted and inflated, if
+// FlagEncrypted and FlagDeflate are set, starts with the frame's dimensions
+// (big-endian):
+// 2 bytes width
+// 2 bytes height
+// followed by the frame as it would otherwise be sent (including any
+// FlagRegion header). See Sender.SetDimensions.
+const FlagDims = 0x04
+
+const dimsSize = 2 + 2
+
+// FrameMeta is what a Receiver knows about a frame besides its bytes.
+type FrameMeta struct {
+	// Width and Height are the pixel dimensions the sender announced with
+	// SetDimensions, or 0 if it didn't.
+	Width, Height int
+}
+
+// received is a complete frame on its way to Next.
+type received struct {
+	b    []byte
+	meta FrameMeta
+}
+
+// SetDimensions makes the sender announce every following frame as width x
+// height pixels (FlagDims), so receivers can size buffers or windows from
+// NextWithMeta without decoding the JPEG. 0 for either stops announcing.
+// Receivers older than FlagDims can't read such frames, so it is off by
+// default.
+func (s *Sender) SetDimensions(width, height int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if width <= 0 || height <= 0 || width > 0xffff || height > 0xffff {
+		s.width, s.height = 0, 0
+		return
+	}
+	s.width, s.height = width, height
+}
+
+// withDims prefixes b with the dimensions set by SetDimensions, if any.
+func (s *Sender) withDims(b []byte, flags uint8) ([]byte, uint8) {
+	s.mu.Lock()
+	w, h := s.width, s.height
+	s.mu.Unlock()
+	if w == 0 {
+		return b, flags
+	}
+	p := make([]byte, dimsSize+len(b))
+	binary.BigEndian.PutUint16(p[0:2], uint16(w))
+	binary.BigEndian.PutUint16(p[2:4], uint16(h))
+	copy(p[dimsSize:], b)
+	return p, flags | FlagDims
+}
+
+// cutDims splits the FlagDims prefix off b.
+func cutDims(b []byte) ([]byte, FrameMeta, bool) {
+	if len(b) < dimsSize {
+		return nil, FrameMeta{}, false
+	}
+	m := FrameMeta{Width: int(binary.BigEndian.Uint16(b[0:2])), Height: int(binary.BigEndian.Uint16(b[2:4]))}
+	return b[dimsSize:], m, true
+}
+
+// NextWithMeta is like Next but also returns what the sender announced
+// about the frame; its fields are 0 for frames sent without them.
+func (r *Receiver) NextWithMeta() ([]byte, FrameMeta, error) {
+	return r.next(context.Background())
+}
+
+// next is NextContext, with the frame's metadata.
+func (r *Receiver) next(ctx context.Context) ([]byte, FrameMeta, error) {
+	select {
+	case f, ok := <-r.out:
+		if !ok {
+			return nil, FrameMeta{}, fmt.Errorf("receiver closed")
+		}
+		return f.b, f.meta, nil
+	case <-ctx.Done():
+		return nil, FrameMeta{}, ctx.Err()
+	}
+}
@@ -0,0 +1,162 @@
+package mcast
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+)
+
+// NACK message layout (big-endian), sent over unicast by a Receiver to the
+// sender's NACK address when a frame is missing only a few fragments:
+// 1 byte type ('N')
+// 4 bytes frameID
+// 2 bytes count
+// count x 2 bytes fragmentIndex
+const (
+	nackType       = 'N'
+	nackHeaderSize = 1 + 4 + 2
+	// nackMaxMissing is the most fragments a frame may be missing for the
+	// receiver to ask for them; beyond that the frame is written off.
+	nackMaxMissing = 2
+	// nackCacheFrames is how many recent frames the sender keeps for
+	// retransmission.
+	nackCacheFrames = 32
+	// DefaultNACKDelay is how long a receiver waits after the last fragment
+	// of an incomplete frame before requesting the missing ones.
+	DefaultNACKDelay = 20 * time.Millisecond
+)
+
+func marshalNACK(frameID uint32, idx []uint16) []byte {
+	b := make([]byte, nackHeaderSize+2*len(idx))
+	b[0] = nackType
+	binary.BigEndian.PutUint32(b[1:5], frameID)
+	binary.BigEndian.PutUint16(b[5:7], uint16(len(idx)))
+	for i, x := range idx {
+		binary.BigEndian.PutUint16(b[nackHeaderSize+2*i:], x)
+	}
+	return b
+}
+
+func parseNACK(b []byte) (uint32, []uint16, error) {
+	if len(b) < nackHeaderSize || b[0] != nackType {
+		return 0, nil, errors.New("not a NACK")
+	}
+	n := int(binary.BigEndian.Uint16(b[5:7]))
+	if len(b) != nackHeaderSize+2*n {
+		return 0, nil, errors.New("truncated NACK")
+	}
+	idx := make([]uint16, n)
+	for i := range idx {
+		idx[i] = binary.BigEndian.Uint16(b[nackHeaderSize+2*i:])
+	}
+	return binary.BigEndian.Uint32(b[1:5]), idx, nil
+}
+
+// sentFrame is a recently sent frame kept for retransmission.
+type sentFrame struct {
+	id     uint32
+	frags  [][]byte
+	resent map[uint16]bool // each fragment is retransmitted at most once
+}
+
+// cacheFrame remembers frags for NACK retransmission, evicting the oldest
+// frame. mu must not be held.
+func (s *Sender) cacheFrame(id uint32, frags [][]byte) {
+	s.mu.Lock()
+	s.sent[s.sentNext] = &sentFrame{id: id, frags: frags, resent: make(map[uint16]bool)}
+	s.sentNext = (s.sentNext + 1) % len(s.sent)
+	s.mu.Unlock()
+}
+
+// nackLoop serves retransmission requests until the NACK socket is closed.
+// Missing fragments are resent to the group, so every receiver that lost
+// them benefits.
+func (s *Sender) nackLoop() {
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := s.nackConn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		id, idx, err := parseNACK(buf[:n])
+		if err != nil {
+			s.logger.Debug("bad NACK", "from", from, "err", err)
+			continue
+		}
+		var resend [][]byte
+		s.mu.Lock()
+		for _, f := range s.sent {
+			if f == nil || f.id != id {
+				continue
+			}
+			for _, i := range idx {
+				if int(i) < len(f.frags) && !f.resent[i] {
+					f.resent[i] = true
+					resend = append(resend, f.frags[i])
+				}
+			}
+		}
+		s.mu.Unlock()
+		s.logger.Debug("NACK", "from", from, "frame", id, "fragments", idx, "resent", len(resend))
+		for _, frag := range resend {
+			if s.budget != nil && !s.budget.tryTake(wireSize(len(frag))) {
+				s.logger.Debug("NACK: no bandwidth budget left, not retransmitting", "frame", id)
+				break
+			}
+			if _, err := s.conn.Write(frag); err != nil {
+				s.logger.Warn("retransmit failed", "err", err)
+				break
+			}
+			s.retransmits.Add(1)
+		}
+	}
+}
+
+// Retransmits returns how many fragments were resent in answer to NACKs.
+func (s *Sender) Retransmits() uint64 { return s.retransmits.Load() }
+
+// nackLoop periodically asks the sender for the fragments missing from
+// frames that are nearly complete and have gone quiet for nackDelay.
+func (r *Receiver) nackLoop() {
+	ticker := time.NewTicker(max(r.nackDelay/2, time.Millisecond))
+	defer ticker.Stop()
+	type request struct {
+		to  *net.UDPAddr
+		msg []byte
+	}
+	for {
+		select {
+		case <-r.stop:
+			return
+		case now := <-ticker.C:
+			var reqs []request
+			r.mu.Lock()
+			for id, af := range r.frames {
+				missing := int(af.total) - af.received
+				if af.nacked || af.src == nil || missing > nackMaxMissing || now.Sub(af.updated) < r.nackDelay {
+					continue
+				}
+				af.nacked = true
+				idx := make([]uint16, 0, missing)
+				for i := uint16(0); i < af.total; i++ {
+					if _, ok := af.parts[i]; !ok {
+						idx = append(idx, i)
+					}
+				}
+				reqs = append(reqs, request{&net.UDPAddr{IP: af.src, Port: r.nackPort}, marshalNACK(id, idx)})
+			}
+			r.mu.Unlock()
+			for _, q := range reqs {
+				if _, err := r.conn.WriteToUDP(q.msg, q.to); err != nil {
+					r.logger.Debug("NACK send failed", "to", q.to, "err", err)
+					continue
+				}
+				r.nacks.Add(1)
+			}
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package mcast
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrOverBudget is returned by SendFrame when SenderOptions.DropOverBudget
+// is set and the frame would exceed SenderOptions.MaxBytesPerSec. Nothing of
+// the frame was sent.
+var ErrOverBudget = errors.New("frame over the egress bandwidth budget")
+
+// tokenBucket caps egress at rate bytes per second, allowing bursts of up to
+// a second's worth (or one maximum-size datagram, if that is more).
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(bytesPerSec int) *tokenBucket {
+	rate := float64(bytesPerSec)
+	burst := max(rate, maxUDPPayload+ipUDPOverhead)
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// refill adds the tokens earned since the last call. b.mu must be held.
+func (b *tokenBucket) refill() {
+	now := time.Now()
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// tryTake takes n tokens if there are that many, and reports whether it did.
+func (b *tokenBucket) tryTake(n int) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill()
+	if b.tokens < float64(n) {
+		return false
+	}
+	b.tokens -= float64(n)
+	return true
+}
+
+// take waits until n tokens are available and takes them, or returns
+// ctx.Err() once ctx is done. n must not exceed the burst size.
+func (b *tokenBucket) take(ctx context.Context, n int) error {
+	for {
+		b.mu.Lock()
+		b.refill()
+		if b.tokens >= float64(n) {
+			b.tokens -= float64(n)
+			b.mu.Unlock()
+			return nil
+		}
+		wait := time.Duration((float64(n) - b.tokens) / b.rate * float64(time.Second))
+		b.mu.Unlock()
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// wireSize is what a datagram with a UDP payload of n bytes costs against
+// the budget: the payload plus IP and UDP headers.
+func wireSize(n int) int { return n + ipUDPOverhead }
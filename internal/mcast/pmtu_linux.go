@@ -0,0 +1,24 @@
+package mcast
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// setDontFragment sets DF on outgoing datagrams, so oversized fragments fail
+// with EMSGSIZE instead of being fragmented (or silently dropped) in the IP
+// layer.
+func setDontFragment(c *net.UDPConn) error {
+	rc, err := c.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var soErr error
+	if err := rc.Control(func(fd uintptr) {
+		soErr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_MTU_DISCOVER, unix.IP_PMTUDISC_DO)
+	}); err != nil {
+		return err
+	}
+	return soErr
+}
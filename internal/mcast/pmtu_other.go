@@ -0,0 +1,11 @@
+//go:build !linux
+
+package mcast
+
+import (
+	"errors"
+	"net"
+)
+
+// setDontFragment is only implemented on Linux (IP_MTU_DISCOVER).
+func setDontFragment(c *net.UDPConn) error { return errors.ErrUnsupported }
@@ -0,0 +1,100 @@
+package mcast
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/draw"
+	"image/jpeg"
+)
+
+// FlagRegion marks a frame that only updates a rectangle of the sender's
+// last keyframe (see Sender.SendRegion). Its payload, after inflating if
+// FlagDeflate is also set, starts with a region header (big-endian):
+// 4 bytes frameID of the keyframe
+// 2 bytes x
+// 2 bytes y
+// 1 byte JPEG quality to re-encode the rebuilt frame at
+// followed by the region as a JPEG, drawn over the keyframe at (x, y).
+const FlagRegion = 0x02
+
+const regionHeaderSize = 4 + 2 + 2 + 1
+
+// ErrNoKeyframe is returned by SendRegion before any full frame was sent.
+var ErrNoKeyframe = errors.New("mcast: region update without a keyframe")
+
+// SendRegion sends a JPEG of part of a frame, to be drawn at (x, y) over the
+// last frame sent with SendFrame, the keyframe. Receivers rebuild the whole
+// frame and hand it out re-encoded at quality, so Next never returns a bare
+// region; ones that missed the keyframe drop the update (see
+// Stats.StaleRegions). mtu and repeats are as for SendFrame.
+func (s *Sender) SendRegion(b []byte, x, y, quality int, mtu, repeats int) error {
+	s.mu.Lock()
+	key, ok := s.keyID, s.haveKey
+	s.mu.Unlock()
+	if !ok {
+		return ErrNoKeyframe
+	}
+	p := make([]byte, regionHeaderSize+len(b))
+	binary.BigEndian.PutUint32(p[0:4], key)
+	binary.BigEndian.PutUint16(p[4:6], uint16(x))
+	binary.BigEndian.PutUint16(p[6:8], uint16(y))
+	p[8] = uint8(min(max(quality, 1), 100))
+	copy(p[regionHeaderSize:], b)
+	return s.send(context.Background(), p, FlagRegion, mtu, repeats)
+}
+
+// keyframe is the last full frame a Receiver assembled, which region
+// updates are drawn over. It is only used from readLoop.
+type keyframe struct {
+	id  uint32
+	b   []byte      // as received
+	img *image.RGBA // decoded on the first region update, nil until then
+	out *image.RGBA // scratch for rebuilt frames
+}
+
+// compose draws the region update p over the keyframe and returns the whole
+// frame as a JPEG, or nil if the update is for a keyframe this receiver
+// doesn't have or can't be applied.
+func (r *Receiver) compose(p []byte) []byte {
+	k := &r.key
+	if len(p) < regionHeaderSize || k.b == nil || binary.BigEndian.Uint32(p[0:4]) != k.id {
+		r.staleRegions.Add(1)
+		return nil
+	}
+	at := image.Pt(int(binary.BigEndian.Uint16(p[4:6])), int(binary.BigEndian.Uint16(p[6:8])))
+	quality := int(p[8])
+	if k.img == nil {
+		img, err := jpeg.Decode(bytes.NewReader(k.b))
+		if err != nil {
+			r.logger.Debug("dropping undecodable keyframe", "frame", k.id, "err", err)
+			k.b = nil
+			r.staleRegions.Add(1)
+			return nil
+		}
+		k.img = image.NewRGBA(img.Bounds())
+		draw.Draw(k.img, k.img.Rect, img, img.Bounds().Min, draw.Src)
+		k.out = image.NewRGBA(k.img.Rect)
+	}
+	region, err := jpeg.Decode(bytes.NewReader(p[regionHeaderSize:]))
+	if err != nil {
+		r.staleRegions.Add(1)
+		return nil
+	}
+	dr := region.Bounds().Sub(region.Bounds().Min).Add(at)
+	if !dr.In(k.img.Rect) {
+		r.staleRegions.Add(1)
+		return nil
+	}
+	copy(k.out.Pix, k.img.Pix)
+	draw.Draw(k.out, dr, region, region.Bounds().Min, draw.Src)
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, k.out, &jpeg.Options{Quality: quality}); err != nil {
+		r.staleRegions.Add(1)
+		return nil
+	}
+	r.regions.Add(1)
+	return buf.Bytes()
+}
@@ -0,0 +1,66 @@
+package mcast
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// selfTestResend is how often SelfTest sends its frame again: the first
+// copy may go out before the group join has taken effect.
+const selfTestResend = 250 * time.Millisecond
+
+// SelfTest checks the whole path on this host: it joins addr with ropts,
+// sends a random multi-fragment frame to it with sopts, and returns nil once
+// that frame comes back intact, or an error saying how far it got after
+// timeout. Loopback is forced on for both ends and NACKs are off, so it can
+// run next to a live server; other frames on the group are ignored.
+func SelfTest(addr string, sopts SenderOptions, ropts ReceiverOptions, timeout time.Duration) error {
+	sopts.NoLoopback, ropts.NoLoopback = false, false
+	sopts.NACKListen, ropts.NACKPort = "", 0
+	r, err := NewReceiverWithOptions(addr, ropts)
+	if err != nil {
+		return fmt.Errorf("receiver: %w", err)
+	}
+	defer r.Close()
+	s, err := NewSenderWithOptions(addr, sopts)
+	if err != nil {
+		return fmt.Errorf("sender: %w", err)
+	}
+	defer s.Close()
+
+	want := make([]byte, 32<<10)
+	_, _ = rand.Read(want)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	for ctx.Err() == nil {
+		if err := s.SendFrame(want, 0, 1); err != nil {
+			return fmt.Errorf("send: %w", err)
+		}
+		attempt, done := context.WithTimeout(ctx, selfTestResend)
+		for {
+			b, err := r.NextContext(attempt)
+			if err != nil {
+				break
+			}
+			if bytes.Equal(b, want) {
+				done()
+				return nil
+			}
+		}
+		done()
+	}
+	st := r.Stats()
+	switch {
+	case st.Packets == 0:
+		err = errors.New("nothing arrived: multicast may be blocked by a firewall, disabled on the interface, or routed out of another one")
+	case st.Frames == 0:
+		err = fmt.Errorf("%d datagrams arrived but no frame completed: fragments are being lost", st.Packets)
+	default:
+		err = fmt.Errorf("%d other frames arrived but not the test frame", st.Frames)
+	}
+	return fmt.Errorf("no test frame back from %s within %v: %w", addr, timeout, err)
+}
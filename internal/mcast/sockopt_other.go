@@ -0,0 +1,16 @@
+//go:build !(aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || windows)
+
+package mcast
+
+import (
+	"errors"
+	"net"
+)
+
+// setReuse is a no-op on platforms without SO_REUSEADDR support here.
+func setReuse(fd uintptr) error { return nil }
+
+// readBufferSize is not supported on this platform.
+func readBufferSize(c *net.UDPConn) (int, error) {
+	return 0, errors.ErrUnsupported
+}
@@ -0,0 +1,36 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package mcast
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// setReuse sets SO_REUSEADDR and, best-effort, SO_REUSEPORT so several
+// receivers on the same host can bind the group port.
+func setReuse(fd uintptr) error {
+	if err := unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEADDR, 1); err != nil {
+		return err
+	}
+	// non-fatal; some kernels/sandboxes refuse SO_REUSEPORT
+	_ = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+	return nil
+}
+
+// readBufferSize reports the SO_RCVBUF the kernel actually granted.
+func readBufferSize(c *net.UDPConn) (int, error) {
+	rc, err := c.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	var soErr error
+	if err := rc.Control(func(fd uintptr) {
+		n, soErr = unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_RCVBUF)
+	}); err != nil {
+		return 0, err
+	}
+	return n, soErr
+}
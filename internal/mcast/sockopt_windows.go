@@ -0,0 +1,29 @@
+package mcast
+
+import (
+	"net"
+
+	"golang.org/x/sys/windows"
+)
+
+// setReuse sets SO_REUSEADDR so several receivers on the same host can bind
+// the group port; Windows has no SO_REUSEPORT.
+func setReuse(fd uintptr) error {
+	return windows.SetsockoptInt(windows.Handle(fd), windows.SOL_SOCKET, windows.SO_REUSEADDR, 1)
+}
+
+// readBufferSize reports the SO_RCVBUF the kernel actually granted.
+func readBufferSize(c *net.UDPConn) (int, error) {
+	rc, err := c.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	var soErr error
+	if err := rc.Control(func(fd uintptr) {
+		n, soErr = windows.GetsockoptInt(windows.Handle(fd), windows.SOL_SOCKET, windows.SO_RCVBUF)
+	}); err != nil {
+		return 0, err
+	}
+	return n, soErr
+}
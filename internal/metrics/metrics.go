@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	"math"
+	"time"
+)
+
+// RateEstimator tracks the bit rate of a stream of byte counts. It reports the
+// instantaneous rate over the gap since the previous sample and an
+// exponentially weighted moving average with time constant Tau, where each
+// sample's weight is derived from the real elapsed time (alpha = 1-exp(-dt/tau))
+// so irregular send intervals are handled correctly.
+type RateEstimator struct {
+	Tau time.Duration
+
+	last   time.Time
+	inst   float64 // bits per second
+	ewma   float64 // bits per second
+	primed bool
+}
+
+// NewRateEstimator returns an estimator smoothing over tau.
+func NewRateEstimator(tau time.Duration) *RateEstimator {
+	return &RateEstimator{Tau: tau}
+}
+
+// Add records n bytes sent at now and returns the instantaneous and smoothed
+// rates in Mbps. The first sample only establishes a time base and reports 0.
+func (r *RateEstimator) Add(n int, now time.Time) (instMbps, ewmaMbps float64) {
+	if r.last.IsZero() {
+		r.last = now
+		return 0, 0
+	}
+	dt := now.Sub(r.last).Seconds()
+	r.last = now
+	if dt <= 0 {
+		return r.inst / 1e6, r.ewma / 1e6
+	}
+	r.inst = float64(n) * 8 / dt
+	if !r.primed {
+		r.ewma = r.inst
+		r.primed = true
+	} else {
+		alpha := 1 - math.Exp(-dt/r.Tau.Seconds())
+		r.ewma = alpha*r.inst + (1-alpha)*r.ewma
+	}
+	return r.inst / 1e6, r.ewma / 1e6
+}
+
+// Mbps returns the latest instantaneous and smoothed rates without adding a sample.
+func (r *RateEstimator) Mbps() (instMbps, ewmaMbps float64) {
+	return r.inst / 1e6, r.ewma / 1e6
+}
@@ -0,0 +1,45 @@
+package metrics
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestRateEstimatorSteady(t *testing.T) {
+	r := NewRateEstimator(5 * time.Second)
+	t0 := time.Unix(0, 0)
+	if inst, ewma := r.Add(125000, t0); inst != 0 || ewma != 0 {
+		t.Fatalf("first sample = %v, %v; want 0, 0", inst, ewma)
+	}
+	// 125000 bytes every 200ms is 5 Mbps
+	var inst, ewma float64
+	for i := 1; i <= 50; i++ {
+		inst, ewma = r.Add(125000, t0.Add(time.Duration(i)*200*time.Millisecond))
+	}
+	if math.Abs(inst-5) > 1e-9 || math.Abs(ewma-5) > 1e-9 {
+		t.Fatalf("steady rate = %v, %v; want 5, 5", inst, ewma)
+	}
+}
+
+func TestRateEstimatorUsesRealDt(t *testing.T) {
+	t0 := time.Unix(0, 0)
+	// same rate (1 Mbps) in the second sample, different gaps
+	a := NewRateEstimator(5 * time.Second)
+	a.Add(0, t0)
+	a.Add(25000, t0.Add(200*time.Millisecond))
+	_, fast := a.Add(0, t0.Add(400*time.Millisecond))
+
+	b := NewRateEstimator(5 * time.Second)
+	b.Add(0, t0)
+	b.Add(25000, t0.Add(200*time.Millisecond))
+	_, slow := b.Add(0, t0.Add(5200*time.Millisecond))
+
+	// a zero-rate sample after 5s (one tau) must pull the average down much
+	// further than one after 200ms
+	wantFast := 1 * math.Exp(-0.2/5)
+	wantSlow := 1 * math.Exp(-1)
+	if math.Abs(fast-wantFast) > 1e-9 || math.Abs(slow-wantSlow) > 1e-9 {
+		t.Fatalf("ewma = %v (200ms), %v (5s); want %v, %v", fast, slow, wantFast, wantSlow)
+	}
+}